@@ -11,21 +11,28 @@
 package cli
 
 import (
+	"bytes"
 	"context"
 	"database/sql/driver"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/cli/clierrorplus"
+	"github.com/cockroachdb/cockroach/pkg/cli/cliflags"
 	"github.com/cockroachdb/cockroach/pkg/cli/clisqlclient"
 	"github.com/cockroachdb/cockroach/pkg/cli/clisqlexec"
 	"github.com/cockroachdb/cockroach/pkg/clusterversion"
 	"github.com/cockroachdb/cockroach/pkg/server/authserver"
 	"github.com/cockroachdb/cockroach/pkg/server/serverpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
+	"github.com/jackc/pgconn"
 	isatty "github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
 )
@@ -41,6 +48,16 @@ Example use of the session cookie using 'curl':
 
    curl -k -b "<cookie>" https://localhost:8080/_admin/v1/settings
 
+With --out, the cookie is written to the named file, readable only by
+its owner, instead of being printed on the standard output.
+
+With --tenant, the session is created in the named virtual cluster,
+in which the user must exist.
+
+The validity of the session is controlled by --expire-after, which
+cannot exceed the server.web_session.max_lifetime cluster setting,
+nor --max-lifetime if specified.
+
 The user invoking the 'login' CLI command must be an admin on the cluster.
 The user for which the HTTP session is opened can be arbitrary.
 `,
@@ -56,23 +73,36 @@ func runLogin(cmd *cobra.Command, args []string) error {
 	// without further normalization.
 	username := tree.Name(args[0]).Normalize()
 
-	id, httpCookie, err := createAuthSessionToken(username)
+	if authCtx.maxLifetime > 0 && authCtx.validityPeriod > authCtx.maxLifetime {
+		return errors.WithHintf(sessionLifetimeError(authCtx.maxLifetime),
+			"Use --%s to allow longer-lived sessions.", cliflags.AuthTokenMaxLifetime.Name)
+	}
+
+	id, httpCookie, expiration, err := createAuthSessionToken(username)
 	if err != nil {
 		return err
 	}
 	hC := httpCookie.String()
 
-	if authCtx.onlyCookie {
+	if authCtx.outFile != "" {
+		// Keep the cookie out of the standard output, where it could
+		// end up in shell history or logs.
+		if err := writeCookieFile(authCtx.outFile, hC); err != nil {
+			return err
+		}
+		fmt.Printf("authentication cookie for user %s (session ID %d) written to %s\n",
+			username, id, authCtx.outFile)
+	} else if authCtx.onlyCookie {
 		// Simple format suitable for automation.
 		fmt.Println(hC)
 	} else {
 		// More complete format, suitable e.g. for appending to a CSV file
 		// with --format=csv.
-		cols := []string{"username", "session ID", "authentication cookie"}
+		cols := []string{"username", "session ID", "authentication cookie", "expires at"}
 		rows := [][]string{
-			{username, fmt.Sprintf("%d", id), hC},
+			{username, fmt.Sprintf("%d", id), hC, expiration.UTC().Format(time.RFC3339)},
 		}
-		if err := sqlExecCtx.PrintQueryOutput(os.Stdout, stderr, cols, clisqlexec.NewRowSliceIter(rows, "ll")); err != nil {
+		if err := sqlExecCtx.PrintQueryOutput(os.Stdout, stderr, cols, clisqlexec.NewRowSliceIter(rows, "llll")); err != nil {
 			return err
 		}
 
@@ -91,13 +121,51 @@ func runLogin(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// writeCookieFile writes the given cookie to the named file, making
+// sure that the file is only readable by its owner.
+func writeCookieFile(path string, cookie string) (resErr error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() { resErr = errors.CombineErrors(resErr, f.Close()) }()
+	// The file may have existed prior with a more permissive mode.
+	if err := f.Chmod(0600); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(f, cookie)
+	return err
+}
+
+// authCLITestingKnobs are set when the auth-session commands are run
+// from a unit test.
+type authCLITestingKnobs struct {
+	// beforeSessionTxnAttempt, if set, is called at the start of every
+	// attempt of the transaction creating a session, e.g. to inject
+	// errors.
+	beforeSessionTxnAttempt func(ctx context.Context, conn clisqlclient.TxBoundConn) error
+}
+
+var authCLIKnobs authCLITestingKnobs
+
+// setAuthCLITestingKnobs installs the given testing knobs, and returns
+// a function restoring the defaults.
+func setAuthCLITestingKnobs(knobs authCLITestingKnobs) func() {
+	authCLIKnobs = knobs
+	return func() {
+		authCLIKnobs = authCLITestingKnobs{}
+	}
+}
+
 func createAuthSessionToken(
 	username string,
-) (sessionID int64, httpCookie *http.Cookie, resErr error) {
+) (sessionID int64, httpCookie *http.Cookie, expiration time.Time, resErr error) {
 	ctx := context.Background()
-	sqlConn, err := makeSQLClient(ctx, "cockroach auth-session login", useSystemDb)
+	// The session must be stored in the system.web_sessions table of
+	// the virtual cluster it is meant for, so connect to it directly.
+	sqlConn, err := makeTenantSQLClient(ctx, "cockroach auth-session login", useSystemDb, authCtx.tenantName)
 	if err != nil {
-		return -1, nil, err
+		return -1, nil, time.Time{}, err
 	}
 	defer func() { resErr = errors.CombineErrors(resErr, sqlConn.Close()) }()
 
@@ -109,22 +177,33 @@ func createAuthSessionToken(
 		false, /* showMoreChars */
 	)
 	if err != nil {
-		return -1, nil, err
+		return -1, nil, time.Time{}, err
 	}
 	if rows[0][0] != "1" {
-		return -1, nil, fmt.Errorf("user %q does not exist", username)
+		if authCtx.tenantName != userDefaultTenant {
+			return -1, nil, time.Time{}, fmt.Errorf("user %q does not exist in virtual cluster %q", username, authCtx.tenantName)
+		}
+		return -1, nil, time.Time{}, fmt.Errorf("user %q does not exist", username)
 	}
 
 	// Make a secret.
 	secret, hashedSecret, err := authserver.CreateAuthSecret()
 	if err != nil {
-		return -1, nil, err
+		return -1, nil, time.Time{}, err
 	}
-	expiration := timeutil.Now().Add(authCtx.validityPeriod)
+	expiration = timeutil.Now().Add(authCtx.validityPeriod)
 
-	// Create the session on the server to the server.
+	// Create the session on the server to the server. ExecTxn retries
+	// the transaction on retryable errors, e.g. serialization failures
+	// due to contention on system.web_sessions; other errors are
+	// returned immediately.
 	var id int64
 	err = sqlConn.ExecTxn(ctx, func(ctx context.Context, conn clisqlclient.TxBoundConn) error {
+		if fn := authCLIKnobs.beforeSessionTxnAttempt; fn != nil {
+			if err := fn(ctx, conn); err != nil {
+				return err
+			}
+		}
 		rows, err := conn.Query(ctx,
 			"SELECT crdb_internal.is_at_least_version($1)",
 			clusterversion.MinSupported.Version())
@@ -142,6 +221,17 @@ func createAuthSessionToken(
 		if !ok {
 			return errors.Newf("expected bool, got %T", row[0])
 		}
+		// The cap on the validity of sessions configured on the server
+		// applies to the sessions created here too.
+		maxLifetime, err := serverSessionMaxLifetime(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if maxLifetime > 0 && authCtx.validityPeriod > maxLifetime {
+			return errors.WithHintf(sessionLifetimeError(maxLifetime),
+				"The limit is configured via the %s cluster setting.",
+				authserver.WebSessionMaxLifetime.Name())
+		}
 		insertSessionStmt := `
 INSERT INTO system.web_sessions ("hashedSecret", username, "expiresAt")
 VALUES ($1, $2, $3)
@@ -161,7 +251,7 @@ RETURNING id
 			expiration,
 		)
 		if err != nil {
-			return err
+			return maybeExplainMissingWebSessions(err)
 		}
 		if err := rows.Next(row); err != nil {
 			return err
@@ -179,30 +269,91 @@ RETURNING id
 		return nil
 	})
 	if err != nil {
-		return -1, nil, err
+		return -1, nil, time.Time{}, err
 	}
 
 	// Spell out the cookie.
 	sCookie := &serverpb.SessionCookie{ID: id, Secret: secret}
 	httpCookie, err = authserver.EncodeSessionCookie(sCookie, false /* forHTTPSOnly */)
-	return id, httpCookie, err
+	return id, httpCookie, expiration, err
+}
+
+// sessionLifetimeError reports that the requested session validity
+// exceeds the given limit.
+func sessionLifetimeError(maxLifetime time.Duration) error {
+	return errors.Newf("--%s=%s exceeds the maximum session lifetime of %s",
+		cliflags.AuthTokenValidityPeriod.Name, authCtx.validityPeriod, maxLifetime)
+}
+
+// serverSessionMaxLifetime returns the maximum validity of sessions
+// configured via the server.web_session.max_lifetime cluster setting,
+// or 0 if it is unlimited or the server doesn't know the setting yet.
+func serverSessionMaxLifetime(
+	ctx context.Context, conn clisqlclient.TxBoundConn,
+) (time.Duration, error) {
+	rows, err := conn.Query(ctx,
+		"SELECT value FROM crdb_internal.cluster_settings WHERE variable = $1",
+		string(authserver.WebSessionMaxLifetime.Name()))
+	if err != nil {
+		return 0, err
+	}
+	row := make([]driver.Value, 1)
+	if err := rows.Next(row); err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, rows.Close()
+		}
+		return 0, errors.CombineErrors(err, rows.Close())
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+	value, ok := row[0].(string)
+	if !ok {
+		return 0, errors.Newf("expected string, got %T", row[0])
+	}
+	return time.ParseDuration(value)
+}
+
+// maybeExplainMissingWebSessions decorates the error returned when
+// the system.web_sessions table does not exist, which is the case on
+// clusters that haven't completed their upgrade to a version
+// supporting HTTP sessions. Other errors are returned as-is.
+func maybeExplainMissingWebSessions(err error) error {
+	if pgErr := (*pgconn.PgError)(nil); errors.As(err, &pgErr) {
+		if pgcode.MakeCode(pgErr.Code) == pgcode.UndefinedTable {
+			return errors.WithHint(
+				errors.Wrap(err, "cannot create HTTP session: the system.web_sessions table does not exist"),
+				"HTTP sessions require the cluster to be fully upgraded. "+
+					"Check the cluster version with SHOW CLUSTER SETTING version "+
+					"and make sure that any pending upgrade is finalized.")
+		}
+	}
+	return err
 }
 
 var logoutCmd = &cobra.Command{
-	Use:   "logout [options] <session-username>",
+	Use:   "logout [options] [<session-username>]",
 	Short: "invalidates all the HTTP session tokens previously created for the given user",
 	Long: `
 Revokes all previously issued HTTP authentication tokens for the given user.
 
+With --from-file, the tokens of all the users listed in the named file,
+one per line, are revoked instead. A failure to revoke the tokens of
+one user does not prevent the others from being revoked.
+
 The user invoking the 'login' CLI command must be an admin on the cluster.
 The user for which the HTTP sessions are revoked can be arbitrary.
 `,
-	Args: cobra.ExactArgs(1),
+	Args: cobra.MaximumNArgs(1),
 	RunE: clierrorplus.MaybeDecorateError(runLogout),
 }
 
 func runLogout(cmd *cobra.Command, args []string) (resErr error) {
-	username := tree.Name(args[0]).Normalize()
+	if (len(args) == 1) == (authCtx.logoutFromFile != "") {
+		return errors.Newf("exactly one of a username or --%s must be specified",
+			cliflags.AuthLogoutFromFile.Name)
+	}
+
 	ctx := context.Background()
 	sqlConn, err := makeSQLClient(ctx, "cockroach auth-session logout", useSystemDb)
 	if err != nil {
@@ -210,16 +361,71 @@ func runLogout(cmd *cobra.Command, args []string) (resErr error) {
 	}
 	defer func() { resErr = errors.CombineErrors(resErr, sqlConn.Close()) }()
 
-	logoutQuery := clisqlclient.MakeQuery(
+	if authCtx.logoutFromFile == "" {
+		username := tree.Name(args[0]).Normalize()
+		return sqlExecCtx.RunQueryAndFormatResults(
+			ctx,
+			sqlConn, os.Stdout, os.Stdout, stderr, makeLogoutQuery(username))
+	}
+
+	usernames, err := readLogoutUsernames(authCtx.logoutFromFile)
+	if err != nil {
+		return err
+	}
+	// Revoke the sessions of every user, reporting all the revoked
+	// sessions in a single table.
+	cols := []string{"username", "session ID", "revoked"}
+	var rows [][]string
+	var failed []string
+	for _, username := range usernames {
+		userCols, userRows, err := sqlExecCtx.RunQuery(ctx, sqlConn, makeLogoutQuery(username), false /* showMoreChars */)
+		if err != nil {
+			fmt.Fprintf(stderr, "warning: could not revoke the sessions of user %s: %v\n", username, err)
+			failed = append(failed, username)
+			continue
+		}
+		cols = userCols
+		rows = append(rows, userRows...)
+	}
+	if err := sqlExecCtx.PrintQueryOutput(os.Stdout, stderr, cols, clisqlexec.NewRowSliceIter(rows, "lrl")); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return errors.Newf("could not revoke the sessions of %d user(s): %s",
+			len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// makeLogoutQuery returns the query revoking the sessions of the
+// given user.
+func makeLogoutQuery(username string) clisqlclient.QueryFn {
+	return clisqlclient.MakeQuery(
 		`UPDATE system.web_sessions SET "revokedAt" = if("revokedAt"::timestamptz<now(),"revokedAt",now())
       WHERE username = $1
   RETURNING username,
             id AS "session ID",
             "revokedAt" AS "revoked"`,
 		username)
-	return sqlExecCtx.RunQueryAndFormatResults(
-		ctx,
-		sqlConn, os.Stdout, os.Stdout, stderr, logoutQuery)
+}
+
+// readLogoutUsernames reads the newline-separated list of usernames
+// in the named file. Blank lines are ignored.
+func readLogoutUsernames(path string) ([]string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var usernames []string
+	for _, line := range strings.Split(string(contents), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			usernames = append(usernames, tree.Name(line).Normalize())
+		}
+	}
+	if len(usernames) == 0 {
+		return nil, errors.Newf("no usernames found in %s", path)
+	}
+	return usernames, nil
 }
 
 var authListCmd = &cobra.Command{
@@ -228,6 +434,14 @@ var authListCmd = &cobra.Command{
 	Long: `
 Prints out the currently active HTTP sessions.
 
+In addition to the absolute creation and expiration timestamps, the
+"expires in" and "age" columns report the remaining validity and the
+age of each session as durations. Sessions past their expiration
+are reported as "expired".
+
+With --count, only the number of sessions is printed, which is
+cheaper than listing them on clusters with many sessions.
+
 The user invoking the 'list' CLI command must be an admin on the cluster.
 `,
 	Args: cobra.ExactArgs(0),
@@ -242,25 +456,152 @@ func runAuthList(cmd *cobra.Command, args []string) (resErr error) {
 	}
 	defer func() { resErr = errors.CombineErrors(resErr, sqlConn.Close()) }()
 
+	authListQuery := clisqlclient.MakeQuery(makeAuthListQuery(authCtx.listCount))
+	if authCtx.listCount {
+		_, rows, err := sqlExecCtx.RunQuery(ctx, sqlConn, authListQuery, false /* showMoreChars */)
+		if err != nil {
+			return err
+		}
+		fmt.Println(rows[0][0])
+		return nil
+	}
+	return sqlExecCtx.RunQueryAndFormatResults(
+		ctx,
+		sqlConn, os.Stdout, os.Stdout, stderr, authListQuery)
+}
+
+// authListFrom is the source of the sessions listed or counted by
+// 'auth-session list'. Any filtering of the sessions must be applied
+// here, so that it applies to both.
+const authListFrom = `FROM system.web_sessions AS w`
+
+// makeAuthListQuery returns the query listing the sessions or, if
+// count is set, counting them.
+func makeAuthListQuery(count bool) string {
+	if count {
+		return `SELECT count(*) ` + authListFrom
+	}
 	// TODO(yang): Change this to read the user_id directly from the table in 23.2.
-	authListQuery := clisqlclient.MakeQuery(`
+	return `
 SELECT username,
        (SELECT user_id FROM system.users AS u WHERE w.username = u.username) AS "user ID",
        id AS "session ID",
        "createdAt" as "created",
        "expiresAt" as "expires",
+       CASE
+         WHEN "expiresAt" <= now() THEN 'expired'
+         ELSE (date_trunc('second', "expiresAt") - date_trunc('second', now()))::STRING
+       END AS "expires in",
+       (date_trunc('second', now()) - date_trunc('second', "createdAt"))::STRING AS "age",
        "revokedAt" as "revoked",
        "lastUsedAt" as "last used"
-  FROM system.web_sessions AS w`)
-	return sqlExecCtx.RunQueryAndFormatResults(
-		ctx,
-		sqlConn, os.Stdout, os.Stdout, stderr, authListQuery)
+  ` + authListFrom
+}
+
+var authVerifyCmd = &cobra.Command{
+	Use:   "verify [options] <cookie>",
+	Short: "checks whether a HTTP session cookie is still valid",
+	Long: `
+Checks whether the given HTTP authentication cookie refers to an
+active session, and prints out the corresponding username and the
+status of the session.
+
+The cookie can be specified either as printed by the 'login' command
+(e.g. "session=...; Path=/; HttpOnly") or as a bare cookie value.
+
+The user invoking the 'verify' CLI command must be an admin on the cluster.
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: clierrorplus.MaybeDecorateError(runAuthVerify),
+}
+
+func runAuthVerify(cmd *cobra.Command, args []string) (resErr error) {
+	sCookie, err := authserver.DecodeSessionCookie(parseSessionCookie(args[0]))
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	sqlConn, err := makeSQLClient(ctx, "cockroach auth-session verify", useSystemDb)
+	if err != nil {
+		return err
+	}
+	defer func() { resErr = errors.CombineErrors(resErr, sqlConn.Close()) }()
+
+	row, err := sqlConn.QueryRow(ctx, "SELECT pg_has_role(current_user(), 'admin', 'MEMBER')")
+	if err != nil {
+		return err
+	}
+	if isAdmin, ok := row[0].(bool); !ok || !isAdmin {
+		return errors.New("only admin users can verify session cookies")
+	}
+
+	row, err = sqlConn.QueryRow(ctx, `
+SELECT "hashedSecret", username, "expiresAt" <= now(), "revokedAt" IS NOT NULL
+  FROM system.web_sessions
+ WHERE id = $1`, sCookie.ID)
+	if errors.Is(err, io.EOF) {
+		return errors.Newf("session %d does not exist", sCookie.ID)
+	}
+	if err != nil {
+		return err
+	}
+	hashedSecret, ok := row[0].([]byte)
+	if !ok {
+		return errors.Newf("expected bytes, got %T", row[0])
+	}
+	username, ok := row[1].(string)
+	if !ok {
+		return errors.Newf("expected string, got %T", row[1])
+	}
+	isExpired, ok := row[2].(bool)
+	if !ok {
+		return errors.Newf("expected bool, got %T", row[2])
+	}
+	isRevoked, ok := row[3].(bool)
+	if !ok {
+		return errors.Newf("expected bool, got %T", row[3])
+	}
+
+	// The checks below mirror those performed by the HTTP server when
+	// authenticating a request with the cookie.
+	status := "valid"
+	switch {
+	case !bytes.Equal(hashedSecret, authserver.HashAuthSecret(sCookie.Secret)):
+		status = "invalid secret"
+	case isRevoked:
+		status = "revoked"
+	case isExpired:
+		status = "expired"
+	}
+
+	cols := []string{"username", "session ID", "status"}
+	rows := [][]string{
+		{username, fmt.Sprintf("%d", sCookie.ID), status},
+	}
+	return sqlExecCtx.PrintQueryOutput(os.Stdout, stderr, cols, clisqlexec.NewRowSliceIter(rows, "lll"))
+}
+
+// parseSessionCookie extracts the session cookie from its string
+// representation. Both the format printed by 'auth-session login'
+// (e.g. "session=...; Path=/; HttpOnly") and the bare cookie value
+// are accepted.
+func parseSessionCookie(s string) *http.Cookie {
+	s = strings.TrimSpace(s)
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok && name == authserver.SessionCookieName {
+			return &http.Cookie{Name: name, Value: value}
+		}
+	}
+	return &http.Cookie{Name: authserver.SessionCookieName, Value: s}
 }
 
 var authCmds = []*cobra.Command{
 	loginCmd,
 	logoutCmd,
 	authListCmd,
+	authVerifyCmd,
 }
 
 var authCmd = &cobra.Command{
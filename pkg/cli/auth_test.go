@@ -0,0 +1,333 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package cli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cli/clisqlclient"
+	"github.com/cockroachdb/cockroach/pkg/server/authserver"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+// lastOutputLine returns the last non-empty line of the output of a
+// CLI test command.
+func lastOutputLine(out string) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	return lines[len(lines)-1]
+}
+
+// runLoginForTest runs 'auth-session login' for the root user with the
+// given extra arguments and returns the resulting cookie.
+func runLoginForTest(t *testing.T, c TestCLI, extraArgs ...string) string {
+	args := append([]string{"auth-session", "login", "root", "--only-cookie"}, extraArgs...)
+	out, err := c.RunWithCaptureArgs(args)
+	require.NoError(t, err)
+	cookie := lastOutputLine(out)
+	require.True(t, strings.HasPrefix(cookie, authserver.SessionCookieName+"="), "unexpected output: %s", out)
+	return cookie
+}
+
+func TestAuthSessionVerify(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	c := NewCLITest(TestCLIParams{T: t})
+	defer c.Cleanup()
+
+	verify := func(t *testing.T, cookie string) string {
+		out, err := c.RunWithCaptureArgs([]string{"auth-session", "verify", cookie})
+		require.NoError(t, err)
+		return lastOutputLine(out)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		cookie := runLoginForTest(t, c)
+		require.Regexp(t, `^root\t\d+\tvalid$`, verify(t, cookie))
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		cookie := runLoginForTest(t, c, "--expire-after=1ns")
+		require.Regexp(t, `^root\t\d+\texpired$`, verify(t, cookie))
+	})
+
+	t.Run("tampered secret", func(t *testing.T) {
+		cookie := runLoginForTest(t, c)
+		sCookie, err := authserver.DecodeSessionCookie(parseSessionCookie(cookie))
+		require.NoError(t, err)
+		sCookie.Secret[0] ^= 0xff
+		httpCookie, err := authserver.EncodeSessionCookie(sCookie, false /* forHTTPSOnly */)
+		require.NoError(t, err)
+		require.Regexp(t, `^root\t\d+\tinvalid secret$`, verify(t, httpCookie.String()))
+	})
+}
+
+func TestAuthSessionMaxLifetime(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	c := NewCLITest(TestCLIParams{T: t})
+	defer c.Cleanup()
+
+	t.Run("over cap", func(t *testing.T) {
+		out, err := c.RunWithCaptureArgs([]string{
+			"auth-session", "login", "root", "--only-cookie", "--expire-after=2h", "--max-lifetime=1h",
+		})
+		require.NoError(t, err)
+		require.Contains(t, out, "--expire-after=2h0m0s exceeds the maximum session lifetime of 1h0m0s")
+		require.NotContains(t, out, authserver.SessionCookieName+"=")
+	})
+
+	t.Run("at cap", func(t *testing.T) {
+		runLoginForTest(t, c, "--expire-after=1h", "--max-lifetime=1h")
+	})
+
+	t.Run("no client cap by default", func(t *testing.T) {
+		runLoginForTest(t, c, "--expire-after=87600h")
+	})
+
+	t.Run("server cap", func(t *testing.T) {
+		_, err := c.RunWithCaptureArgs([]string{"sql", "-e",
+			"SET CLUSTER SETTING server.web_session.max_lifetime = '1h'"})
+		require.NoError(t, err)
+		defer func() {
+			_, err := c.RunWithCaptureArgs([]string{"sql", "-e",
+				"RESET CLUSTER SETTING server.web_session.max_lifetime"})
+			require.NoError(t, err)
+		}()
+
+		out, err := c.RunWithCaptureArgs([]string{
+			"auth-session", "login", "root", "--only-cookie", "--expire-after=2h",
+		})
+		require.NoError(t, err)
+		require.Contains(t, out, "--expire-after=2h0m0s exceeds the maximum session lifetime of 1h0m0s")
+		require.Contains(t, out, "server.web_session.max_lifetime")
+		require.NotContains(t, out, authserver.SessionCookieName+"=")
+
+		runLoginForTest(t, c, "--expire-after=1h")
+	})
+}
+
+func TestAuthSessionLoginOutFile(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	c := NewCLITest(TestCLIParams{T: t})
+	defer c.Cleanup()
+
+	path := filepath.Join(t.TempDir(), "cookie.txt")
+	out, err := c.RunWithCaptureArgs([]string{"auth-session", "login", "root", "--out=" + path})
+	require.NoError(t, err)
+	require.Contains(t, lastOutputLine(out), "written to "+path)
+	require.NotContains(t, out, authserver.SessionCookieName+"=")
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	cookie := strings.TrimSpace(string(contents))
+	require.True(t, strings.HasPrefix(cookie, authserver.SessionCookieName+"="), "unexpected cookie: %s", cookie)
+	_, err = authserver.DecodeSessionCookie(parseSessionCookie(cookie))
+	require.NoError(t, err)
+}
+
+func TestMaybeExplainMissingWebSessions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	t.Run("missing table", func(t *testing.T) {
+		err := maybeExplainMissingWebSessions(&pgconn.PgError{
+			Code:    pgcode.UndefinedTable.String(),
+			Message: `relation "system.web_sessions" does not exist`,
+		})
+		require.ErrorContains(t, err, "cannot create HTTP session: the system.web_sessions table does not exist")
+		// The original error remains available to callers.
+		pgErr := (*pgconn.PgError)(nil)
+		require.True(t, errors.As(err, &pgErr))
+		require.Contains(t, errors.FlattenHints(err), "SHOW CLUSTER SETTING version")
+	})
+
+	t.Run("other error", func(t *testing.T) {
+		origErr := &pgconn.PgError{
+			Code:    pgcode.UniqueViolation.String(),
+			Message: "duplicate key value violates unique constraint",
+		}
+		require.Equal(t, error(origErr), maybeExplainMissingWebSessions(origErr))
+	})
+}
+
+func TestAuthSessionLoginTenant(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	c := NewCLITest(TestCLIParams{T: t})
+	defer c.Cleanup()
+
+	t.Run("system", func(t *testing.T) {
+		runLoginForTest(t, c, "--tenant=system")
+	})
+
+	t.Run("nonexistent", func(t *testing.T) {
+		// The connection is routed to the requested virtual cluster, which
+		// doesn't exist.
+		out, err := c.RunWithCaptureArgs([]string{
+			"auth-session", "login", "root", "--only-cookie", "--tenant=nonexistent",
+		})
+		require.NoError(t, err)
+		require.Regexp(t, `service unavailable for target tenant \(nonexistent\)`, out)
+		require.NotContains(t, out, authserver.SessionCookieName+"=")
+	})
+
+	t.Run("missing user", func(t *testing.T) {
+		out, err := c.RunWithCaptureArgs([]string{
+			"auth-session", "login", "nobody", "--only-cookie", "--tenant=system",
+		})
+		require.NoError(t, err)
+		require.Contains(t, out, `user "nobody" does not exist in virtual cluster "system"`)
+	})
+}
+
+func TestAuthSessionLoginRetry(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	c := NewCLITest(TestCLIParams{T: t})
+	defer c.Cleanup()
+
+	t.Run("retryable", func(t *testing.T) {
+		// Fail the first attempt with a serialization failure.
+		var attempts int
+		defer setAuthCLITestingKnobs(authCLITestingKnobs{
+			beforeSessionTxnAttempt: func(ctx context.Context, conn clisqlclient.TxBoundConn) error {
+				attempts++
+				if attempts == 1 {
+					return conn.Exec(ctx, "SELECT crdb_internal.force_retry('1h')")
+				}
+				return nil
+			},
+		})()
+		cookie := runLoginForTest(t, c)
+		require.Equal(t, 2, attempts)
+
+		out, err := c.RunWithCaptureArgs([]string{"auth-session", "verify", cookie})
+		require.NoError(t, err)
+		require.Regexp(t, `^root\t\d+\tvalid$`, lastOutputLine(out))
+	})
+
+	t.Run("non-retryable", func(t *testing.T) {
+		var attempts int
+		defer setAuthCLITestingKnobs(authCLITestingKnobs{
+			beforeSessionTxnAttempt: func(ctx context.Context, conn clisqlclient.TxBoundConn) error {
+				attempts++
+				return conn.Exec(ctx, "SELECT crdb_internal.force_error('XXUUU', 'boom')")
+			},
+		})()
+		out, err := c.RunWithCaptureArgs([]string{"auth-session", "login", "root", "--only-cookie"})
+		require.NoError(t, err)
+		require.Contains(t, out, "boom")
+		require.Equal(t, 1, attempts)
+	})
+}
+
+func TestAuthSessionLoginExpiration(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	c := NewCLITest(TestCLIParams{T: t})
+	defer c.Cleanup()
+
+	before := time.Now().Truncate(time.Second)
+	out, err := c.RunWithCaptureArgs([]string{
+		"auth-session", "login", "root", "--format=csv", "--expire-after=2h",
+	})
+	require.NoError(t, err)
+	after := time.Now()
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.GreaterOrEqual(t, len(lines), 2, "unexpected output: %s", out)
+	header, row := lines[len(lines)-2], lines[len(lines)-1]
+	require.Equal(t, "username,session ID,authentication cookie,expires at", header)
+	fields := strings.Split(row, ",")
+	expiresAt, err := time.Parse(time.RFC3339, fields[len(fields)-1])
+	require.NoError(t, err, "unexpected row: %s", row)
+	require.Equal(t, time.UTC, expiresAt.Location())
+	require.False(t, expiresAt.Before(before.Add(2*time.Hour)), "expiration %s too early", expiresAt)
+	require.False(t, expiresAt.After(after.Add(2*time.Hour)), "expiration %s too late", expiresAt)
+
+	// The cookie-only output is unchanged.
+	runLoginForTest(t, c)
+}
+
+func TestAuthSessionLogoutFromFile(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	c := NewCLITest(TestCLIParams{T: t})
+	defer c.Cleanup()
+
+	_, err := c.RunWithCaptureArgs([]string{"sql", "-e", "CREATE USER alice; CREATE USER bob"})
+	require.NoError(t, err)
+	cookies := make(map[string]string)
+	for _, user := range []string{"alice", "bob"} {
+		out, err := c.RunWithCaptureArgs([]string{"auth-session", "login", user, "--only-cookie"})
+		require.NoError(t, err)
+		cookies[user] = lastOutputLine(out)
+	}
+
+	path := filepath.Join(t.TempDir(), "users.txt")
+	require.NoError(t, os.WriteFile(path, []byte("alice\n\nBob\n"), 0644))
+	out, err := c.RunWithCaptureArgs([]string{"auth-session", "logout", "--from-file=" + path, "--format=tsv"})
+	require.NoError(t, err)
+	require.Contains(t, out, "username\tsession ID\trevoked")
+	for user, cookie := range cookies {
+		require.Regexp(t, "(?m)^"+user+`\t\d+\t\d{4}-`, out)
+
+		out, err := c.RunWithCaptureArgs([]string{"auth-session", "verify", cookie})
+		require.NoError(t, err)
+		require.Regexp(t, `^`+user+`\t\d+\trevoked$`, lastOutputLine(out))
+	}
+
+	t.Run("username and file", func(t *testing.T) {
+		out, err := c.RunWithCaptureArgs([]string{"auth-session", "logout", "alice", "--from-file=" + path})
+		require.NoError(t, err)
+		require.Contains(t, out, "exactly one of a username or --from-file must be specified")
+	})
+}
+
+func TestAuthSessionListCount(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// The count is computed over the same sessions as the listing.
+	require.Equal(t, "SELECT count(*) "+authListFrom, makeAuthListQuery(true /* count */))
+	require.True(t, strings.HasSuffix(makeAuthListQuery(false /* count */), authListFrom))
+
+	c := NewCLITest(TestCLIParams{T: t})
+	defer c.Cleanup()
+
+	for i := 0; i < 3; i++ {
+		runLoginForTest(t, c)
+	}
+	out, err := c.RunWithCaptureArgs([]string{"auth-session", "list", "--count"})
+	require.NoError(t, err)
+	require.Equal(t, "3", lastOutputLine(out))
+}
@@ -222,7 +222,18 @@ shifts to 100MiB when the first store is in-memory.
 	AuthTokenValidityPeriod = FlagInfo{
 		Name: "expire-after",
 		Description: `
-Duration after which the newly created session token expires.`,
+Duration after which the newly created session token expires.
+The duration cannot exceed the server.web_session.max_lifetime
+cluster setting, nor the value of --max-lifetime if specified.`,
+	}
+
+	AuthTokenMaxLifetime = FlagInfo{
+		Name: "max-lifetime",
+		Description: `
+Maximum duration accepted for --expire-after, on top of the
+server.web_session.max_lifetime cluster setting. This prevents the
+accidental creation of session tokens that remain valid for a very
+long time. Zero means no limit.`,
 	}
 
 	OnlyCookie = FlagInfo{
@@ -232,6 +243,35 @@ Display only the newly created cookie on the standard output
 without additional details and decoration.`,
 	}
 
+	AuthCookieOutFile = FlagInfo{
+		Name: "out",
+		Description: `
+Write the newly created cookie to the specified file, with permissions
+restricted to the file owner, instead of displaying it on the standard
+output.`,
+	}
+
+	AuthLogoutFromFile = FlagInfo{
+		Name: "from-file",
+		Description: `
+Revoke the sessions of all the users listed in the specified file,
+one username per line, instead of a single user given as argument.`,
+	}
+
+	AuthListCount = FlagInfo{
+		Name: "count",
+		Description: `
+Display only the number of sessions instead of listing them.`,
+	}
+
+	AuthSessionTenant = FlagInfo{
+		Name: "tenant",
+		Description: `
+Name of the virtual cluster (tenant) in which to create the session.
+The user must exist in that virtual cluster. By default, the session
+is created in the virtual cluster that the connection is routed to.`,
+	}
+
 	Cache = FlagInfo{
 		Name: "cache",
 		Description: `
@@ -428,6 +428,11 @@ func setDumpContextDefaults() {
 var authCtx struct {
 	onlyCookie     bool
 	validityPeriod time.Duration
+	maxLifetime    time.Duration
+	outFile        string
+	tenantName     string
+	logoutFromFile string
+	listCount      bool
 }
 
 // setAuthContextDefaults set the default values in authCtx.  This
@@ -436,6 +441,11 @@ var authCtx struct {
 func setAuthContextDefaults() {
 	authCtx.onlyCookie = false
 	authCtx.validityPeriod = 1 * time.Hour
+	authCtx.maxLifetime = 0
+	authCtx.outFile = ""
+	authCtx.tenantName = userDefaultTenant
+	authCtx.logoutFromFile = ""
+	authCtx.listCount = false
 }
 
 // debugCtx captures the command-line parameters of the `debug` command.
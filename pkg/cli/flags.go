@@ -651,7 +651,18 @@ func init() {
 	{
 		f := loginCmd.Flags()
 		cliflagcfg.DurationFlag(f, &authCtx.validityPeriod, cliflags.AuthTokenValidityPeriod)
+		cliflagcfg.DurationFlag(f, &authCtx.maxLifetime, cliflags.AuthTokenMaxLifetime)
 		cliflagcfg.BoolFlag(f, &authCtx.onlyCookie, cliflags.OnlyCookie)
+		cliflagcfg.StringFlag(f, &authCtx.outFile, cliflags.AuthCookieOutFile)
+		cliflagcfg.StringFlag(f, &authCtx.tenantName, cliflags.AuthSessionTenant)
+	}
+	{
+		f := logoutCmd.Flags()
+		cliflagcfg.StringFlag(f, &authCtx.logoutFromFile, cliflags.AuthLogoutFromFile)
+	}
+	{
+		f := authListCmd.Flags()
+		cliflagcfg.BoolFlag(f, &authCtx.listCount, cliflags.AuthListCount)
 	}
 
 	timeoutCmds := []*cobra.Command{
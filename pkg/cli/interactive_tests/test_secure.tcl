@@ -181,6 +181,20 @@ eexpect "cluster.organization"
 eexpect $prompt
 end_test
 
+start_test "Check that list reports the remaining validity and age of sessions."
+send "$argv auth-session login testuser --certs-dir=$certs_dir --expire-after=1s --only-cookie >/dev/null\r"
+eexpect $prompt
+# Let the testuser session expire.
+sleep 2
+send "$argv auth-session list --certs-dir=$certs_dir --format=csv\r"
+eexpect "expires in,age"
+# The root session is still active and reports its remaining validity.
+eexpect_re "root,\\d+,\\d+,\[^,\]*,\[^,\]*,00:5\\d:\\d\\d,\\d\\d:\\d\\d:\\d\\d,"
+# The testuser session has expired.
+eexpect_re "testuser,\\d+,\\d+,\[^,\]*,\[^,\]*,expired,00:00:0\\d,"
+eexpect $prompt
+end_test
+
 start_test "Check that env vars are reported in the node report"
 send "$python $pyfile cookie_root.txt 'https://localhost:8080/_status/nodes/1'> logs/db/node.txt\r"
 eexpect $prompt
@@ -37,6 +37,7 @@ var (
 	destroyAllMine        bool
 	destroyAllLocal       bool
 	extendLifetime        time.Duration
+	resetForce            bool
 	wipePreserveCerts     bool
 	grafanaConfig         string
 	grafanaArch           string
@@ -147,6 +148,9 @@ func initFlags() {
 	destroyCmd.Flags().BoolVarP(&destroyAllLocal,
 		"all-local", "l", false, "Destroy all local clusters")
 
+	resetCmd.Flags().BoolVar(&resetForce,
+		"force", false, "Start VMs which are stopped instead of failing to reset them (if supported by the provider)")
+
 	extendCmd.Flags().DurationVarP(&extendLifetime,
 		"lifetime", "l", 12*time.Hour, "Lifetime of the cluster")
 
@@ -800,7 +800,7 @@ var resetCmd = &cobra.Command{
 environments and will fall back to a no-op.`,
 	Args: cobra.ExactArgs(1),
 	Run: wrap(func(cmd *cobra.Command, args []string) (retErr error) {
-		return roachprod.Reset(config.Logger, args[0])
+		return roachprod.Reset(config.Logger, args[0], resetForce)
 	}),
 }
 
@@ -13,6 +13,8 @@ package rangefeed
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"io"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/kv/kvpb"
@@ -24,6 +26,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
 	"github.com/cockroachdb/errors"
 )
 
@@ -67,30 +70,139 @@ type CatchUpIterator struct {
 	close     func()
 	span      roachpb.Span
 	startTime hlc.Timestamp // exclusive
+	endTime   hlc.Timestamp // inclusive
 	pacer     *admission.Pacer
-	OnEmit    func(key, endKey roachpb.Key, ts hlc.Timestamp, vh enginepb.MVCCValueHeader)
+	// OnEmit, if set, is called right after every value and MVCC range
+	// tombstone is emitted, with its MVCC value header, e.g. to inspect the
+	// header fields which RangeFeedEvents don't carry.
+	OnEmit func(key, endKey roachpb.Key, ts hlc.Timestamp, vh enginepb.MVCCValueHeader)
+	// OnIntent, if set, is called for every intent within the time bounds,
+	// after the events of the preceding keys were emitted and ahead of the
+	// committed values of the intent's key. An error returned by it fails the
+	// scan. By default, intents are skipped. Intents are not surfaced as
+	// RangeFeedEvents, since rangefeed consumers don't expect them.
+	OnIntent func(key roachpb.Key, txnID uuid.UUID, ts hlc.Timestamp) error
+	// EmitCaughtUp, if set, makes CatchUpScan emit a checkpoint spanning the
+	// scanned span at the start time as its final event, once the scan
+	// completed successfully. This allows consumers to tell a completed scan
+	// apart from one that stopped emitting events. It is not emitted if the
+	// scan fails.
+	EmitCaughtUp bool
+	// LatestOnly, if set, makes CatchUpScan only emit the newest version of
+	// each key within the time bounds, rather than all of them, e.g. for
+	// consumers building a snapshot of the span. With withDiff, the previous
+	// value is that of the version preceding the newest one. Intents are
+	// reported and MVCC range tombstones are emitted as usual.
+	LatestOnly bool
+	// OnSkip, if set along with LatestOnly, is called once per key with the
+	// number of versions within the time bounds that were superseded by the
+	// newest one and thus not emitted, if any. This allows gauging the write
+	// amplification within the time bounds. When unset, the scan doesn't even
+	// step onto the superseded versions.
+	OnSkip func(key roachpb.Key, versions int)
+	// MaxValueBytes, if positive, caps the size of the data of the values
+	// loaded by CatchUpScan, including previous values with withDiff, to
+	// protect against memory spikes on keys with very large values. By
+	// default, a value exceeding it fails the scan.
+	MaxValueBytes int
+	// TruncateValues, if set along with MaxValueBytes, makes CatchUpScan
+	// truncate the data of the values exceeding MaxValueBytes instead of
+	// failing. The truncated values don't carry a checksum.
+	TruncateValues bool
+	// OnTruncate, if set, is called for every value truncated due to
+	// TruncateValues, with its original data size.
+	OnTruncate func(key roachpb.Key, ts hlc.Timestamp, valueBytes int)
+	// MaxKeys, if positive, makes CatchUpScan stop once it stepped over
+	// MaxKeys keys, ahead of the next key. The scan can then be continued from
+	// that key via ResumeToken.
+	MaxKeys int
+	// ResumeFrom, if set, makes CatchUpScan continue a previous scan of the
+	// same span and time bounds which stopped early, starting at the key of
+	// the token without re-emitting any of the preceding events.
+	ResumeFrom *ResumeToken
+	// resumeToken is set by CatchUpScan if it stopped early due to MaxKeys.
+	resumeToken *ResumeToken
+}
+
+// ResumeToken records where a catch-up scan which stopped early left off, so
+// that it can be continued via CatchUpIterator.ResumeFrom, possibly after a
+// process restart, see Encode and DecodeResumeToken.
+type ResumeToken struct {
+	// Key is the first key which the scan hasn't emitted events for.
+	Key roachpb.Key
+	// Timestamp is the (exclusive) start time of the scan.
+	Timestamp hlc.Timestamp
+}
+
+// Encode serializes the token.
+func (t ResumeToken) Encode() []byte {
+	return storage.EncodeMVCCKey(storage.MVCCKey{Key: t.Key, Timestamp: t.Timestamp})
+}
+
+// DecodeResumeToken deserializes a token serialized via Encode.
+func DecodeResumeToken(b []byte) (ResumeToken, error) {
+	k, err := storage.DecodeMVCCKey(b)
+	if err != nil {
+		return ResumeToken{}, errors.Wrap(err, "decoding resume token")
+	}
+	return ResumeToken{Key: k.Key.Clone(), Timestamp: k.Timestamp}, nil
+}
+
+// ResumeToken returns the token to continue the last scan from, if it stopped
+// early due to MaxKeys, or nil if it completed.
+func (i *CatchUpIterator) ResumeToken() *ResumeToken {
+	return i.resumeToken
 }
 
 // NewCatchUpIterator returns a CatchUpIterator for the given Reader over the
-// given key/time span. startTime is exclusive.
+// given key/time span. startTime is exclusive and endTime is inclusive; an
+// empty endTime means there is no upper bound.
 //
 // NB: startTime is exclusive, i.e. the first possible event will be emitted at
 // Timestamp.Next().
+//
+// Both time bounds are passed down to the engine as timestamp hints, which
+// allows it to skip blocks containing only versions outside of the window. If
+// the window is empty, i.e. endTime is at or below startTime, no engine
+// iterator is created at all and CatchUpScan returns without emitting events.
+// See CatchUpEndTime for fully caught-up rangefeeds.
+//
+// If inclusiveLowerBound is set, startTime is inclusive instead, i.e. versions
+// at exactly startTime are emitted too. The iterator then behaves as if
+// startTime.Prev() had been passed, e.g. as the timestamp of the checkpoint
+// emitted with EmitCaughtUp.
 func NewCatchUpIterator(
 	ctx context.Context,
 	reader storage.Reader,
 	span roachpb.Span,
 	startTime hlc.Timestamp,
+	endTime hlc.Timestamp,
+	inclusiveLowerBound bool,
 	closer func(),
 	pacer *admission.Pacer,
 ) (*CatchUpIterator, error) {
+	if endTime.IsEmpty() {
+		endTime = hlc.MaxTimestamp
+	}
+	if inclusiveLowerBound && !startTime.IsEmpty() {
+		startTime = startTime.Prev()
+	}
+	if endTime.LessEq(startTime) {
+		return &CatchUpIterator{
+			close:     closer,
+			span:      span,
+			startTime: startTime,
+			endTime:   endTime,
+			pacer:     pacer,
+		}, nil
+	}
 	iter, err := storage.NewMVCCIncrementalIterator(ctx, reader,
 		storage.MVCCIncrementalIterOptions{
 			KeyTypes:  storage.IterKeyTypePointsAndRanges,
 			StartKey:  span.Key,
 			EndKey:    span.EndKey,
 			StartTime: startTime,
-			EndTime:   hlc.MaxTimestamp,
+			EndTime:   endTime,
 			// We want to emit intents rather than error
 			// (the default behavior) so that we can skip
 			// over the provisional values during
@@ -106,14 +218,97 @@ func NewCatchUpIterator(
 		close:             closer,
 		span:              span,
 		startTime:         startTime,
+		endTime:           endTime,
 		pacer:             pacer,
 	}, nil
 }
 
+// CatchUpEndTime returns the endTime to pass to NewCatchUpIterator for a
+// catch-up scan starting at startTime over data whose versions are known to be
+// at or below latest. If no version can be above startTime, i.e. the rangefeed
+// is fully caught up, startTime itself is returned, which empties the time
+// window such that no engine iterator is created at all. Otherwise, an empty
+// timestamp is returned, i.e. the scan isn't bounded from above.
+//
+// Callers must only pass a true upper bound on the version timestamps as
+// latest, since the versions above it are silently skipped otherwise. In
+// particular, the LastUpdateNanos of the MVCC stats of a range is not one:
+// e.g. writes at future timestamps can be above it.
+//
+// N.B. with inclusiveLowerBound, the window then still covers the versions at
+// exactly startTime.
+func CatchUpEndTime(startTime, latest hlc.Timestamp) hlc.Timestamp {
+	if latest.LessEq(startTime) {
+		return startTime
+	}
+	return hlc.Timestamp{}
+}
+
+// NewCatchUpIteratorFromSnapshot is like NewCatchUpIterator, but scans the
+// given engine snapshot, as obtained from Engine.NewSnapshot, instead of the
+// live engine. The catch-up scan then observes a consistent, pinned view of
+// the engine, unaffected by writes that are concurrent with the scan.
+//
+// The caller owns the snapshot: it must remain open until the CatchUpIterator
+// is closed, and must be closed by the caller afterwards, e.g. in closer.
+func NewCatchUpIteratorFromSnapshot(
+	ctx context.Context,
+	snapshot storage.Reader,
+	span roachpb.Span,
+	startTime hlc.Timestamp,
+	endTime hlc.Timestamp,
+	closer func(),
+	pacer *admission.Pacer,
+) (*CatchUpIterator, error) {
+	if !snapshot.ConsistentIterators() {
+		return nil, errors.AssertionFailedf("catch-up scan reader %T is not a consistent snapshot", snapshot)
+	}
+	return NewCatchUpIterator(ctx, snapshot, span, startTime, endTime,
+		false /* inclusiveLowerBound */, closer, pacer)
+}
+
+// NewCatchUpIteratorFromIter is like NewCatchUpIterator, but wraps an existing
+// engine iterator instead of constructing one, for callers that already hold
+// a suitable iterator. The iterator must surface both point and range keys
+// (IterKeyTypePointsAndRanges) and intents, and its bounds must cover span.
+// They may be wider than span, in which case the scan is still limited to
+// span, and MVCC range tombstones are truncated to it.
+// The CatchUpIterator takes ownership of the iterator and closes it in Close.
+//
+// Unlike an incremental iterator, a plain MVCCIterator can't skip versions
+// outside of the (startTime, endTime] window, so these are filtered out during
+// the scan instead.
+func NewCatchUpIteratorFromIter(
+	iter storage.MVCCIterator,
+	span roachpb.Span,
+	startTime hlc.Timestamp,
+	endTime hlc.Timestamp,
+	inclusiveLowerBound bool,
+	closer func(),
+	pacer *admission.Pacer,
+) *CatchUpIterator {
+	if endTime.IsEmpty() {
+		endTime = hlc.MaxTimestamp
+	}
+	if inclusiveLowerBound && !startTime.IsEmpty() {
+		startTime = startTime.Prev()
+	}
+	return &CatchUpIterator{
+		simpleCatchupIter: simpleCatchupIterAdapter{SimpleMVCCIterator: iter},
+		close:             closer,
+		span:              span,
+		startTime:         startTime,
+		endTime:           endTime,
+		pacer:             pacer,
+	}
+}
+
 // Close closes the iterator and calls the instantiator-supplied close
 // callback.
 func (i *CatchUpIterator) Close() {
-	i.simpleCatchupIter.Close()
+	if i.simpleCatchupIter != nil {
+		i.simpleCatchupIter.Close()
+	}
 	i.pacer.Close()
 	if i.close != nil {
 		i.close()
@@ -137,11 +332,35 @@ type outputEventFn func(e *kvpb.RangeFeedEvent) error
 // keys a@6, a@4, and b@2, the emitted order is [a-f)@3,[a-f)@5,a@4,a@6,b@2 because
 // the start key "a" is ordered before all of the timestamped point keys.
 //
+// The highest timestamp of any committed version or MVCC range tombstone seen
+// by the scan is returned, which may be above the emitted versions (e.g. when
+// a version above the end time is encountered). It is empty if no versions
+// were seen, e.g. because the span is empty.
+//
 // TODO(sumeer): ctx is not used for SeekGE and Next. Fix by adding a method
 // to SimpleMVCCIterator to replace the context.
 func (i *CatchUpIterator) CatchUpScan(
 	ctx context.Context, outputFn outputEventFn, withDiff bool, withFiltering bool,
-) error {
+) (hlc.Timestamp, error) {
+	i.resumeToken = nil
+	if t := i.ResumeFrom; t != nil {
+		if t.Timestamp != i.startTime {
+			return hlc.Timestamp{}, errors.Errorf("resume token of a scan starting at %s "+
+				"can't resume a scan starting at %s", t.Timestamp, i.startTime)
+		}
+		if !i.span.ContainsKey(t.Key) {
+			return hlc.Timestamp{}, errors.Errorf("resume token key %s is outside of the scanned span %s",
+				t.Key, i.span)
+		}
+	}
+	// Fast-path for an empty time window, in which case there is nothing to
+	// emit (and NewCatchUpIterator didn't even create an iterator).
+	if i.simpleCatchupIter == nil || i.endTime.LessEq(i.startTime) {
+		if err := i.maybeEmitCaughtUp(outputFn); err != nil {
+			return hlc.Timestamp{}, err
+		}
+		return hlc.Timestamp{}, nil
+	}
 	var a bufalloc.ByteAllocator
 	// MVCCIterator will encounter historical values for each key in
 	// reverse-chronological order. To output in chronological order, store
@@ -149,32 +368,93 @@ func (i *CatchUpIterator) CatchUpScan(
 	// the encountered values in reverse. This also allows us to buffer events
 	// as we fill in previous values.
 	reorderBuf := make([]kvpb.RangeFeedEvent, 0, 5)
+	// reorderHeaders holds the MVCC value headers of the values in reorderBuf,
+	// if needed for OnEmit.
+	var reorderHeaders []enginepb.MVCCValueHeader
+
+	// output emits the given event, and then passes it to OnEmit along with the
+	// given MVCC value header. This is the single point at which events of
+	// values and MVCC range tombstones are emitted.
+	output := func(e *kvpb.RangeFeedEvent, vh enginepb.MVCCValueHeader) error {
+		if err := outputFn(e); err != nil {
+			return err
+		}
+		if i.OnEmit != nil {
+			switch t := e.GetValue().(type) {
+			case *kvpb.RangeFeedValue:
+				i.OnEmit(t.Key, nil, t.Value.Timestamp, vh)
+			case *kvpb.RangeFeedDeleteRange:
+				i.OnEmit(t.Span.Key, t.Span.EndKey, t.Timestamp, vh)
+			}
+		}
+		return nil
+	}
 
 	outputEvents := func() error {
-		for i := len(reorderBuf) - 1; i >= 0; i-- {
-			e := reorderBuf[i]
-			if err := outputFn(&e); err != nil {
+		for j := len(reorderBuf) - 1; j >= 0; j-- {
+			e := reorderBuf[j]
+			var vh enginepb.MVCCValueHeader
+			if i.OnEmit != nil {
+				vh = reorderHeaders[j]
+			}
+			if err := output(&e, vh); err != nil {
 				return err
 			}
-			reorderBuf[i] = kvpb.RangeFeedEvent{} // Drop references to values to allow GC
+			reorderBuf[j] = kvpb.RangeFeedEvent{} // Drop references to values to allow GC
 		}
 		reorderBuf = reorderBuf[:0]
+		reorderHeaders = reorderHeaders[:0]
 		return nil
 	}
 	// Iterate though all keys using Next. We want to publish all committed
 	// versions of each key that are after the registration's startTS, so we
 	// can't use NextKey.
 	var lastKey roachpb.Key
+	// skippedVersions is the number of versions of lastKey superseded with
+	// LatestOnly so far.
+	var skippedVersions int
+	reportSkipped := func() {
+		if skippedVersions > 0 {
+			i.OnSkip(lastKey, skippedVersions)
+			skippedVersions = 0
+		}
+	}
+	// lastSeenKey is the last key the scan stepped onto, and seenKeys the
+	// number of keys it stepped onto so far, with MaxKeys.
+	var lastSeenKey roachpb.Key
+	var seenKeys int
 	var meta enginepb.MVCCMetadata
-	i.SeekGE(storage.MVCCKey{Key: i.span.Key})
+	var highWater hlc.Timestamp
+	if i.ResumeFrom != nil {
+		i.SeekGE(storage.MVCCKey{Key: i.ResumeFrom.Key})
+	} else {
+		i.SeekGE(storage.MVCCKey{Key: i.span.Key})
+	}
 
 	every := log.Every(100 * time.Millisecond)
 	for {
 		if ok, err := i.Valid(); err != nil {
-			return err
+			return hlc.Timestamp{}, err
 		} else if !ok {
 			break
 		}
+		// A wrapped MVCCIterator may have wider bounds than the span, see
+		// NewCatchUpIteratorFromIter.
+		if i.UnsafeKey().Key.Compare(i.span.EndKey) >= 0 {
+			break
+		}
+
+		if i.MaxKeys > 0 {
+			if unsafeKey := i.UnsafeKey().Key; !bytes.Equal(unsafeKey, lastSeenKey) {
+				if seenKeys == i.MaxKeys {
+					// Stop ahead of this key, which the scan can be resumed from.
+					i.resumeToken = &ResumeToken{Key: unsafeKey.Clone(), Timestamp: i.startTime}
+					break
+				}
+				seenKeys++
+				a, lastSeenKey = a.Copy(unsafeKey, 0)
+			}
+		}
 
 		if err := i.pacer.Pace(ctx); err != nil {
 			// We're unable to pace things automatically -- shout loudly
@@ -196,26 +476,47 @@ func (i *CatchUpIterator) CatchUpScan(
 			if hasRange {
 				// Emit events for these MVCC range tombstones, in chronological order.
 				rangeKeys := i.RangeKeys()
+				// Truncate the bounds to the span, in case of a wrapped MVCCIterator
+				// with wider bounds.
+				bounds := rangeKeys.Bounds
+				if bounds.Key.Compare(i.span.Key) < 0 {
+					bounds.Key = i.span.Key
+				}
+				if bounds.EndKey.Compare(i.span.EndKey) > 0 {
+					bounds.EndKey = i.span.EndKey
+				}
 				for j := rangeKeys.Len() - 1; j >= 0; j-- {
-					var span roachpb.Span
-					a, span.Key = a.Copy(rangeKeys.Bounds.Key, 0)
-					a, span.EndKey = a.Copy(rangeKeys.Bounds.EndKey, 0)
 					ts := rangeKeys.Versions[j].Timestamp
-					err := outputFn(&kvpb.RangeFeedEvent{
-						DeleteRange: &kvpb.RangeFeedDeleteRange{
-							Span:      span,
-							Timestamp: ts,
-						},
-					})
-					if err != nil {
-						return err
+					highWater.Forward(ts)
+					// An incremental iterator has already filtered the versions by the
+					// time bounds, but a wrapped MVCCIterator hasn't.
+					if ts.LessEq(i.startTime) || i.endTime.Less(ts) {
+						continue
 					}
+					// MVCC range tombstones starting ahead of the resumed key were
+					// already emitted by the scan being resumed.
+					if i.ResumeFrom != nil && bounds.Key.Compare(i.ResumeFrom.Key) < 0 {
+						continue
+					}
+					var span roachpb.Span
+					a, span.Key = a.Copy(bounds.Key, 0)
+					a, span.EndKey = a.Copy(bounds.EndKey, 0)
+					var vh enginepb.MVCCValueHeader
 					if i.OnEmit != nil {
 						v, err := storage.DecodeMVCCValue(rangeKeys.Versions[j].Value)
 						if err != nil {
-							return err
+							return hlc.Timestamp{}, err
 						}
-						i.OnEmit(span.Key, span.EndKey, ts, v.MVCCValueHeader)
+						vh = v.MVCCValueHeader
+					}
+					err := output(&kvpb.RangeFeedEvent{
+						DeleteRange: &kvpb.RangeFeedDeleteRange{
+							Span:      span,
+							Timestamp: ts,
+						},
+					}, vh)
+					if err != nil {
+						return hlc.Timestamp{}, err
 					}
 				}
 			}
@@ -231,18 +532,18 @@ func (i *CatchUpIterator) CatchUpScan(
 		unsafeKey := i.UnsafeKey()
 		unsafeValRaw, err := i.UnsafeValue()
 		if err != nil {
-			return err
+			return hlc.Timestamp{}, err
 		}
 		if !unsafeKey.IsValue() {
 			// Found a metadata key.
 			if err := protoutil.Unmarshal(unsafeValRaw, &meta); err != nil {
-				return errors.Wrapf(err, "unmarshaling mvcc meta: %v", unsafeKey)
+				return hlc.Timestamp{}, errors.Wrapf(err, "unmarshaling mvcc meta: %v", unsafeKey)
 			}
 
 			// Inline values are unsupported by rangefeeds. MVCCIncrementalIterator
 			// should have errored on them already.
 			if meta.IsInline() {
-				return errors.AssertionFailedf("unexpected inline key %s", unsafeKey)
+				return hlc.Timestamp{}, errors.AssertionFailedf("unexpected inline key %s", unsafeKey)
 			}
 
 			// This is an MVCCMetadata key for an intent. The catchUp scan
@@ -257,14 +558,25 @@ func (i *CatchUpIterator) CatchUpScan(
 			i.NextIgnoringTime()
 
 			if ok, err := i.Valid(); err != nil {
-				return errors.Wrap(err, "iterating to provisional value for intent")
+				return hlc.Timestamp{}, errors.Wrap(err, "iterating to provisional value for intent")
 			} else if !ok {
-				return errors.Errorf("expected provisional value for intent")
+				return hlc.Timestamp{}, errors.Errorf("expected provisional value for intent")
 			}
 			if !meta.Timestamp.ToTimestamp().EqOrdering(i.UnsafeKey().Timestamp) {
-				return errors.Errorf("expected provisional value for intent with ts %s, found %s",
+				return hlc.Timestamp{}, errors.Errorf("expected provisional value for intent with ts %s, found %s",
 					meta.Timestamp, i.UnsafeKey().Timestamp)
 			}
+			if intentTS := meta.Timestamp.ToTimestamp(); i.OnIntent != nil &&
+				i.startTime.Less(intentTS) && intentTS.LessEq(i.endTime) {
+				// Flush the events of the previous key first, so that the intent is
+				// reported ahead of the committed values of its own key.
+				if err := outputEvents(); err != nil {
+					return hlc.Timestamp{}, err
+				}
+				if err := i.OnIntent(i.UnsafeKey().Key.Clone(), meta.Txn.ID, intentTS); err != nil {
+					return hlc.Timestamp{}, err
+				}
+			}
 			// Now move to the next key of interest. Note that if in the last
 			// iteration of the loop we called `NextIgnoringTime`, the fact that we
 			// hit an intent proves that there wasn't a previous value, so we can
@@ -276,13 +588,22 @@ func (i *CatchUpIterator) CatchUpScan(
 
 		mvccVal, err := storage.DecodeMVCCValue(unsafeValRaw)
 		if err != nil {
-			return errors.Wrapf(err, "decoding mvcc value: %v", unsafeKey)
+			return hlc.Timestamp{}, errors.Wrapf(err, "decoding mvcc value: %v", unsafeKey)
 		}
 		unsafeVal := mvccVal.Value.RawBytes
 
+		// A preceding call to NextIgnoringTime (in the with-diff case) may have
+		// moved onto a version of the next key above the (inclusive) end time.
+		// Skip to the first version of this key within the time bounds.
+		ts := unsafeKey.Timestamp
+		highWater.Forward(ts)
+		if i.endTime.Less(ts) {
+			i.Next()
+			continue
+		}
+
 		// Ignore the version if its timestamp is at or before the registration's
 		// (exclusive) starting timestamp.
-		ts := unsafeKey.Timestamp
 		ignore := ts.LessEq(i.startTime)
 		if ignore && !withDiff {
 			// Skip all the way to the next key.
@@ -296,8 +617,9 @@ func (i *CatchUpIterator) CatchUpScan(
 		if !sameKey {
 			// If so, output events for the last key encountered.
 			if err := outputEvents(); err != nil {
-				return err
+				return hlc.Timestamp{}, err
 			}
+			reportSkipped()
 			a, lastKey = a.Copy(unsafeKey.Key, 0)
 		}
 		key := lastKey
@@ -315,9 +637,16 @@ func (i *CatchUpIterator) CatchUpScan(
 		//   reorderBuf for which we need to set the previous
 		//   value.
 		if !ignore || (withDiff && len(reorderBuf) > 0) {
+			if i.MaxValueBytes > 0 && len(unsafeVal) > i.MaxValueBytes {
+				if unsafeVal, err = i.capValue(key, ts, mvccVal.Value); err != nil {
+					return hlc.Timestamp{}, err
+				}
+			}
 			var val []byte
 			a, val = a.Copy(unsafeVal, 0)
-			if withDiff {
+			// N.B. with LatestOnly, only the version preceding the newest one is
+			// its previous value; the versions counted for OnSkip are older.
+			if withDiff && skippedVersions == 0 {
 				// Update the last version with its previous value (this version).
 				if l := len(reorderBuf) - 1; l >= 0 {
 					// The previous value may have already been set by an event with
@@ -347,6 +676,18 @@ func (i *CatchUpIterator) CatchUpScan(
 			}
 
 			if !ignore {
+				if i.LatestOnly && len(reorderBuf) > 0 {
+					// A newer version of this key was already buffered, and has
+					// taken this version as its previous value above, so skip
+					// the rest of the key, or count its versions for OnSkip.
+					if i.OnSkip == nil {
+						i.NextKey()
+					} else {
+						skippedVersions++
+						i.Next()
+					}
+					continue
+				}
 				// Add value to reorderBuf to be output.
 				var event kvpb.RangeFeedEvent
 				event.MustSetValue(&kvpb.RangeFeedValue{
@@ -358,13 +699,15 @@ func (i *CatchUpIterator) CatchUpScan(
 				})
 				reorderBuf = append(reorderBuf, event)
 				if i.OnEmit != nil {
-					i.OnEmit(key, nil, ts, mvccVal.MVCCValueHeader)
+					reorderHeaders = append(reorderHeaders, mvccVal.MVCCValueHeader)
 				}
 			}
 		}
 
-		if ignore {
-			// Skip all the way to the next key.
+		if ignore || (i.LatestOnly && !withDiff && i.OnSkip == nil) {
+			// Skip all the way to the next key. With LatestOnly, only the newest
+			// version needs to be seen, unless withDiff requires its previous
+			// value.
 			i.NextKey()
 		} else {
 			// Move to the next version of this key (there may not be one, in which
@@ -380,5 +723,97 @@ func (i *CatchUpIterator) CatchUpScan(
 	}
 
 	// Output events for the last key encountered.
-	return outputEvents()
+	if err := outputEvents(); err != nil {
+		return hlc.Timestamp{}, err
+	}
+	reportSkipped()
+	if i.resumeToken != nil {
+		// The scan isn't complete yet.
+		return highWater, nil
+	}
+	if err := i.maybeEmitCaughtUp(outputFn); err != nil {
+		return hlc.Timestamp{}, err
+	}
+	return highWater, nil
+}
+
+// capValue returns the raw bytes of the given value of the given key, unless
+// its data exceeds MaxValueBytes, in which case it is either truncated or an
+// error is returned, depending on TruncateValues.
+func (i *CatchUpIterator) capValue(
+	key roachpb.Key, ts hlc.Timestamp, v roachpb.Value,
+) ([]byte, error) {
+	tagAndData := v.TagAndDataBytes()
+	valueBytes := len(tagAndData) - 1
+	if valueBytes <= i.MaxValueBytes {
+		return v.RawBytes, nil
+	}
+	if !i.TruncateValues {
+		return nil, errors.Errorf("value of key %s at %s is %d bytes, exceeding the limit of %d bytes",
+			key, ts, valueBytes, i.MaxValueBytes)
+	}
+	if i.OnTruncate != nil {
+		i.OnTruncate(key, ts, valueBytes)
+	}
+	var truncated roachpb.Value
+	truncated.SetTagAndData(tagAndData[:1+i.MaxValueBytes])
+	return truncated.RawBytes, nil
+}
+
+// CatchUpScanToWriter is like CatchUpScan, but writes the events to w rather
+// than emitting them via a callback, e.g. to pipe them to an external process.
+// Each event is written as a marshaled kvpb.RangeFeedEvent, prefixed by its
+// length as a uvarint. If w has a Flush method (e.g. a bufio.Writer), it is
+// flushed once all of the events were written.
+func (i *CatchUpIterator) CatchUpScanToWriter(
+	ctx context.Context, w io.Writer, withDiff bool, withFiltering bool,
+) (hlc.Timestamp, error) {
+	var buf []byte
+	highWater, err := i.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+		data, err := protoutil.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf = binary.AppendUvarint(buf[:0], uint64(len(data)))
+		buf = append(buf, data...)
+		return writeFull(w, buf)
+	}, withDiff, withFiltering)
+	if err != nil {
+		return hlc.Timestamp{}, err
+	}
+	if f, ok := w.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return hlc.Timestamp{}, errors.Wrap(err, "flushing catch-up scan events")
+		}
+	}
+	return highWater, nil
+}
+
+// writeFull writes all of p to w, retrying short writes.
+func writeFull(w io.Writer, p []byte) error {
+	for len(p) > 0 {
+		n, err := w.Write(p)
+		if err != nil {
+			return errors.Wrap(err, "writing catch-up scan event")
+		}
+		if n == 0 {
+			return errors.Wrap(io.ErrShortWrite, "writing catch-up scan event")
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+// maybeEmitCaughtUp emits the final checkpoint of a successful scan, if
+// EmitCaughtUp is set.
+func (i *CatchUpIterator) maybeEmitCaughtUp(outputFn outputEventFn) error {
+	if !i.EmitCaughtUp {
+		return nil
+	}
+	return outputFn(&kvpb.RangeFeedEvent{
+		Checkpoint: &kvpb.RangeFeedCheckpoint{
+			Span:       i.span,
+			ResolvedTS: i.startTime,
+		},
+	})
 }
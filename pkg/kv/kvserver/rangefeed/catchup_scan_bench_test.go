@@ -50,13 +50,14 @@ func runCatchUpBenchmark(b *testing.B, emk engineMaker, opts benchOptions) (numE
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		func() {
-			iter, err := rangefeed.NewCatchUpIterator(ctx, eng, span, opts.ts, nil, nil)
+			iter, err := rangefeed.NewCatchUpIterator(ctx, eng, span, opts.ts, hlc.Timestamp{},
+				false /* inclusiveLowerBound */, nil, nil)
 			if err != nil {
 				b.Fatal(err)
 			}
 			defer iter.Close()
 			counter := 0
-			err = iter.CatchUpScan(ctx, func(*kvpb.RangeFeedEvent) error {
+			_, err = iter.CatchUpScan(ctx, func(*kvpb.RangeFeedEvent) error {
 				counter++
 				return nil
 			}, opts.withDiff, false /* withFiltering */)
@@ -179,6 +180,102 @@ func BenchmarkCatchUpScan(b *testing.B) {
 	}
 }
 
+// BenchmarkCatchUpScanEmptyWindow measures the overhead of a catch-up scan of
+// a fully caught-up rangefeed, i.e. one with nothing to emit, by a caller
+// which knows an upper bound on the version timestamps: with the end time
+// derived from it via CatchUpEndTime, compared against an unbounded end time.
+func BenchmarkCatchUpScanEmptyWindow(b *testing.B) {
+	defer log.Scope(b).Close(b)
+
+	ctx := context.Background()
+	eng := storage.NewDefaultInMemForTesting()
+	defer eng.Close()
+
+	const numKeys = 10_000
+	for i := 0; i < numKeys; i++ {
+		key := roachpb.Key(encoding.EncodeUvarintAscending([]byte("key-"), uint64(i)))
+		ts := hlc.Timestamp{WallTime: int64(i + 1)}
+		_, err := storage.MVCCPut(ctx, eng, key, ts, roachpb.MakeValueFromString("val"), storage.MVCCWriteOptions{})
+		require.NoError(b, err)
+	}
+	require.NoError(b, eng.Flush())
+
+	span := roachpb.Span{Key: roachpb.KeyMin, EndKey: roachpb.KeyMax}
+	// The rangefeed is caught up to a timestamp above all of the versions.
+	startTime := hlc.Timestamp{WallTime: numKeys + 1}
+	latest := hlc.Timestamp{WallTime: numKeys}
+	for _, tc := range []struct {
+		name    string
+		endTime hlc.Timestamp
+	}{
+		{"unbounded", hlc.Timestamp{}},
+		{"bounded", rangefeed.CatchUpEndTime(startTime, latest)},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				iter, err := rangefeed.NewCatchUpIterator(ctx, eng, span, startTime, tc.endTime,
+					false /* inclusiveLowerBound */, nil, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := iter.CatchUpScan(ctx, func(*kvpb.RangeFeedEvent) error {
+					b.Fatal("unexpected event")
+					return nil
+				}, false /* withDiff */, false /* withFiltering */); err != nil {
+					b.Fatal(err)
+				}
+				iter.Close()
+			}
+		})
+	}
+}
+
+// BenchmarkCatchUpScanWithDiff measures the cost of loading previous values
+// in a catch-up scan over keys with many versions, most of which are below the
+// start time, comparing withDiff=true against withDiff=false.
+func BenchmarkCatchUpScanWithDiff(b *testing.B) {
+	defer log.Scope(b).Close(b)
+
+	ctx := context.Background()
+	eng := storage.NewDefaultInMemForTesting()
+	defer eng.Close()
+
+	const numKeys, numVersions = 1_000, 10
+	for v := 1; v <= numVersions; v++ {
+		for i := 0; i < numKeys; i++ {
+			key := roachpb.Key(encoding.EncodeUvarintAscending([]byte("key-"), uint64(i)))
+			ts := hlc.Timestamp{WallTime: int64(v)}
+			_, err := storage.MVCCPut(ctx, eng, key, ts, roachpb.MakeValueFromString("val"), storage.MVCCWriteOptions{})
+			require.NoError(b, err)
+		}
+	}
+	require.NoError(b, eng.Flush())
+
+	// Only the newest version of each key is emitted.
+	span := roachpb.Span{Key: roachpb.KeyMin, EndKey: roachpb.KeyMax}
+	startTime := hlc.Timestamp{WallTime: numVersions - 1}
+	for _, withDiff := range []bool{true, false} {
+		b.Run(fmt.Sprintf("withDiff=%v", withDiff), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				iter, err := rangefeed.NewCatchUpIterator(ctx, eng, span, startTime, hlc.Timestamp{},
+					false /* inclusiveLowerBound */, nil, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				counter := 0
+				if _, err := iter.CatchUpScan(ctx, func(*kvpb.RangeFeedEvent) error {
+					counter++
+					return nil
+				}, withDiff, false /* withFiltering */); err != nil {
+					b.Fatal(err)
+				}
+				iter.Close()
+				require.Equal(b, numKeys, counter)
+			}
+		})
+	}
+}
+
 type benchDataOptions struct {
 	numKeys        int
 	valueBytes     int
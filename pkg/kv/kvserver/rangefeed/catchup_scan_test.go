@@ -11,7 +11,13 @@
 package rangefeed
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/cockroachdb/cockroach/pkg/keys"
@@ -21,11 +27,14 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/storage"
 	"github.com/cockroachdb/cockroach/pkg/storage/enginepb"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/skip"
 	"github.com/cockroachdb/cockroach/pkg/util"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
 	"github.com/cockroachdb/cockroach/pkg/util/uuid"
+	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -156,15 +165,17 @@ func TestCatchupScan(t *testing.T) {
 		testutils.RunTrueAndFalse(t, "withDiff", func(t *testing.T, withDiff bool) {
 			testutils.RunTrueAndFalse(t, "withFiltering", func(t *testing.T, withFiltering bool) {
 				span := roachpb.Span{Key: testKey1, EndKey: roachpb.KeyMax}
-				iter, err := NewCatchUpIterator(ctx, eng, span, ts1, nil, nil)
+				iter, err := NewCatchUpIterator(ctx, eng, span, ts1, hlc.Timestamp{},
+					false /* inclusiveLowerBound */, nil, nil)
 				require.NoError(t, err)
 				defer iter.Close()
 				var events []kvpb.RangeFeedValue
 				// ts1 here is exclusive, so we do not want the versions at ts1.
-				require.NoError(t, iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+				_, err = iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
 					events = append(events, *e.Val)
 					return nil
-				}, withDiff, withFiltering))
+				}, withDiff, withFiltering)
+				require.NoError(t, err)
 				if !(withFiltering && omitInRangefeeds) {
 					require.Equal(t, 7, len(events))
 				} else {
@@ -214,11 +225,12 @@ func TestCatchupScanInlineError(t *testing.T) {
 
 	// Run a catchup scan across the span and watch it error.
 	span := roachpb.Span{Key: keys.LocalMax, EndKey: keys.MaxKey}
-	iter, err := NewCatchUpIterator(ctx, eng, span, hlc.Timestamp{}, nil, nil)
+	iter, err := NewCatchUpIterator(ctx, eng, span, hlc.Timestamp{}, hlc.Timestamp{},
+		false /* inclusiveLowerBound */, nil, nil)
 	require.NoError(t, err)
 	defer iter.Close()
 
-	err = iter.CatchUpScan(ctx, nil, false /* withDiff */, false /* withFiltering */)
+	_, err = iter.CatchUpScan(ctx, nil, false /* withDiff */, false /* withFiltering */)
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "unexpected inline value")
 }
@@ -258,17 +270,1050 @@ func TestCatchupScanSeesOldIntent(t *testing.T) {
 
 	// Run a catchup scan across the span and watch it succeed.
 	span := roachpb.Span{Key: keys.LocalMax, EndKey: keys.MaxKey}
-	iter, err := NewCatchUpIterator(ctx, eng, span, tsCutoff, nil, nil)
+	iter, err := NewCatchUpIterator(ctx, eng, span, tsCutoff, hlc.Timestamp{},
+		false /* inclusiveLowerBound */, nil, nil)
 	require.NoError(t, err)
 	defer iter.Close()
 
 	keys := map[string]struct{}{}
-	require.NoError(t, iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+	_, err = iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
 		keys[string(e.Val.Key)] = struct{}{}
 		return nil
-	}, true /* withDiff */, false /* withFiltering */))
+	}, true /* withDiff */, false /* withFiltering */)
+	require.NoError(t, err)
 	require.Equal(t, map[string]struct{}{
 		"b": {},
 		"e": {},
 	}, keys)
 }
+
+// TestCatchupScanOnIntent tests that intents within the time bounds are
+// reported via OnIntent, in order with the emitted events, only when it is set.
+func TestCatchupScanOnIntent(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	eng := storage.NewDefaultInMemForTesting(storage.If(smallEngineBlocks, storage.BlockSize(1)))
+	defer eng.Close()
+
+	// b -> version @ 1100
+	// d -> intent @ 1050, version @ 1010
+	// e -> version @ 1100
+	tsCutoff := hlc.Timestamp{WallTime: 1000}
+	tsVersion := tsCutoff.Add(10, 0)
+	tsIntent := tsCutoff.Add(50, 0)
+	tsLater := tsCutoff.Add(100, 0)
+
+	for _, kv := range []struct {
+		key string
+		ts  hlc.Timestamp
+	}{{"b", tsLater}, {"d", tsVersion}, {"e", tsLater}} {
+		_, err := storage.MVCCPut(ctx, eng, roachpb.Key(kv.key),
+			kv.ts, roachpb.MakeValueFromString("foo"), storage.MVCCWriteOptions{})
+		require.NoError(t, err)
+	}
+	txn := roachpb.MakeTransaction("foo", roachpb.Key("d"), isolation.Serializable, roachpb.NormalUserPriority, tsIntent, 100, 0, 0, false /* omitInRangefeeds */)
+	_, err := storage.MVCCPut(ctx, eng, roachpb.Key("d"),
+		tsIntent, roachpb.MakeValueFromString("intent"), storage.MVCCWriteOptions{Txn: &txn})
+	require.NoError(t, err)
+
+	span := roachpb.Span{Key: keys.LocalMax, EndKey: keys.MaxKey}
+	for _, emitIntents := range []bool{false, true} {
+		for _, withDiff := range []bool{false, true} {
+			t.Run(fmt.Sprintf("emitIntents=%t/withDiff=%t", emitIntents, withDiff), func(t *testing.T) {
+				iter, err := NewCatchUpIterator(ctx, eng, span, tsCutoff, hlc.Timestamp{},
+					false /* inclusiveLowerBound */, nil, nil)
+				require.NoError(t, err)
+				defer iter.Close()
+
+				var events []string
+				if emitIntents {
+					iter.OnIntent = func(key roachpb.Key, txnID uuid.UUID, ts hlc.Timestamp) error {
+						require.Equal(t, txn.ID, txnID)
+						events = append(events, fmt.Sprintf("intent %s@%s", key, ts))
+						return nil
+					}
+				}
+				_, err = iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+					switch ev := e.GetValue().(type) {
+					case *kvpb.RangeFeedValue:
+						events = append(events, fmt.Sprintf("val %s@%s", ev.Key, ev.Value.Timestamp))
+					default:
+						t.Fatalf("unexpected event %v", e)
+					}
+					return nil
+				}, withDiff, false /* withFiltering */)
+				require.NoError(t, err)
+
+				expected := []string{"val b@0.000001100,0", "val d@0.000001010,0", "val e@0.000001100,0"}
+				if emitIntents {
+					expected = []string{"val b@0.000001100,0", "intent d@0.000001050,0",
+						"val d@0.000001010,0", "val e@0.000001100,0"}
+				}
+				require.Equal(t, expected, events)
+			})
+		}
+	}
+}
+
+// TestCatchupScanEndTime tests that the catch-up scan only emits versions
+// within (startTime, endTime], and that the time bounds are passed down to the
+// engine such that blocks outside of them aren't read.
+func TestCatchupScanEndTime(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	skip.UnderMetamorphic(t, "time-bound iterators are randomly disabled in metamorphic builds")
+
+	ctx := context.Background()
+	// Force small blocks regardless of smallEngineBlocks, such that each block
+	// contains a single version and can be skipped if it's outside of the time
+	// bounds.
+	eng := storage.NewDefaultInMemForTesting(storage.BlockSize(1))
+	defer eng.Close()
+
+	// Key i has versions at timestamps 2i+1 and 2i+2.
+	const numKeys = 100
+	key := func(i int) roachpb.Key {
+		return roachpb.Key(fmt.Sprintf("/db%03d", i))
+	}
+	for i := 0; i < numKeys; i++ {
+		for _, wallTime := range []int64{int64(2*i + 1), int64(2*i + 2)} {
+			_, err := storage.MVCCPut(ctx, eng, key(i), hlc.Timestamp{WallTime: wallTime},
+				roachpb.MakeValueFromString("val"), storage.MVCCWriteOptions{})
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, eng.Flush())
+
+	span := roachpb.Span{Key: key(0), EndKey: roachpb.KeyMax}
+	startTime, endTime := hlc.Timestamp{WallTime: 20}, hlc.Timestamp{WallTime: 40}
+	scan := func(t *testing.T, endTime hlc.Timestamp, withDiff bool) ([]hlc.Timestamp, uint64) {
+		iter, err := NewCatchUpIterator(ctx, eng, span, startTime, endTime,
+			false /* inclusiveLowerBound */, nil, nil)
+		require.NoError(t, err)
+		defer iter.Close()
+		var timestamps []hlc.Timestamp
+		_, err = iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+			timestamps = append(timestamps, e.Val.Value.Timestamp)
+			return nil
+		}, withDiff, false /* withFiltering */)
+		require.NoError(t, err)
+		stats := iter.simpleCatchupIter.(*storage.MVCCIncrementalIterator).Stats()
+		return timestamps, stats.Stats.InternalStats.BlockBytes
+	}
+
+	testutils.RunTrueAndFalse(t, "withDiff", func(t *testing.T, withDiff bool) {
+		var expected []hlc.Timestamp
+		for wallTime := startTime.WallTime + 1; wallTime <= endTime.WallTime; wallTime++ {
+			expected = append(expected, hlc.Timestamp{WallTime: wallTime})
+		}
+		timestamps, boundedBlockBytes := scan(t, endTime, withDiff)
+		require.Equal(t, expected, timestamps)
+
+		_, unboundedBlockBytes := scan(t, hlc.Timestamp{}, withDiff)
+		require.Less(t, boundedBlockBytes, unboundedBlockBytes)
+	})
+}
+
+// TestCatchupScanInclusiveLowerBound tests that versions at exactly the start
+// time are only emitted when the lower bound is inclusive.
+func TestCatchupScanInclusiveLowerBound(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	eng := storage.NewDefaultInMemForTesting(storage.If(smallEngineBlocks, storage.BlockSize(1)))
+	defer eng.Close()
+
+	// Write versions at 1-3 to a, and a version at 2 to b.
+	startTime := hlc.Timestamp{WallTime: 2}
+	for wallTime := int64(1); wallTime <= 3; wallTime++ {
+		_, err := storage.MVCCPut(ctx, eng, roachpb.Key("a"), hlc.Timestamp{WallTime: wallTime},
+			roachpb.MakeValueFromString(fmt.Sprintf("a%d", wallTime)), storage.MVCCWriteOptions{})
+		require.NoError(t, err)
+	}
+	_, err := storage.MVCCPut(ctx, eng, roachpb.Key("b"), startTime,
+		roachpb.MakeValueFromString("b2"), storage.MVCCWriteOptions{})
+	require.NoError(t, err)
+
+	span := roachpb.Span{Key: roachpb.Key("a"), EndKey: roachpb.Key("z")}
+	testutils.RunTrueAndFalse(t, "withDiff", func(t *testing.T, withDiff bool) {
+		testutils.RunTrueAndFalse(t, "inclusiveLowerBound", func(t *testing.T, inclusive bool) {
+			iter, err := NewCatchUpIterator(ctx, eng, span, startTime, hlc.Timestamp{}, inclusive, nil, nil)
+			require.NoError(t, err)
+			defer iter.Close()
+
+			var values []string
+			_, err = iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+				value, err := e.Val.Value.GetBytes()
+				require.NoError(t, err)
+				values = append(values, string(value))
+				return nil
+			}, withDiff, false /* withFiltering */)
+			require.NoError(t, err)
+
+			if inclusive {
+				require.Equal(t, []string{"a2", "a3", "b2"}, values)
+			} else {
+				require.Equal(t, []string{"a3"}, values)
+			}
+		})
+	})
+}
+
+// TestCatchupScanLatestOnly tests that only the newest version of each key
+// within the time bounds is emitted with LatestOnly.
+func TestCatchupScanLatestOnly(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	eng := storage.NewDefaultInMemForTesting(storage.If(smallEngineBlocks, storage.BlockSize(1)))
+	defer eng.Close()
+
+	// Write testKey1 at ts1-3 and testKey2 at ts2. The scan starts above ts1.
+	testKey1, testKey2 := roachpb.Key("/db1"), roachpb.Key("/db2")
+	ts1, ts2, ts3 := hlc.Timestamp{WallTime: 1}, hlc.Timestamp{WallTime: 2}, hlc.Timestamp{WallTime: 3}
+	for _, kv := range []struct {
+		key roachpb.Key
+		ts  hlc.Timestamp
+	}{
+		{testKey1, ts1}, {testKey1, ts2}, {testKey1, ts3}, {testKey2, ts2},
+	} {
+		_, err := storage.MVCCPut(ctx, eng, kv.key, kv.ts,
+			roachpb.MakeValueFromString(fmt.Sprintf("%s@%d", kv.key, kv.ts.WallTime)), storage.MVCCWriteOptions{})
+		require.NoError(t, err)
+	}
+
+	span := roachpb.Span{Key: testKey1, EndKey: roachpb.KeyMax}
+	testutils.RunTrueAndFalse(t, "withDiff", func(t *testing.T, withDiff bool) {
+		testutils.RunTrueAndFalse(t, "latestOnly", func(t *testing.T, latestOnly bool) {
+			iter, err := NewCatchUpIterator(ctx, eng, span, ts1, hlc.Timestamp{},
+				false /* inclusiveLowerBound */, nil, nil)
+			require.NoError(t, err)
+			defer iter.Close()
+			iter.LatestOnly = latestOnly
+
+			var values, prevValues []string
+			_, err = iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+				value, err := e.Val.Value.GetBytes()
+				require.NoError(t, err)
+				values = append(values, string(value))
+				var prevValue []byte
+				if e.Val.PrevValue.IsPresent() {
+					prevValue, err = e.Val.PrevValue.GetBytes()
+					require.NoError(t, err)
+				}
+				prevValues = append(prevValues, string(prevValue))
+				return nil
+			}, withDiff, false /* withFiltering */)
+			require.NoError(t, err)
+
+			if latestOnly {
+				require.Equal(t, []string{"/db1@3", "/db2@2"}, values)
+				if withDiff {
+					require.Equal(t, []string{"/db1@2", ""}, prevValues)
+				}
+			} else {
+				require.Equal(t, []string{"/db1@2", "/db1@3", "/db2@2"}, values)
+				if withDiff {
+					require.Equal(t, []string{"/db1@1", "/db1@2", ""}, prevValues)
+				}
+			}
+		})
+	})
+}
+
+// TestCatchupScanLatestOnlySkipped tests that OnSkip reports the number of
+// versions of each key superseded with LatestOnly.
+func TestCatchupScanLatestOnlySkipped(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	eng := storage.NewDefaultInMemForTesting(storage.If(smallEngineBlocks, storage.BlockSize(1)))
+	defer eng.Close()
+
+	// Write a at 1-5, b at 3 and c at 2 and 4. The scan starts above 1, so
+	// three versions of a and one of c are superseded.
+	for _, kv := range []struct {
+		key      string
+		wallTime int64
+	}{
+		{"a", 1}, {"a", 2}, {"a", 3}, {"a", 4}, {"a", 5}, {"b", 3}, {"c", 2}, {"c", 4},
+	} {
+		_, err := storage.MVCCPut(ctx, eng, roachpb.Key(kv.key), hlc.Timestamp{WallTime: kv.wallTime},
+			roachpb.MakeValueFromString(fmt.Sprintf("%s%d", kv.key, kv.wallTime)), storage.MVCCWriteOptions{})
+		require.NoError(t, err)
+	}
+
+	span := roachpb.Span{Key: roachpb.Key("a"), EndKey: roachpb.Key("z")}
+	testutils.RunTrueAndFalse(t, "withDiff", func(t *testing.T, withDiff bool) {
+		iter, err := NewCatchUpIterator(ctx, eng, span, hlc.Timestamp{WallTime: 1}, hlc.Timestamp{},
+			false /* inclusiveLowerBound */, nil, nil)
+		require.NoError(t, err)
+		defer iter.Close()
+		iter.LatestOnly = true
+		skipped := make(map[string]int)
+		iter.OnSkip = func(key roachpb.Key, versions int) {
+			skipped[string(key)] += versions
+		}
+
+		var values, prevValues []string
+		_, err = iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+			value, err := e.Val.Value.GetBytes()
+			require.NoError(t, err)
+			values = append(values, string(value))
+			var prevValue []byte
+			if e.Val.PrevValue.IsPresent() {
+				prevValue, err = e.Val.PrevValue.GetBytes()
+				require.NoError(t, err)
+			}
+			prevValues = append(prevValues, string(prevValue))
+			return nil
+		}, withDiff, false /* withFiltering */)
+		require.NoError(t, err)
+
+		require.Equal(t, []string{"a5", "b3", "c4"}, values)
+		if withDiff {
+			require.Equal(t, []string{"a4", "", "c2"}, prevValues)
+		}
+		require.Equal(t, map[string]int{"a": 3, "c": 1}, skipped)
+	})
+}
+
+// TestCatchupScanMaxValueBytes tests that values exceeding MaxValueBytes
+// either fail the scan or are truncated, depending on TruncateValues.
+func TestCatchupScanMaxValueBytes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	eng := storage.NewDefaultInMemForTesting(storage.If(smallEngineBlocks, storage.BlockSize(1)))
+	defer eng.Close()
+
+	// Write a large value of testKey1 at ts1, overwritten by a small value at
+	// ts2, and a small value of testKey2 at ts2.
+	const maxValueBytes = 10
+	testKey1, testKey2 := roachpb.Key("/db1"), roachpb.Key("/db2")
+	ts1, ts2 := hlc.Timestamp{WallTime: 1}, hlc.Timestamp{WallTime: 2}
+	large := strings.Repeat("x", 1000)
+	for _, kv := range []struct {
+		key   roachpb.Key
+		ts    hlc.Timestamp
+		value string
+	}{
+		{testKey1, ts1, large}, {testKey1, ts2, "small"}, {testKey2, ts2, "small"},
+	} {
+		_, err := storage.MVCCPut(ctx, eng, kv.key, kv.ts,
+			roachpb.MakeValueFromString(kv.value), storage.MVCCWriteOptions{})
+		require.NoError(t, err)
+	}
+
+	span := roachpb.Span{Key: testKey1, EndKey: roachpb.KeyMax}
+	scan := func(
+		t *testing.T, startTime hlc.Timestamp, truncate bool, onTruncate func(roachpb.Key, hlc.Timestamp, int),
+	) (values, prevValues []string, _ error) {
+		iter, err := NewCatchUpIterator(ctx, eng, span, startTime, hlc.Timestamp{},
+			false /* inclusiveLowerBound */, nil, nil)
+		require.NoError(t, err)
+		defer iter.Close()
+		iter.MaxValueBytes = maxValueBytes
+		iter.TruncateValues = truncate
+		iter.OnTruncate = onTruncate
+		_, err = iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+			value, err := e.Val.Value.GetBytes()
+			require.NoError(t, err)
+			values = append(values, string(value))
+			var prevValue []byte
+			if e.Val.PrevValue.IsPresent() {
+				prevValue, err = e.Val.PrevValue.GetBytes()
+				require.NoError(t, err)
+			}
+			prevValues = append(prevValues, string(prevValue))
+			return nil
+		}, true /* withDiff */, false /* withFiltering */)
+		return values, prevValues, err
+	}
+
+	testutils.RunTrueAndFalse(t, "prevValue", func(t *testing.T, prevValue bool) {
+		// Start the scan at ts1 to only load the large value as a previous value.
+		var startTime hlc.Timestamp
+		if prevValue {
+			startTime = ts1
+		}
+
+		t.Run("error", func(t *testing.T) {
+			_, _, err := scan(t, startTime, false /* truncate */, nil /* onTruncate */)
+			require.ErrorContains(t, err, "is 1000 bytes, exceeding the limit of 10 bytes")
+		})
+
+		t.Run("truncate", func(t *testing.T) {
+			var truncated []string
+			values, prevValues, err := scan(t, startTime, true, /* truncate */
+				func(key roachpb.Key, ts hlc.Timestamp, valueBytes int) {
+					truncated = append(truncated, fmt.Sprintf("%s@%d:%d", string(key), ts.WallTime, valueBytes))
+				})
+			require.NoError(t, err)
+			require.Equal(t, []string{"/db1@1:1000"}, truncated)
+			if prevValue {
+				require.Equal(t, []string{"small", "small"}, values)
+				require.Equal(t, []string{large[:maxValueBytes], ""}, prevValues)
+			} else {
+				require.Equal(t, []string{large[:maxValueBytes], "small", "small"}, values)
+				require.Equal(t, []string{"", large[:maxValueBytes], ""}, prevValues)
+			}
+		})
+	})
+}
+
+// TestCatchupScanValueHeader tests that the MVCC value header of each emitted
+// value and MVCC range tombstone is surfaced via OnEmit, right after the event
+// is emitted, and only for the events which are actually emitted.
+func TestCatchupScanValueHeader(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	eng := storage.NewDefaultInMemForTesting(storage.If(smallEngineBlocks, storage.BlockSize(1)))
+	defer eng.Close()
+
+	testKey := roachpb.Key("/db1")
+	for i, omit := range []bool{false, true, false} {
+		_, err := storage.MVCCPut(ctx, eng, testKey, hlc.Timestamp{WallTime: int64(i + 1)},
+			roachpb.MakeValueFromString("val"), storage.MVCCWriteOptions{OmitInRangefeeds: omit})
+		require.NoError(t, err)
+	}
+	require.NoError(t, storage.MVCCDeleteRangeUsingTombstone(ctx, eng, nil,
+		roachpb.Key("/db2"), roachpb.Key("/db3"), hlc.Timestamp{WallTime: 4}, hlc.ClockTimestamp{},
+		nil, nil, false, 0, nil))
+
+	span := roachpb.Span{Key: testKey, EndKey: roachpb.KeyMax}
+	testutils.RunTrueAndFalse(t, "latestOnly", func(t *testing.T, latestOnly bool) {
+		iter, err := NewCatchUpIterator(ctx, eng, span, hlc.Timestamp{}, hlc.Timestamp{},
+			false /* inclusiveLowerBound */, nil, nil)
+		require.NoError(t, err)
+		defer iter.Close()
+		iter.LatestOnly = latestOnly
+		var events []string
+		iter.OnEmit = func(key, _ roachpb.Key, ts hlc.Timestamp, vh enginepb.MVCCValueHeader) {
+			events = append(events, fmt.Sprintf("header %s@%d omit=%t", string(key), ts.WallTime, vh.OmitInRangefeeds))
+		}
+		_, err = iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+			switch t := e.GetValue().(type) {
+			case *kvpb.RangeFeedValue:
+				events = append(events, fmt.Sprintf("val %s@%d", string(t.Key), t.Value.Timestamp.WallTime))
+			case *kvpb.RangeFeedDeleteRange:
+				events = append(events, fmt.Sprintf("del %s@%d", string(t.Span.Key), t.Timestamp.WallTime))
+			}
+			return nil
+		}, false /* withDiff */, false /* withFiltering */)
+		require.NoError(t, err)
+		expected := []string{
+			"val /db1@1", "header /db1@1 omit=false",
+			"val /db1@2", "header /db1@2 omit=true",
+			"val /db1@3", "header /db1@3 omit=false",
+			"del /db2@4", "header /db2@4 omit=false",
+		}
+		if latestOnly {
+			// The superseded versions are neither emitted nor passed to OnEmit.
+			expected = expected[4:]
+		}
+		require.Equal(t, expected, events)
+	})
+}
+
+// TestCatchupScanEmptyWindow tests that a catch-up scan over an empty time
+// window emits nothing without creating an engine iterator.
+func TestCatchupScanEmptyWindow(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	eng := storage.NewDefaultInMemForTesting(storage.If(smallEngineBlocks, storage.BlockSize(1)))
+	defer eng.Close()
+
+	testKey := roachpb.Key("/db1")
+	for wallTime := int64(1); wallTime <= 3; wallTime++ {
+		_, err := storage.MVCCPut(ctx, eng, testKey, hlc.Timestamp{WallTime: wallTime},
+			roachpb.MakeValueFromString("val"), storage.MVCCWriteOptions{})
+		require.NoError(t, err)
+	}
+
+	span := roachpb.Span{Key: testKey, EndKey: roachpb.KeyMax}
+	latest := hlc.Timestamp{WallTime: 3}
+	for _, tc := range []struct {
+		name               string
+		startTime, endTime hlc.Timestamp
+	}{
+		{"start equals end", latest, latest},
+		{"start after end", latest, hlc.Timestamp{WallTime: 1}},
+		{"caught up", latest, CatchUpEndTime(latest, latest)},
+		{"caught up above latest", latest.Next(), CatchUpEndTime(latest.Next(), latest)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testutils.RunTrueAndFalse(t, "withDiff", func(t *testing.T, withDiff bool) {
+				var closed bool
+				iter, err := NewCatchUpIterator(ctx, eng, span, tc.startTime, tc.endTime,
+					false /* inclusiveLowerBound */, func() { closed = true }, nil)
+				require.NoError(t, err)
+				require.Nil(t, iter.simpleCatchupIter)
+				_, err = iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+					t.Fatalf("unexpected event %v", e)
+					return nil
+				}, withDiff, false /* withFiltering */)
+				require.NoError(t, err)
+				iter.Close()
+				require.True(t, closed)
+			})
+		})
+	}
+}
+
+// TestCatchUpEndTime tests that CatchUpEndTime only bounds the scan when no
+// version can be above its start time, and that the scan then still emits the
+// versions at its start time with an inclusive lower bound.
+func TestCatchUpEndTime(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ts1, ts2 := hlc.Timestamp{WallTime: 1}, hlc.Timestamp{WallTime: 2}
+	require.Equal(t, ts2, CatchUpEndTime(ts2, ts1))
+	require.Equal(t, ts2, CatchUpEndTime(ts2, ts2))
+	require.Equal(t, hlc.Timestamp{}, CatchUpEndTime(ts1, ts2))
+
+	ctx := context.Background()
+	eng := storage.NewDefaultInMemForTesting()
+	defer eng.Close()
+	testKey := roachpb.Key("/db1")
+	_, err := storage.MVCCPut(ctx, eng, testKey, ts2, roachpb.MakeValueFromString("val"), storage.MVCCWriteOptions{})
+	require.NoError(t, err)
+
+	span := roachpb.Span{Key: testKey, EndKey: roachpb.KeyMax}
+	iter, err := NewCatchUpIterator(ctx, eng, span, ts2, CatchUpEndTime(ts2, ts2),
+		true /* inclusiveLowerBound */, nil, nil)
+	require.NoError(t, err)
+	defer iter.Close()
+	var emitted int
+	_, err = iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+		emitted++
+		return nil
+	}, false /* withDiff */, false /* withFiltering */)
+	require.NoError(t, err)
+	require.Equal(t, 1, emitted)
+}
+
+// TestCatchupScanEmitCaughtUp tests that, with EmitCaughtUp set, a completed
+// catch-up scan emits a checkpoint at the start time over the whole span after
+// all of its values, even if it emitted none, and that no checkpoint is
+// emitted if the scan fails partway through.
+func TestCatchupScanEmitCaughtUp(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	eng := storage.NewDefaultInMemForTesting(storage.If(smallEngineBlocks, storage.BlockSize(1)))
+	defer eng.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		for wallTime := int64(1); wallTime <= 3; wallTime++ {
+			_, err := storage.MVCCPut(ctx, eng, roachpb.Key(key), hlc.Timestamp{WallTime: wallTime},
+				roachpb.MakeValueFromString("val"), storage.MVCCWriteOptions{})
+			require.NoError(t, err)
+		}
+	}
+
+	span := roachpb.Span{Key: roachpb.Key("a"), EndKey: roachpb.Key("z")}
+	startTime := hlc.Timestamp{WallTime: 1}
+	scan := func(t *testing.T, endTime hlc.Timestamp, outputFn outputEventFn) error {
+		iter, err := NewCatchUpIterator(ctx, eng, span, startTime, endTime,
+			false /* inclusiveLowerBound */, nil, nil)
+		require.NoError(t, err)
+		defer iter.Close()
+		iter.EmitCaughtUp = true
+		_, err = iter.CatchUpScan(ctx, outputFn, false /* withDiff */, false /* withFiltering */)
+		return err
+	}
+
+	for _, tc := range []struct {
+		name           string
+		endTime        hlc.Timestamp
+		expectedValues int
+	}{
+		{"values", hlc.Timestamp{}, 6},
+		{"empty window", startTime, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var events []kvpb.RangeFeedEvent
+			require.NoError(t, scan(t, tc.endTime, func(e *kvpb.RangeFeedEvent) error {
+				events = append(events, *e.ShallowCopy())
+				return nil
+			}))
+			require.Len(t, events, tc.expectedValues+1)
+			for _, e := range events[:tc.expectedValues] {
+				require.NotNil(t, e.Val)
+			}
+			require.Equal(t, &kvpb.RangeFeedCheckpoint{Span: span, ResolvedTS: startTime},
+				events[len(events)-1].Checkpoint)
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		var events []kvpb.RangeFeedEvent
+		err := scan(t, hlc.Timestamp{}, func(e *kvpb.RangeFeedEvent) error {
+			events = append(events, *e.ShallowCopy())
+			if len(events) == 3 {
+				return errors.New("boom")
+			}
+			return nil
+		})
+		require.ErrorContains(t, err, "boom")
+		for _, e := range events {
+			require.Nil(t, e.Checkpoint)
+		}
+	})
+}
+
+// TestCatchupScanResume tests that catch-up scans stopped early via MaxKeys
+// can be resumed from their serialized ResumeToken, without losing or
+// duplicating any events.
+func TestCatchupScanResume(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	eng := storage.NewDefaultInMemForTesting(storage.If(smallEngineBlocks, storage.BlockSize(1)))
+	defer eng.Close()
+
+	// Write versions at 1-3 to a-e, an MVCC range tombstone [b-e)@4, and a
+	// version at 5 to c.
+	for wallTime := int64(1); wallTime <= 3; wallTime++ {
+		for _, key := range []string{"a", "b", "c", "d", "e"} {
+			_, err := storage.MVCCPut(ctx, eng, roachpb.Key(key), hlc.Timestamp{WallTime: wallTime},
+				roachpb.MakeValueFromString(fmt.Sprintf("%s%d", key, wallTime)), storage.MVCCWriteOptions{})
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, storage.MVCCDeleteRangeUsingTombstone(ctx, eng, nil,
+		roachpb.Key("b"), roachpb.Key("e"), hlc.Timestamp{WallTime: 4}, hlc.ClockTimestamp{},
+		nil, nil, false, 0, nil))
+	_, err := storage.MVCCPut(ctx, eng, roachpb.Key("c"), hlc.Timestamp{WallTime: 5},
+		roachpb.MakeValueFromString("c5"), storage.MVCCWriteOptions{})
+	require.NoError(t, err)
+
+	span := roachpb.Span{Key: roachpb.Key("a"), EndKey: roachpb.Key("z")}
+	startTime := hlc.Timestamp{WallTime: 1}
+	// scan runs a scan which emits at most maxKeys keys, resuming from the
+	// given serialized token if any, and returns the serialized token to
+	// resume it from, if it stopped early.
+	scan := func(t *testing.T, maxKeys int, token []byte, events *[]string) []byte {
+		iter, err := NewCatchUpIterator(ctx, eng, span, startTime, hlc.Timestamp{},
+			false /* inclusiveLowerBound */, nil, nil)
+		require.NoError(t, err)
+		defer iter.Close()
+		iter.EmitCaughtUp = true
+		iter.MaxKeys = maxKeys
+		if token != nil {
+			resumeFrom, err := DecodeResumeToken(token)
+			require.NoError(t, err)
+			iter.ResumeFrom = &resumeFrom
+		}
+		_, err = iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+			*events = append(*events, e.String())
+			return nil
+		}, true /* withDiff */, false /* withFiltering */)
+		require.NoError(t, err)
+		if resumeToken := iter.ResumeToken(); resumeToken != nil {
+			return resumeToken.Encode()
+		}
+		return nil
+	}
+
+	var expected []string
+	require.Nil(t, scan(t, 0 /* maxKeys */, nil /* token */, &expected))
+	// 11 values, the MVCC range tombstone, and the checkpoint.
+	require.Len(t, expected, 13)
+
+	for _, maxKeys := range []int{1, 2, 3, 4, 5} {
+		t.Run(fmt.Sprintf("maxKeys=%d", maxKeys), func(t *testing.T) {
+			var events []string
+			var scans int
+			token := scan(t, maxKeys, nil /* token */, &events)
+			for scans = 1; token != nil; scans++ {
+				token = scan(t, maxKeys, token, &events)
+			}
+			require.Equal(t, expected, events)
+			require.Equal(t, (5+maxKeys-1)/maxKeys, scans)
+		})
+	}
+
+	t.Run("mismatched start time", func(t *testing.T) {
+		iter, err := NewCatchUpIterator(ctx, eng, span, hlc.Timestamp{WallTime: 2}, hlc.Timestamp{},
+			false /* inclusiveLowerBound */, nil, nil)
+		require.NoError(t, err)
+		defer iter.Close()
+		iter.ResumeFrom = &ResumeToken{Key: roachpb.Key("c"), Timestamp: startTime}
+		_, err = iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+			return nil
+		}, false /* withDiff */, false /* withFiltering */)
+		require.ErrorContains(t, err, "can't resume a scan starting at")
+	})
+}
+
+// shortWriter is an io.Writer which writes at most 3 bytes at a time.
+type shortWriter struct {
+	bytes.Buffer
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	return w.Buffer.Write(p[:min(len(p), 3)])
+}
+
+// TestCatchupScanToWriter tests that the events written by CatchUpScanToWriter
+// match the ones emitted by CatchUpScan.
+func TestCatchupScanToWriter(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	eng := storage.NewDefaultInMemForTesting(storage.If(smallEngineBlocks, storage.BlockSize(1)))
+	defer eng.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		for wallTime := int64(1); wallTime <= 3; wallTime++ {
+			_, err := storage.MVCCPut(ctx, eng, roachpb.Key(key), hlc.Timestamp{WallTime: wallTime},
+				roachpb.MakeValueFromString(fmt.Sprintf("%s@%d", key, wallTime)), storage.MVCCWriteOptions{})
+			require.NoError(t, err)
+		}
+	}
+
+	span := roachpb.Span{Key: roachpb.Key("a"), EndKey: roachpb.Key("z")}
+	newIter := func(t *testing.T) *CatchUpIterator {
+		iter, err := NewCatchUpIterator(ctx, eng, span, hlc.Timestamp{WallTime: 1}, hlc.Timestamp{},
+			false /* inclusiveLowerBound */, nil, nil)
+		require.NoError(t, err)
+		iter.EmitCaughtUp = true
+		return iter
+	}
+
+	var expected []kvpb.RangeFeedEvent
+	iter := newIter(t)
+	expectedHighWater, err := iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+		expected = append(expected, *e.ShallowCopy())
+		return nil
+	}, true /* withDiff */, false /* withFiltering */)
+	iter.Close()
+	require.NoError(t, err)
+	require.Len(t, expected, 7)
+
+	// readEvents reads the length-prefixed events back from r.
+	readEvents := func(t *testing.T, r *bytes.Buffer) []kvpb.RangeFeedEvent {
+		var events []kvpb.RangeFeedEvent
+		for r.Len() > 0 {
+			n, err := binary.ReadUvarint(r)
+			require.NoError(t, err)
+			data := make([]byte, n)
+			_, err = io.ReadFull(r, data)
+			require.NoError(t, err)
+			var e kvpb.RangeFeedEvent
+			require.NoError(t, protoutil.Unmarshal(data, &e))
+			events = append(events, e)
+		}
+		return events
+	}
+
+	t.Run("buffer", func(t *testing.T) {
+		var buf bytes.Buffer
+		iter := newIter(t)
+		defer iter.Close()
+		highWater, err := iter.CatchUpScanToWriter(ctx, &buf, true /* withDiff */, false /* withFiltering */)
+		require.NoError(t, err)
+		require.Equal(t, expectedHighWater, highWater)
+		require.Equal(t, expected, readEvents(t, &buf))
+	})
+
+	t.Run("short writes", func(t *testing.T) {
+		var w shortWriter
+		iter := newIter(t)
+		defer iter.Close()
+		_, err := iter.CatchUpScanToWriter(ctx, &w, true /* withDiff */, false /* withFiltering */)
+		require.NoError(t, err)
+		require.Equal(t, expected, readEvents(t, &w.Buffer))
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		var buf bytes.Buffer
+		// The buffer is larger than all of the events, which are only written
+		// to buf when flushed.
+		bw := bufio.NewWriterSize(&buf, 1<<16)
+		iter := newIter(t)
+		defer iter.Close()
+		_, err := iter.CatchUpScanToWriter(ctx, bw, true /* withDiff */, false /* withFiltering */)
+		require.NoError(t, err)
+		require.Zero(t, bw.Buffered())
+		require.Equal(t, expected, readEvents(t, &buf))
+	})
+}
+
+// TestCatchupScanHighWater tests that the catch-up scan reports the highest
+// timestamp it observed.
+func TestCatchupScanHighWater(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	eng := storage.NewDefaultInMemForTesting(storage.If(smallEngineBlocks, storage.BlockSize(1)))
+	defer eng.Close()
+
+	scan := func(t *testing.T, startTime hlc.Timestamp, withDiff bool) hlc.Timestamp {
+		span := roachpb.Span{Key: keys.LocalMax, EndKey: keys.MaxKey}
+		iter, err := NewCatchUpIterator(ctx, eng, span, startTime, hlc.Timestamp{},
+			false /* inclusiveLowerBound */, nil, nil)
+		require.NoError(t, err)
+		defer iter.Close()
+		highWater, err := iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+			return nil
+		}, withDiff, false /* withFiltering */)
+		require.NoError(t, err)
+		return highWater
+	}
+
+	testutils.RunTrueAndFalse(t, "withDiff", func(t *testing.T, withDiff bool) {
+		// Nothing has been written yet.
+		require.Empty(t, scan(t, hlc.Timestamp{}, withDiff))
+	})
+
+	newest := hlc.Timestamp{WallTime: 9}
+	for _, kv := range []struct {
+		key string
+		ts  hlc.Timestamp
+	}{
+		{"a", hlc.Timestamp{WallTime: 3}},
+		{"a", hlc.Timestamp{WallTime: 5}},
+		{"b", newest},
+		{"c", hlc.Timestamp{WallTime: 7}},
+	} {
+		_, err := storage.MVCCPut(ctx, eng, roachpb.Key(kv.key), kv.ts,
+			roachpb.MakeValueFromString("val"), storage.MVCCWriteOptions{})
+		require.NoError(t, err)
+	}
+	// An intent above the newest committed version isn't reported.
+	txn := roachpb.MakeTransaction("test", roachpb.Key("d"), isolation.Serializable,
+		roachpb.NormalUserPriority, hlc.Timestamp{WallTime: 11}, 0, 0, 0, false /* omitInRangefeeds */)
+	_, err := storage.MVCCPut(ctx, eng, roachpb.Key("d"), txn.ReadTimestamp,
+		roachpb.MakeValueFromString("val"), storage.MVCCWriteOptions{Txn: &txn})
+	require.NoError(t, err)
+
+	testutils.RunTrueAndFalse(t, "withDiff", func(t *testing.T, withDiff bool) {
+		require.Equal(t, newest, scan(t, hlc.Timestamp{}, withDiff))
+		require.Equal(t, newest, scan(t, hlc.Timestamp{WallTime: 4}, withDiff))
+	})
+}
+
+// TestCatchupScanFromIter tests that a CatchUpIterator wrapping an existing
+// MVCCIterator emits the same events as one constructed from the engine.
+func TestCatchupScanFromIter(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	eng := storage.NewDefaultInMemForTesting(storage.If(smallEngineBlocks, storage.BlockSize(1)))
+	defer eng.Close()
+
+	// Write versions at 1-5 to a, b and c, except that b and c have an MVCC
+	// range tombstone [b-d)@3 instead of a version at 3, and an intent on c@6.
+	for wallTime := int64(1); wallTime <= 5; wallTime++ {
+		writeKeys := []string{"a", "b", "c"}
+		if wallTime == 3 {
+			require.NoError(t, storage.MVCCDeleteRangeUsingTombstone(ctx, eng, nil,
+				roachpb.Key("b"), roachpb.Key("d"), hlc.Timestamp{WallTime: wallTime}, hlc.ClockTimestamp{},
+				nil, nil, false, 0, nil))
+			writeKeys = writeKeys[:1]
+		}
+		for _, key := range writeKeys {
+			_, err := storage.MVCCPut(ctx, eng, roachpb.Key(key), hlc.Timestamp{WallTime: wallTime},
+				roachpb.MakeValueFromString(fmt.Sprintf("%s%d", key, wallTime)), storage.MVCCWriteOptions{})
+			require.NoError(t, err)
+		}
+	}
+	txn := roachpb.MakeTransaction("foo", roachpb.Key("c"), isolation.Serializable, roachpb.NormalUserPriority, hlc.Timestamp{WallTime: 6}, 100, 0, 0, false /* omitInRangefeeds */)
+	_, err := storage.MVCCPut(ctx, eng, roachpb.Key("c"), hlc.Timestamp{WallTime: 6},
+		roachpb.MakeValueFromString("intent"), storage.MVCCWriteOptions{Txn: &txn})
+	require.NoError(t, err)
+
+	span := roachpb.Span{Key: roachpb.Key("a"), EndKey: roachpb.Key("z")}
+	startTime, endTime := hlc.Timestamp{WallTime: 2}, hlc.Timestamp{WallTime: 4}
+	scan := func(t *testing.T, iter *CatchUpIterator, withDiff bool) []kvpb.RangeFeedEvent {
+		defer iter.Close()
+		var events []kvpb.RangeFeedEvent
+		_, err := iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+			events = append(events, *e.ShallowCopy())
+			return nil
+		}, withDiff, false /* withFiltering */)
+		require.NoError(t, err)
+		return events
+	}
+
+	testutils.RunTrueAndFalse(t, "withDiff", func(t *testing.T, withDiff bool) {
+		testutils.RunTrueAndFalse(t, "inclusiveLowerBound", func(t *testing.T, inclusiveLowerBound bool) {
+			engIter, err := NewCatchUpIterator(ctx, eng, span, startTime, endTime, inclusiveLowerBound, nil, nil)
+			require.NoError(t, err)
+			expected := scan(t, engIter, withDiff)
+			if inclusiveLowerBound {
+				// a@2, a@3, a@4, b@2, b@4, c@2, c@4 and the range tombstone.
+				require.Len(t, expected, 8)
+			} else {
+				// a@3, a@4, b@4, c@4 and the range tombstone.
+				require.Len(t, expected, 5)
+			}
+
+			mvccIter, err := eng.NewMVCCIterator(ctx, storage.MVCCKeyAndIntentsIterKind, storage.IterOptions{
+				KeyTypes:   storage.IterKeyTypePointsAndRanges,
+				LowerBound: span.Key,
+				UpperBound: span.EndKey,
+			})
+			require.NoError(t, err)
+			actual := scan(t, NewCatchUpIteratorFromIter(mvccIter, span, startTime, endTime,
+				inclusiveLowerBound, nil, nil), withDiff)
+			require.Equal(t, expected, actual)
+		})
+	})
+
+	// An iterator with wider bounds than the span only emits the events within
+	// the span, and truncates the range tombstone to it.
+	testutils.RunTrueAndFalse(t, "widerBounds/withDiff", func(t *testing.T, withDiff bool) {
+		narrowSpan := roachpb.Span{Key: roachpb.Key("b"), EndKey: roachpb.Key("c")}
+		engIter, err := NewCatchUpIterator(ctx, eng, narrowSpan, startTime, endTime,
+			false /* inclusiveLowerBound */, nil, nil)
+		require.NoError(t, err)
+		expected := scan(t, engIter, withDiff)
+		// b@4 and the range tombstone [b-c)@3.
+		require.Len(t, expected, 2)
+
+		mvccIter, err := eng.NewMVCCIterator(ctx, storage.MVCCKeyAndIntentsIterKind, storage.IterOptions{
+			KeyTypes:   storage.IterKeyTypePointsAndRanges,
+			LowerBound: span.Key,
+			UpperBound: span.EndKey,
+		})
+		require.NoError(t, err)
+		actual := scan(t, NewCatchUpIteratorFromIter(mvccIter, narrowSpan, startTime, endTime,
+			false /* inclusiveLowerBound */, nil, nil), withDiff)
+		require.Equal(t, expected, actual)
+		require.Equal(t, narrowSpan, actual[0].DeleteRange.Span)
+	})
+}
+
+// TestCatchupScanFromSnapshot tests that a catch-up iterator created from a
+// snapshot only observes writes made before the snapshot was taken, that
+// closing the iterator calls its close callback, and that a live engine is
+// rejected since it doesn't provide a consistent view.
+func TestCatchupScanFromSnapshot(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	eng := storage.NewDefaultInMemForTesting()
+	defer eng.Close()
+
+	put := func(key string, wallTime int64) {
+		_, err := storage.MVCCPut(ctx, eng, roachpb.Key(key), hlc.Timestamp{WallTime: wallTime},
+			roachpb.MakeValueFromString(fmt.Sprintf("%s%d", key, wallTime)), storage.MVCCWriteOptions{})
+		require.NoError(t, err)
+	}
+	put("a", 1)
+	put("b", 1)
+
+	snapshot := eng.NewSnapshot()
+	var closed bool
+	iter, err := NewCatchUpIteratorFromSnapshot(ctx, snapshot,
+		roachpb.Span{Key: roachpb.Key("a"), EndKey: roachpb.Key("z")},
+		hlc.Timestamp{}, hlc.Timestamp{} /* endTime */, func() {
+			snapshot.Close()
+			closed = true
+		}, nil /* pacer */)
+	require.NoError(t, err)
+
+	// Writes after the snapshot was taken, both to existing and new keys, are
+	// not observed by the catch-up scan.
+	put("a", 2)
+	put("c", 2)
+
+	var keys []string
+	_, err = iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+		keys = append(keys, fmt.Sprintf("%s@%d", e.Val.Key, e.Val.Value.Timestamp.WallTime))
+		return nil
+	}, false /* withDiff */, false /* withFiltering */)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a@1", "b@1"}, keys)
+
+	iter.Close()
+	require.True(t, closed)
+
+	// The live engine doesn't provide a consistent view.
+	_, err = NewCatchUpIteratorFromSnapshot(ctx, eng, roachpb.Span{Key: roachpb.Key("a"), EndKey: roachpb.Key("z")},
+		hlc.Timestamp{}, hlc.Timestamp{}, nil, nil)
+	require.Error(t, err)
+}
+
+// nextIgnoringTimeCounter counts the calls to NextIgnoringTime, which is how
+// CatchUpScan steps onto versions below the start time to load previous values.
+type nextIgnoringTimeCounter struct {
+	simpleCatchupIter
+	count int
+}
+
+func (i *nextIgnoringTimeCounter) NextIgnoringTime() {
+	i.count++
+	i.simpleCatchupIter.NextIgnoringTime()
+}
+
+// TestCatchupScanPrevValueLoads tests that the catch-up scan only steps onto
+// versions below the start time to load previous values when withDiff is set.
+func TestCatchupScanPrevValueLoads(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	eng := storage.NewDefaultInMemForTesting(storage.If(smallEngineBlocks, storage.BlockSize(1)))
+	defer eng.Close()
+
+	// Write versions at 1-3 to a, b and c.
+	for wallTime := int64(1); wallTime <= 3; wallTime++ {
+		for _, key := range []string{"a", "b", "c"} {
+			_, err := storage.MVCCPut(ctx, eng, roachpb.Key(key), hlc.Timestamp{WallTime: wallTime},
+				roachpb.MakeValueFromString(fmt.Sprintf("%s%d", key, wallTime)), storage.MVCCWriteOptions{})
+			require.NoError(t, err)
+		}
+	}
+
+	span := roachpb.Span{Key: roachpb.Key("a"), EndKey: roachpb.Key("z")}
+	testutils.RunTrueAndFalse(t, "withDiff", func(t *testing.T, withDiff bool) {
+		iter, err := NewCatchUpIterator(ctx, eng, span, hlc.Timestamp{WallTime: 2}, hlc.Timestamp{},
+			false /* inclusiveLowerBound */, nil, nil)
+		require.NoError(t, err)
+		defer iter.Close()
+		counter := &nextIgnoringTimeCounter{simpleCatchupIter: iter.simpleCatchupIter}
+		iter.simpleCatchupIter = counter
+
+		var prevValues []string
+		_, err = iter.CatchUpScan(ctx, func(e *kvpb.RangeFeedEvent) error {
+			var prevValue []byte
+			if e.Val.PrevValue.IsPresent() {
+				var err error
+				prevValue, err = e.Val.PrevValue.GetBytes()
+				require.NoError(t, err)
+			}
+			prevValues = append(prevValues, string(prevValue))
+			return nil
+		}, withDiff, false /* withFiltering */)
+		require.NoError(t, err)
+
+		if withDiff {
+			require.Equal(t, []string{"a2", "b2", "c2"}, prevValues)
+			require.NotZero(t, counter.count)
+		} else {
+			require.Equal(t, []string{"", "", ""}, prevValues)
+			require.Zero(t, counter.count)
+		}
+	})
+}
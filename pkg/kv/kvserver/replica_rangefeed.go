@@ -304,8 +304,8 @@ func (r *Replica) RangeFeed(
 		// Pass context.Background() since the context where the iter will be used
 		// is different.
 		catchUpIter, err = rangefeed.NewCatchUpIterator(
-			context.Background(), r.store.TODOEngine(), rSpan.AsRawSpanWithNoLocals(),
-			args.Timestamp, iterSemRelease, pacer)
+			context.Background(), r.store.TODOEngine(), rSpan.AsRawSpanWithNoLocals(), args.Timestamp,
+			hlc.Timestamp{} /* endTime */, false /* inclusiveLowerBound */, iterSemRelease, pacer)
 		if err != nil {
 			r.raftMu.Unlock()
 			iterSemRelease()
@@ -537,8 +537,10 @@ func Stage(
 	return install.StageApplication(ctx, l, c, applicationName, version, os, vm.CPUArch(arch), dir)
 }
 
-// Reset resets all VMs in a cluster.
-func Reset(l *logger.Logger, clusterName string) error {
+// Reset resets all VMs in a cluster. If force is set, providers which support
+// it (see vm.ForceReset) also recover VMs which can't be reset, e.g. because
+// they are stopped.
+func Reset(l *logger.Logger, clusterName string, force bool) error {
 	if err := LoadClusters(); err != nil {
 		return err
 	}
@@ -557,6 +559,9 @@ func Reset(l *logger.Logger, clusterName string) error {
 	}
 
 	return vm.FanOut(c.VMs, func(p vm.Provider, vms vm.List) error {
+		if fr, ok := p.(vm.ForceReset); ok && force {
+			return fr.ForceReset(l, vms)
+		}
 		return p.Reset(l, vms)
 	})
 }
@@ -257,6 +257,10 @@ type Provider struct {
 	IAMProfile string
 }
 
+func (p *Provider) SupportsEncryptedVolumes() bool {
+	return true
+}
+
 func (p *Provider) SupportsSpotVMs() bool {
 	return false
 }
@@ -1592,8 +1596,9 @@ func (p *Provider) CreateVolumeSnapshot(
 		return vm.VolumeSnapshot{}, err
 	}
 	return vm.VolumeSnapshot{
-		ID:   so.SnapshotID,
-		Name: vsco.Name,
+		ID:                so.SnapshotID,
+		Name:              vsco.Name,
+		CreationTimestamp: so.StartTime,
 	}, nil
 }
 
@@ -94,6 +94,10 @@ type Provider struct {
 	}
 }
 
+func (p *Provider) SupportsEncryptedVolumes() bool {
+	return false
+}
+
 func (p *Provider) SupportsSpotVMs() bool {
 	return false
 }
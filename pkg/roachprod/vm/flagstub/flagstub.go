@@ -32,6 +32,10 @@ type provider struct {
 	unimplemented string
 }
 
+func (p *provider) SupportsEncryptedVolumes() bool {
+	return false
+}
+
 func (p *provider) SupportsSpotVMs() bool {
 	return false
 }
@@ -14,7 +14,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
 	"sort"
 	"strconv"
 	"strings"
@@ -94,7 +93,7 @@ func (n *dnsProvider) CreateRecords(ctx context.Context, records ...vm.DNSRecord
 			"--zone", dnsManagedZone,
 			"--rrdatas", strings.Join(data, ","),
 		}
-		cmd := exec.CommandContext(ctx, "gcloud", args...)
+		cmd := gcloudCommand(ctx, args...)
 		out, err := cmd.CombinedOutput()
 		if err != nil {
 			return markDNSOperationError(errors.Wrapf(err, "output: %s", out))
@@ -126,7 +125,7 @@ func (n *dnsProvider) DeleteRecordsByName(ctx context.Context, names ...string)
 				"--type", string(vm.SRV),
 				"--zone", dnsManagedZone,
 			}
-			cmd := exec.CommandContext(ctx, "gcloud", args...)
+			cmd := gcloudCommand(ctx, args...)
 			out, err := cmd.CombinedOutput()
 			if err != nil {
 				return markDNSOperationError(errors.Wrapf(err, "output: %s", out))
@@ -210,7 +209,7 @@ func (n *dnsProvider) listSRVRecords(
 	if filter != "" {
 		args = append(args, "--filter", filter)
 	}
-	cmd := exec.CommandContext(ctx, "gcloud", args...)
+	cmd := gcloudCommand(ctx, args...)
 	res, err := cmd.CombinedOutput()
 	if err != nil {
 		return nil, markDNSOperationError(errors.Wrapf(err, "output: %s", res))
@@ -15,6 +15,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"regexp"
@@ -28,9 +29,13 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/vm"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/vm/flagstub"
+	"github.com/cockroachdb/cockroach/pkg/util/randutil"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
 	"github.com/spf13/pflag"
+	"golang.org/x/exp/maps"
 	"golang.org/x/sync/errgroup"
 	cloudbilling "google.golang.org/api/cloudbilling/v1beta"
 )
@@ -62,16 +67,11 @@ var initialized = false
 
 // Init registers the GCE provider into vm.Providers.
 //
-// If the gcloud tool is not available on the local path, the provider is a
-// stub.
+// If the gcloud tool is not available on the local path (or at the path set
+// via GCLOUD_BIN), the provider is a stub.
 func Init() error {
-	providerInstance.Projects = []string{defaultProject}
-	projectFromEnv := os.Getenv("GCE_PROJECT")
-	if projectFromEnv != "" {
-		providerInstance.Projects = []string{projectFromEnv}
-	}
-	providerInstance.ServiceAccount = os.Getenv("GCE_SERVICE_ACCOUNT")
-	if _, err := exec.LookPath("gcloud"); err != nil {
+	providerInstance.ReloadEnv()
+	if _, err := exec.LookPath(gcloudBinary()); err != nil {
 		vm.Providers[ProviderName] = flagstub.New(&Provider{}, "please install the gcloud CLI utilities "+
 			"(https://cloud.google.com/sdk/downloads)")
 		return errors.New("gcloud not found")
@@ -82,10 +82,56 @@ func Init() error {
 	return nil
 }
 
-func runJSONCommand(args []string, parsed interface{}) error {
-	cmd := exec.Command("gcloud", args...)
+// gcloudBinEnvVar is the environment variable which overrides the gcloud
+// binary, e.g. for CI images with an SDK installed outside of the PATH.
+const gcloudBinEnvVar = "GCLOUD_BIN"
+
+// gcloudBinary returns the gcloud binary to run: the one set via GCLOUD_BIN,
+// if any, or the one found in the PATH otherwise.
+func gcloudBinary() string {
+	if bin := os.Getenv(gcloudBinEnvVar); bin != "" {
+		return bin
+	}
+	return "gcloud"
+}
+
+// gcloudCommand returns the command running gcloud with the given arguments.
+// All gcloud invocations go through it, so that they honor GCLOUD_BIN.
+func gcloudCommand(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, gcloudBinary(), args...)
+}
+
+// commandRunner runs gcloud commands on behalf of the provider. It allows
+// tests to substitute a fake implementation and inspect the commands that
+// would be run.
+type commandRunner interface {
+	// Output runs gcloud with the given arguments and returns its standard
+	// output.
+	Output(ctx context.Context, args ...string) ([]byte, error)
+	// CombinedOutput runs gcloud with the given arguments and returns its
+	// combined standard output and standard error.
+	CombinedOutput(ctx context.Context, args ...string) ([]byte, error)
+}
+
+// execRunner is the commandRunner which executes the gcloud binary.
+type execRunner struct{}
+
+// Output implements the commandRunner interface.
+func (execRunner) Output(ctx context.Context, args ...string) ([]byte, error) {
+	return gcloudCommand(ctx, args...).Output()
+}
 
-	rawJSON, err := cmd.Output()
+// CombinedOutput implements the commandRunner interface.
+func (execRunner) CombinedOutput(ctx context.Context, args ...string) ([]byte, error) {
+	return gcloudCommand(ctx, args...).CombinedOutput()
+}
+
+// runner is the commandRunner used to run all gcloud commands issued by the
+// provider.
+var runner commandRunner = execRunner{}
+
+func runJSONCommand(args []string, parsed interface{}) error {
+	rawJSON, err := runner.Output(context.Background(), args...)
 	if err != nil {
 		var stderr []byte
 		if exitErr := (*exec.ExitError)(nil); errors.As(err, &exitErr) {
@@ -106,11 +152,65 @@ func runJSONCommand(args []string, parsed interface{}) error {
 	return nil
 }
 
+// gcloudOperation is the part of a GCE operation which identifies it, e.g.
+// for auditing in Cloud Logging.
+type gcloudOperation struct {
+	Kind          string `json:"kind"`
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	OperationType string `json:"operationType"`
+	TargetLink    string `json:"targetLink"`
+}
+
+// operationNameRE matches the names of GCE operations, e.g.
+// operation-1700000000000-60a8e0c3c5b3e-7e7f1b2c-0d5e8f9a.
+var operationNameRE = regexp.MustCompile(`\boperation-\d+-[0-9a-f]+(?:-[0-9a-f]+)*\b`)
+
+// parseOperations returns the operations reported in the given output of a
+// gcloud command. If the output is JSON (e.g. with --format json), the
+// operations among the returned resources are parsed in full. Otherwise, only
+// the names of the operations mentioned in the output are.
+func parseOperations(output []byte) []gcloudOperation {
+	var resources []gcloudOperation
+	if err := json.Unmarshal(output, &resources); err != nil {
+		var resource gcloudOperation
+		if err := json.Unmarshal(output, &resource); err != nil {
+			var operations []gcloudOperation
+			for _, name := range operationNameRE.FindAll(output, -1) {
+				operations = append(operations, gcloudOperation{Name: string(name)})
+			}
+			return operations
+		}
+		resources = []gcloudOperation{resource}
+	}
+	var operations []gcloudOperation
+	for _, resource := range resources {
+		if resource.Kind == "compute#operation" {
+			operations = append(operations, resource)
+		}
+	}
+	return operations
+}
+
+// logOperations logs the operations reported in the given output of the
+// given gcloud command, if any, so that they can be audited afterwards.
+func logOperations(l *logger.Logger, args []string, output []byte) {
+	for _, op := range parseOperations(output) {
+		msg := fmt.Sprintf("gcloud %s: operation %s", strings.Join(args[:min(3, len(args))], " "), op.Name)
+		if op.ID != "" {
+			msg += fmt.Sprintf(" (id: %s, type: %s, target: %s)", op.ID, op.OperationType, op.TargetLink)
+		}
+		l.Printf("%s", msg)
+	}
+}
+
 // Used to parse the gcloud responses
 type jsonVM struct {
 	Name              string
+	Hostname          string
 	Labels            map[string]string
 	CreationTimestamp time.Time
+	Status            string
 	NetworkInterfaces []struct {
 		Network       string
 		NetworkIP     string
@@ -128,12 +228,49 @@ type jsonVM struct {
 	}
 	MachineType string
 	// CPU platform corresponding to machine type; see https://cloud.google.com/compute/docs/cpu-platforms
-	CPUPlatform string
-	SelfLink    string
-	Zone        string
+	CPUPlatform       string
+	GuestAccelerators []struct {
+		AcceleratorType  string
+		AcceleratorCount int
+	}
+	SelfLink string
+	Zone     string
 	instanceDisksResponse
 }
 
+// Provisioning models reported in vm.VM.ProvisioningModel.
+const (
+	ProvisioningModelStandard    = "STANDARD"
+	ProvisioningModelSpot        = "SPOT"
+	ProvisioningModelPreemptible = "PREEMPTIBLE"
+)
+
+// provisioningModel returns the provisioning model of the VM. Both spot and
+// legacy preemptible instances are marked as preemptible by gcloud; only the
+// former report the SPOT provisioning model.
+func (jsonVM *jsonVM) provisioningModel() string {
+	switch {
+	case jsonVM.Scheduling.ProvisioningModel == ProvisioningModelSpot:
+		return ProvisioningModelSpot
+	case jsonVM.Scheduling.Preemptible:
+		return ProvisioningModelPreemptible
+	default:
+		return ProvisioningModelStandard
+	}
+}
+
+// accelerators returns the accelerators attached to the VM, if any.
+func (jsonVM *jsonVM) accelerators() []vm.Accelerator {
+	var accelerators []vm.Accelerator
+	for _, a := range jsonVM.GuestAccelerators {
+		accelerators = append(accelerators, vm.Accelerator{
+			Type:  lastComponent(a.AcceleratorType),
+			Count: a.AcceleratorCount,
+		})
+	}
+	return accelerators
+}
+
 // Convert the JSON VM data into our common VM type
 func (jsonVM *jsonVM) toVM(
 	project string, disks []describeVolumeCommandResponse, opts *ProviderOpts,
@@ -200,6 +337,7 @@ func (jsonVM *jsonVM) toVM(
 			localDisks = append(localDisks, vm.Volume{
 				Size:               parseDiskSize(jsonVMDisk.DiskSizeGB),
 				ProviderVolumeType: "local-ssd",
+				Interface:          jsonVMDisk.Interface,
 			})
 			continue
 		}
@@ -218,6 +356,22 @@ func (jsonVM *jsonVM) toVM(
 						Name:               detailedDisk.Name,
 						Labels:             detailedDisk.Labels,
 						Size:               parseDiskSize(detailedDisk.SizeGB),
+						Interface:          jsonVMDisk.Interface,
+					}
+					if iops, err := parseOptionalInt(detailedDisk.ProvisionedIops); err == nil {
+						vol.IOPS = iops
+					} else {
+						vmErrors = append(vmErrors, errors.Newf("invalid provisioned IOPS: %q", detailedDisk.ProvisionedIops))
+					}
+					if throughput, err := parseOptionalInt(detailedDisk.ProvisionedThroughput); err == nil {
+						vol.Throughput = throughput
+					} else {
+						vmErrors = append(vmErrors, errors.Newf("invalid provisioned throughput: %q", detailedDisk.ProvisionedThroughput))
+					}
+					if blockSize, err := parseOptionalInt(detailedDisk.PhysicalBlockSizeBytes); err == nil {
+						vol.PhysicalBlockSizeBytes = blockSize
+					} else {
+						vmErrors = append(vmErrors, errors.Newf("invalid physical block size: %q", detailedDisk.PhysicalBlockSizeBytes))
 					}
 					volumes = append(volumes, vol)
 				}
@@ -227,11 +381,15 @@ func (jsonVM *jsonVM) toVM(
 
 	return &vm.VM{
 		Name:                   jsonVM.Name,
+		Hostname:               jsonVM.Hostname,
 		CreatedAt:              jsonVM.CreationTimestamp,
 		Errors:                 vmErrors,
 		DNS:                    fmt.Sprintf("%s.%s.%s", jsonVM.Name, zone, project),
 		Lifetime:               lifetime,
 		Preemptible:            jsonVM.Scheduling.Preemptible,
+		ProvisioningModel:      jsonVM.provisioningModel(),
+		Status:                 jsonVM.Status,
+		Accelerators:           jsonVM.accelerators(),
 		Labels:                 jsonVM.Labels,
 		PrivateIP:              privateIP,
 		Provider:               ProviderName,
@@ -248,6 +406,8 @@ func (jsonVM *jsonVM) toVM(
 		Project:                project,
 		NonBootAttachedVolumes: volumes,
 		LocalDisks:             localDisks,
+		// N.B. this is typically only set for spot instances.
+		InstanceTerminationAction: jsonVM.Scheduling.InstanceTerminationAction,
 	}
 }
 
@@ -268,12 +428,15 @@ func DefaultProviderOpts() *ProviderOpts {
 		Zones:                nil,
 		Image:                DefaultImage,
 		SSDCount:             1,
+		LocalSSDInterface:    "NVME",
 		PDVolumeType:         "pd-ssd",
 		PDVolumeSize:         500,
 		TerminateOnMigration: false,
 		UseSpot:              false,
 		useSharedUser:        true,
 		preemptible:          false,
+		SkipDiskLabels:       false,
+		DryRun:               false,
 	}
 }
 
@@ -287,25 +450,98 @@ type ProviderOpts struct {
 	// projects represent the GCE projects to operate on. Accessed through
 	// GetProject() or GetProjects() depending on whether the command accepts
 	// multiple projects or a single one.
-	MachineType      string
-	MinCPUPlatform   string
-	Zones            []string
-	Image            string
+	MachineType    string
+	MinCPUPlatform string
+	// MinNodeCPUs, if positive, is the minimum number of vCPUs of a custom
+	// machine type.
+	MinNodeCPUs int
+	Zones       []string
+	Image       string
+	// ImageProject, if set, overrides the project in which Image is looked up.
+	// N.B. it is ignored for FIPS-enabled clusters, which always use
+	// FIPSImageProject.
+	ImageProject string
+	// ImageLatest, if set, resolves the image to the newest one in the family
+	// of the default image for the architecture and Ubuntu version, instead of
+	// using Image.
+	ImageLatest      bool
 	SSDCount         int
 	PDVolumeType     string
 	PDVolumeSize     int
 	UseMultipleDisks bool
+	// LocalSSDInterface is the interface, NVME or SCSI, used for all of the
+	// local SSDs, if any.
+	LocalSSDInterface string
+	// Labels are additional labels to apply to the instances, on top of
+	// vm.CreateOpts.CustomLabels and the default labels.
+	Labels map[string]string
+	// LabelsFromFile, if set, is the path of a file of additional labels to
+	// apply to the instances, one key=value pair per line, on top of Labels.
+	LabelsFromFile string
 	// use spot instances (i.e., latest version of preemptibles which can run > 24 hours)
 	UseSpot bool
 
 	// GCE allows two availability policies in case of a maintenance event (see --maintenance-policy via gcloud),
 	// 'TERMINATE' or 'MIGRATE'. The default is 'MIGRATE' which we denote by 'TerminateOnMigration == false'.
 	TerminateOnMigration bool
+	// MaintenancePolicy, if set, is the maintenance policy of the instances,
+	// MIGRATE or TERMINATE, overriding the one derived from
+	// TerminateOnMigration.
+	MaintenancePolicy string
+	// RestartOnFailure controls whether instances are automatically restarted
+	// when terminated by GCE (not by a user). If unset, the gcloud default is
+	// used.
+	RestartOnFailure OptionalBool
 	// useSharedUser indicates that the shared user rather than the personal
 	// user should be used to ssh into the remote machines.
 	useSharedUser bool
 	// use preemptible instances
 	preemptible bool
+	// SkipDiskLabels, if set, skips the propagation of the VM labels to the
+	// boot and persistent disks, which speeds up the creation of large
+	// clusters at the expense of disk-level cost attribution.
+	SkipDiskLabels bool
+	// DryRun, if set, makes Create log the gcloud commands it would run
+	// instead of running them.
+	DryRun bool
+	// ExtraCreateArgs are appended verbatim to the `gcloud compute instances
+	// create` command, after all of the flags set by roachprod. They're an
+	// escape hatch for instance options not modeled by roachprod and aren't
+	// validated in any way.
+	ExtraCreateArgs []string
+	// Hostnames maps instance names to custom guest OS hostnames. Instances
+	// without an entry use the default, internal DNS based hostname.
+	Hostnames map[string]string
+	// StartupScriptTimeout, if non-zero, makes Create wait up to this long for
+	// the startup script of every instance to complete, failing otherwise.
+	StartupScriptTimeout time.Duration
+	// Subnets maps zones or regions to the subnet in which the instances of
+	// the zone, or of all the zones of the region, are placed. If set, it must
+	// cover all of the zones of the instances. Otherwise, the default subnet
+	// is used.
+	Subnets map[string]string
+	// DeletionProtection, if set, protects the instances against deletion,
+	// which then fails until the protection is disabled.
+	DeletionProtection bool
+	// SkipExisting, if set, makes Create skip the instances which already
+	// exist, e.g. when re-running it after a partial failure, provided that
+	// they're in the expected zone and of the expected machine type.
+	SkipExisting bool
+	// WeightedZones, if set, are the zones across which the instances are
+	// placed in proportion to the weights of the zones, see
+	// vm.ParseWeightedZonesFlag. It can't be combined with Zones.
+	WeightedZones []string
+	// MultiProject, if set, makes Create spread the instances across all of
+	// the configured projects, see createInProjects. Otherwise, Create
+	// requires a single project.
+	MultiProject bool
+	// UseMIG, if set, makes Create back the cluster by a regional managed
+	// instance group (MIG) spanning the zones, which recreates instances that
+	// fail, rather than creating the instances directly. The instances keep
+	// the given names, but the MIG picks the zone of each of them. Delete
+	// deletes the instances via the MIG, and the MIG and its instance template
+	// along with the last of them.
+	UseMIG bool
 }
 
 // Provider is the GCE implementation of the vm.Provider interface.
@@ -313,6 +549,23 @@ type Provider struct {
 	vm.DNSProvider
 	Projects       []string
 	ServiceAccount string
+	// AllowedEmailDomains are the domains of the accounts accepted by
+	// FindActiveAccount, e.g. "cockroachlabs.com". If empty, the domains are
+	// read from GCE_ALLOWED_EMAIL_DOMAINS, and default to config.EmailDomain.
+	AllowedEmailDomains []string
+
+	// projectsFromFlag is set when Projects was set explicitly via the
+	// --gce-project flag, in which case ReloadEnv doesn't override it.
+	projectsFromFlag bool
+	// envServiceAccount is the service account last read from the
+	// environment by ReloadEnv.
+	envServiceAccount string
+
+	// zonesCache caches, per project, the set of zones reported by gcloud.
+	zonesCache struct {
+		mu    syncutil.Mutex
+		zones map[string]map[string]struct{}
+	}
 }
 
 // LogEntry represents a single log entry from the gcloud logging(stack driver)
@@ -329,6 +582,12 @@ type LogEntry struct {
 	} `json:"protoPayload"`
 }
 
+// SupportsEncryptedVolumes implements the vm.Provider interface. Customer
+// managed encryption keys (CMEK) aren't supported yet.
+func (p *Provider) SupportsEncryptedVolumes() bool {
+	return false
+}
+
 func (p *Provider) SupportsSpotVMs() bool {
 	return true
 }
@@ -417,14 +676,43 @@ type snapshotJson struct {
 	StorageBytes       string    `json:"storageBytes"`
 	StorageBytesStatus string    `json:"storageBytesStatus"`
 	StorageLocations   []string  `json:"storageLocations"`
+	// N.B. the labels of a snapshot are only listed, since they are added
+	// after its creation.
+	Labels map[string]string `json:"labels"`
+}
+
+// toVolumeSnapshot converts the snapshot to a vm.VolumeSnapshot.
+func (s snapshotJson) toVolumeSnapshot() (vm.VolumeSnapshot, error) {
+	snapshot := vm.VolumeSnapshot{
+		ID:                s.ID,
+		Name:              s.Name,
+		CreationTimestamp: s.CreationTimestamp,
+		Labels:            s.Labels,
+	}
+	if s.DiskSizeGb != "" {
+		size, err := strconv.Atoi(s.DiskSizeGb)
+		if err != nil {
+			return vm.VolumeSnapshot{}, errors.Wrapf(err, "parsing disk size of snapshot %s", s.Name)
+		}
+		snapshot.SizeGB = size
+	}
+	return snapshot, nil
 }
 
 func (p *Provider) CreateVolumeSnapshot(
 	l *logger.Logger, volume vm.Volume, vsco vm.VolumeSnapshotCreateOpts,
+) (vm.VolumeSnapshot, error) {
+	return createVolumeSnapshot(p.GetProject(), volume, vsco)
+}
+
+// createVolumeSnapshot is like CreateVolumeSnapshot, but creates the snapshot
+// in the given project, which must be the project of the volume.
+func createVolumeSnapshot(
+	project string, volume vm.Volume, vsco vm.VolumeSnapshotCreateOpts,
 ) (vm.VolumeSnapshot, error) {
 	args := []string{
 		"compute",
-		"--project", p.GetProject(),
+		"--project", project,
 		"snapshots",
 		"create", vsco.Name,
 		"--source-disk", volume.ProviderResourceID,
@@ -438,27 +726,29 @@ func (p *Provider) CreateVolumeSnapshot(
 		return vm.VolumeSnapshot{}, err
 	}
 
+	snapshot, err := createJsonResponse.toVolumeSnapshot()
+	if err != nil {
+		return vm.VolumeSnapshot{}, err
+	}
+	snapshot.Labels = make(map[string]string, len(vsco.Labels))
 	sb := strings.Builder{}
 	for k, v := range vsco.Labels {
 		fmt.Fprintf(&sb, "%s=%s,", serializeLabel(k), serializeLabel(v))
+		snapshot.Labels[serializeLabel(k)] = serializeLabel(v)
 	}
 	s := sb.String()
 
 	args = []string{
 		"compute",
-		"--project", p.GetProject(),
+		"--project", project,
 		"snapshots",
 		"add-labels", vsco.Name,
 		"--labels", s[:len(s)-1],
 	}
-	cmd := exec.Command("gcloud", args...)
-	if _, err := cmd.CombinedOutput(); err != nil {
+	if _, err := runner.CombinedOutput(context.Background(), args...); err != nil {
 		return vm.VolumeSnapshot{}, err
 	}
-	return vm.VolumeSnapshot{
-		ID:   createJsonResponse.ID,
-		Name: createJsonResponse.Name,
-	}, nil
+	return snapshot, nil
 }
 
 func (p *Provider) ListVolumeSnapshots(
@@ -469,7 +759,7 @@ func (p *Provider) ListVolumeSnapshots(
 		"--project", p.GetProject(),
 		"snapshots",
 		"list",
-		"--format", "json(name,id)",
+		"--format", "json(name,id,creationTimestamp,diskSizeGb,labels)",
 	}
 	var filters []string
 	if vslo.NamePrefix != "" {
@@ -495,10 +785,11 @@ func (p *Provider) ListVolumeSnapshots(
 		if !strings.HasPrefix(snapshotJson.Name, vslo.NamePrefix) {
 			continue
 		}
-		snapshots = append(snapshots, vm.VolumeSnapshot{
-			ID:   snapshotJson.ID,
-			Name: snapshotJson.Name,
-		})
+		snapshot, err := snapshotJson.toVolumeSnapshot()
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
 	}
 	sort.Sort(vm.VolumeSnapshots(snapshots))
 	return snapshots, nil
@@ -518,8 +809,7 @@ func (p *Provider) DeleteVolumeSnapshots(l *logger.Logger, snapshots ...vm.Volum
 		args = append(args, snapshot.Name)
 	}
 
-	cmd := exec.Command("gcloud", args...)
-	if _, err := cmd.CombinedOutput(); err != nil {
+	if _, err := runner.CombinedOutput(context.Background(), args...); err != nil {
 		return err
 	}
 	return nil
@@ -532,6 +822,8 @@ type describeVolumeCommandResponse struct {
 	LabelFingerprint       string            `json:"labelFingerprint"`
 	Name                   string            `json:"name"`
 	PhysicalBlockSizeBytes string            `json:"physicalBlockSizeBytes"`
+	ProvisionedIops        string            `json:"provisionedIops"`
+	ProvisionedThroughput  string            `json:"provisionedThroughput"`
 	SelfLink               string            `json:"selfLink"`
 	SizeGB                 string            `json:"sizeGb"`
 	Status                 string            `json:"status"`
@@ -549,6 +841,27 @@ func (p *Provider) CreateVolume(
 		err = errors.New("Creating a volume with IOPS is not supported at this time.")
 		return vol, err
 	}
+	if vco.Zone == "" {
+		if vco.TargetVM == nil {
+			return vol, errors.New("Either a zone or a target VM must be specified for the volume")
+		}
+		vco.Zone = vco.TargetVM.Zone
+	}
+	if vco.TargetVM != nil && vco.TargetVM.Zone != vco.Zone {
+		return vol, errors.Newf("volume %s is in zone %s, but VM %s is in zone %s; disks can only be attached to VMs in the same zone",
+			vco.Name, vco.Zone, vco.TargetVM.Name, vco.TargetVM.Zone)
+	}
+	if vco.Size == 0 {
+		return vol, errors.New("Cannot create a volume of size 0")
+	}
+	if vco.Encrypted && !p.SupportsEncryptedVolumes() {
+		return vol, errors.New("Volume encryption is not implemented for GCP")
+	}
+	if vco.AutoSuffixName {
+		if vco.Name, err = p.uniqueDiskName(vco.Name, vco.Zone); err != nil {
+			return vol, err
+		}
+	}
 	args := []string{
 		"compute",
 		"--project", p.GetProject(),
@@ -561,13 +874,18 @@ func (p *Provider) CreateVolume(
 	if vco.SourceSnapshotID != "" {
 		args = append(args, "--source-snapshot", vco.SourceSnapshotID)
 	}
-
-	if vco.Size == 0 {
-		return vol, errors.New("Cannot create a volume of size 0")
-	}
-
-	if vco.Encrypted {
-		return vol, errors.New("Volume encryption is not implemented for GCP")
+	if vco.InheritSnapshotLabels {
+		if vco.SourceSnapshotID == "" {
+			return vol, errors.New("Cannot inherit snapshot labels without a source snapshot")
+		}
+		labels, err := p.snapshotLabels(vco.SourceSnapshotID)
+		if err != nil {
+			return vol, err
+		}
+		for k, v := range vco.Labels {
+			labels[k] = v
+		}
+		vco.Labels = labels
 	}
 
 	if vco.Architecture != "" {
@@ -598,9 +916,17 @@ func (p *Provider) CreateVolume(
 
 	createdVolume := commandResponse[0]
 
-	size, err := strconv.Atoi(createdVolume.SizeGB)
-	if err != nil {
-		return vol, err
+	if !vco.SkipDefaultLabels {
+		// Attribute the disk like Create does for instances. Labels passed by the
+		// caller take precedence.
+		labels := map[string]string{
+			vm.TagCreated:   createdLabel(timeutil.Now()),
+			vm.TagCreatedBy: config.OSUser.Username,
+		}
+		for k, v := range vco.Labels {
+			labels[k] = v
+		}
+		vco.Labels = labels
 	}
 	if len(vco.Labels) > 0 {
 		sb := strings.Builder{}
@@ -616,23 +942,177 @@ func (p *Provider) CreateVolume(
 			"--labels", s[:len(s)-1],
 			"--zone", vco.Zone,
 		}
-		cmd := exec.Command("gcloud", args...)
-		if _, err := cmd.CombinedOutput(); err != nil {
+		if _, err := runner.CombinedOutput(context.Background(), args...); err != nil {
 			return vm.Volume{}, err
 		}
 	}
 
+	return createdVolume.toVolume()
+}
+
+// maxDiskNameSuffixAttempts is the number of random suffixes tried by
+// uniqueDiskName before giving up.
+const maxDiskNameSuffixAttempts = 5
+
+// diskNameSuffix returns a random token appended to disk names which collide
+// with an existing disk. It's a variable so that tests can make it
+// deterministic.
+var diskNameSuffix = func() string {
+	rng, _ := randutil.NewPseudoRand()
+	return randutil.RandString(rng, 6, "abcdefghijklmnopqrstuvwxyz0123456789")
+}
+
+// diskExists returns whether a disk with the given name exists in the zone.
+func (p *Provider) diskExists(name, zone string) (bool, error) {
+	args := []string{
+		"compute",
+		"--project", p.GetProject(),
+		"disks",
+		"list",
+		"--zones", zone,
+		"--filter", fmt.Sprintf("name=%s", name),
+		"--format", "json(name)",
+	}
+	var disks []struct {
+		Name string `json:"name"`
+	}
+	if err := runJSONCommand(args, &disks); err != nil {
+		return false, errors.Wrapf(err, "checking whether disk %s exists in zone %s", name, zone)
+	}
+	for _, d := range disks {
+		// N.B. the filter matches names by pattern, so check for an exact match.
+		if d.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// uniqueDiskName returns the given name with a random suffix appended, if a
+// disk with the given name already exists in the zone, such that the name no
+// longer collides.
+func (p *Provider) uniqueDiskName(name, zone string) (string, error) {
+	exists, err := p.diskExists(name, zone)
+	if err != nil || !exists {
+		return name, err
+	}
+	for i := 0; i < maxDiskNameSuffixAttempts; i++ {
+		candidate := fmt.Sprintf("%s-%s", name, diskNameSuffix())
+		exists, err := p.diskExists(candidate, zone)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+	}
+	return "", errors.Newf("unable to find an unused name for disk %s in zone %s after %d attempts",
+		name, zone, maxDiskNameSuffixAttempts)
+}
+
+// maxConcurrentVolumeCreations is the maximum number of volumes created
+// concurrently by CreateVolumes.
+const maxConcurrentVolumeCreations = 8
+
+// CreateVolumes creates the given volumes concurrently, see CreateVolume. On
+// error, the volumes which were successfully created are returned along with
+// an error naming the ones which weren't.
+func (p *Provider) CreateVolumes(
+	l *logger.Logger, specs []vm.VolumeCreateOpts,
+) ([]vm.Volume, error) {
+	volumes := make([]vm.Volume, len(specs))
+	errs := make([]error, len(specs))
+	var g errgroup.Group
+	g.SetLimit(maxConcurrentVolumeCreations)
+	for i := range specs {
+		i := i
+		g.Go(func() error {
+			volumes[i], errs[i] = p.CreateVolume(l, specs[i])
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	var created []vm.Volume
+	var err error
+	for i := range specs {
+		if errs[i] != nil {
+			err = errors.CombineErrors(err, errors.Wrapf(errs[i], "creating volume %s", specs[i].Name))
+			continue
+		}
+		created = append(created, volumes[i])
+	}
+	return created, err
+}
+
+// snapshotLabels returns the labels of the given snapshot.
+func (p *Provider) snapshotLabels(snapshot string) (map[string]string, error) {
+	args := []string{
+		"compute",
+		"--project", p.GetProject(),
+		"snapshots",
+		"describe", snapshot,
+		"--format", "json(labels)",
+	}
+	var describeResponse struct {
+		Labels map[string]string `json:"labels"`
+	}
+	if err := runJSONCommand(args, &describeResponse); err != nil {
+		return nil, err
+	}
+	if describeResponse.Labels == nil {
+		return make(map[string]string), nil
+	}
+	return describeResponse.Labels, nil
+}
+
+// toVolume converts the gcloud description of a disk into a vm.Volume.
+func (r describeVolumeCommandResponse) toVolume() (vm.Volume, error) {
+	size, err := strconv.Atoi(r.SizeGB)
+	if err != nil {
+		return vm.Volume{}, err
+	}
+	iops, err := parseOptionalInt(r.ProvisionedIops)
+	if err != nil {
+		return vm.Volume{}, errors.Wrapf(err, "invalid provisioned IOPS")
+	}
+	throughput, err := parseOptionalInt(r.ProvisionedThroughput)
+	if err != nil {
+		return vm.Volume{}, errors.Wrapf(err, "invalid provisioned throughput")
+	}
+	blockSize, err := parseOptionalInt(r.PhysicalBlockSizeBytes)
+	if err != nil {
+		return vm.Volume{}, errors.Wrapf(err, "invalid physical block size")
+	}
 	return vm.Volume{
-		ProviderResourceID: createdVolume.Name,
-		ProviderVolumeType: lastComponent(createdVolume.Type),
-		Zone:               lastComponent(createdVolume.Zone),
-		Encrypted:          false, // only used for aws
-		Name:               createdVolume.Name,
-		Labels:             createdVolume.Labels,
-		Size:               size,
+		ProviderResourceID:     r.Name,
+		ProviderVolumeType:     lastComponent(r.Type),
+		Zone:                   lastComponent(r.Zone),
+		Encrypted:              false, // only used for aws
+		Name:                   r.Name,
+		Labels:                 r.Labels,
+		Size:                   size,
+		IOPS:                   iops,
+		Throughput:             throughput,
+		PhysicalBlockSizeBytes: blockSize,
 	}, nil
 }
 
+// createdLabel formats the given creation time as the value of the
+// vm.TagCreated label, according to the GCE label naming requirements.
+func createdLabel(t time.Time) string {
+	return strings.ToLower(strings.ReplaceAll(t.Format(time.RFC3339), ":", "_"))
+}
+
+// parseOptionalInt parses an integer reported by gcloud, which is omitted
+// when not applicable, in which case 0 is returned.
+func parseOptionalInt(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
 func (p *Provider) DeleteVolume(l *logger.Logger, volume vm.Volume, vm *vm.VM) error {
 	{ // Detach disks.
 		args := []string{
@@ -643,8 +1123,7 @@ func (p *Provider) DeleteVolume(l *logger.Logger, volume vm.Volume, vm *vm.VM) e
 			"--disk", volume.ProviderResourceID,
 			"--zone", volume.Zone,
 		}
-		cmd := exec.Command("gcloud", args...)
-		if _, err := cmd.CombinedOutput(); err != nil {
+		if _, err := runner.CombinedOutput(context.Background(), args...); err != nil {
 			return err
 		}
 	}
@@ -658,8 +1137,7 @@ func (p *Provider) DeleteVolume(l *logger.Logger, volume vm.Volume, vm *vm.VM) e
 			"--zone", volume.Zone,
 			"--quiet",
 		}
-		cmd := exec.Command("gcloud", args...)
-		if _, err := cmd.CombinedOutput(); err != nil {
+		if _, err := runner.CombinedOutput(context.Background(), args...); err != nil {
 			return err
 		}
 	}
@@ -719,20 +1197,11 @@ func (p *Provider) ListVolumes(l *logger.Logger, v *vm.VM) ([]vm.Volume, error)
 		if attachedDisk.Boot {
 			continue
 		}
-		describedVolume := describedVolumes[idx]
-		size, err := strconv.Atoi(describedVolume.SizeGB)
+		volume, err := describedVolumes[idx].toVolume()
 		if err != nil {
 			return nil, err
 		}
-		volumes = append(volumes, vm.Volume{
-			ProviderResourceID: describedVolume.Name,
-			ProviderVolumeType: lastComponent(describedVolume.Type),
-			Zone:               lastComponent(describedVolume.Zone),
-			Encrypted:          false, // only used for aws
-			Name:               describedVolume.Name,
-			Labels:             describedVolume.Labels,
-			Size:               size,
-		})
+		volumes = append(volumes, volume)
 	}
 
 	// TODO(irfansharif): Update v.NonBootAttachedVolumes? It's awkward to have
@@ -740,6 +1209,90 @@ func (p *Provider) ListVolumes(l *logger.Logger, v *vm.VM) ([]vm.Volume, error)
 	return volumes, nil
 }
 
+// volumeReadyRetryOpts are the retry options used to poll the status of a
+// volume in WaitForVolumeReady.
+var volumeReadyRetryOpts = retry.Options{
+	InitialBackoff: time.Second,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+}
+
+// WaitForVolumeReady waits until the given volume is READY, or the timeout
+// elapses. CreateVolume returns once the disk is created, but it may still be
+// provisioning, in which case attaching it fails. A disk which FAILED to be
+// created is an error.
+func (p *Provider) WaitForVolumeReady(
+	l *logger.Logger, volume vm.Volume, timeout time.Duration,
+) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	args := []string{
+		"compute",
+		"--project", p.GetProject(),
+		"disks",
+		"describe", volume.ProviderResourceID,
+		"--zone", volume.Zone,
+		"--format", "json(status)",
+	}
+	var status string
+	for r := retry.StartWithCtx(ctx, volumeReadyRetryOpts); r.Next(); {
+		var described struct {
+			Status string `json:"status"`
+		}
+		if err := runJSONCommand(args, &described); err != nil {
+			return err
+		}
+		status = described.Status
+		switch status {
+		case "READY":
+			return nil
+		case "FAILED":
+			return errors.Newf("volume %s failed to be created", volume.ProviderResourceID)
+		}
+		l.Printf("Waiting for volume %s to be READY (status: %s)", volume.ProviderResourceID, status)
+	}
+	return errors.Newf("timed out after %s waiting for volume %s to be READY (status: %s)",
+		timeout, volume.ProviderResourceID, status)
+}
+
+// ListOrphanedVolumes returns the disks of the given project (or of the
+// provider's project, if empty) which aren't attached to any instance, e.g.
+// because their instance was deleted without deleting its disks. These linger
+// until they're deleted explicitly.
+func (p *Provider) ListOrphanedVolumes(l *logger.Logger, project string) ([]vm.Volume, error) {
+	if project == "" {
+		project = p.GetProject()
+	}
+	args := []string{
+		"compute",
+		"disks",
+		"list",
+		"--project", project,
+		"--filter", "-users:*",
+		"--format", "json",
+	}
+	var describedVolumes []describeVolumeCommandResponse
+	if err := runJSONCommand(args, &describedVolumes); err != nil {
+		return nil, err
+	}
+
+	var volumes []vm.Volume
+	for _, describedVolume := range describedVolumes {
+		// N.B. gcloud filters the disks already, but they're checked again so
+		// that disks in use are never returned, e.g. to be deleted.
+		if len(describedVolume.Users) > 0 {
+			continue
+		}
+		volume, err := describedVolume.toVolume()
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes, nil
+}
+
 type instanceDisksResponse struct {
 	// Disks that are attached to the instance.
 	// N.B. Unattached disks can be enumerated via,
@@ -760,6 +1313,38 @@ type attachDiskCmdDisk struct {
 }
 
 func (p *Provider) AttachVolume(l *logger.Logger, volume vm.Volume, vm *vm.VM) (string, error) {
+	return p.AttachVolumeWithDeviceName(l, volume, "" /* deviceName */, vm)
+}
+
+// AttachVolumeWithDeviceName is like AttachVolume, but attaches the volume
+// under the given device name, which allows for deterministic device paths
+// when attaching multiple disks. If deviceName is empty, the volume's
+// ProviderResourceID is used. It returns the device path of the attached disk.
+func (p *Provider) AttachVolumeWithDeviceName(
+	l *logger.Logger, volume vm.Volume, deviceName string, vm *vm.VM,
+) (string, error) {
+	return p.AttachVolumeWithOpts(l, volume, AttachVolumeOpts{DeviceName: deviceName}, vm)
+}
+
+// AttachVolumeOpts are the options of AttachVolumeWithOpts.
+type AttachVolumeOpts struct {
+	// DeviceName is the device name under which the volume is attached, see
+	// AttachVolumeWithDeviceName.
+	DeviceName string
+	// KeepOnTerminate, if set, keeps the volume when the VM is deleted, e.g. to
+	// snapshot it later, instead of setting it to auto-delete.
+	KeepOnTerminate bool
+}
+
+// AttachVolumeWithOpts is like AttachVolume, but with the given options. It
+// returns the device path of the attached disk.
+func (p *Provider) AttachVolumeWithOpts(
+	l *logger.Logger, volume vm.Volume, opts AttachVolumeOpts, vm *vm.VM,
+) (string, error) {
+	deviceName := opts.DeviceName
+	if deviceName == "" {
+		deviceName = volume.ProviderResourceID
+	}
 	// Volume attach.
 	args := []string{
 		"compute",
@@ -768,7 +1353,7 @@ func (p *Provider) AttachVolume(l *logger.Logger, volume vm.Volume, vm *vm.VM) (
 		"attach-disk",
 		vm.ProviderID,
 		"--disk", volume.ProviderResourceID,
-		"--device-name", volume.ProviderResourceID,
+		"--device-name", deviceName,
 		"--zone", vm.Zone,
 		"--format=json(disks)",
 	}
@@ -777,81 +1362,271 @@ func (p *Provider) AttachVolume(l *logger.Logger, volume vm.Volume, vm *vm.VM) (
 	if err := runJSONCommand(args, &commandResponse); err != nil {
 		return "", err
 	}
-	found := false
 	if len(commandResponse) != 1 {
 		return "", errors.Newf("Expected to get back json with just a single item got %d", len(commandResponse))
 	}
-	cmdRespDisks := commandResponse[0].Disks
-	for _, response := range cmdRespDisks {
-		found = found || strings.Contains(response.Source, volume.ProviderResourceID)
+	hasDisk := func(disks []attachDiskCmdDisk) bool {
+		for _, response := range disks {
+			if strings.Contains(response.Source, volume.ProviderResourceID) {
+				return true
+			}
+		}
+		return false
+	}
+	if !hasDisk(commandResponse[0].Disks) {
+		// N.B. GCE may not list the disk among the disks of the instance right
+		// after attaching it, so describe the instance again before concluding
+		// that the attachment failed.
+		found := false
+		for r := retry.Start(attachDiskRetryOpts); r.Next(); {
+			var described instanceDisksResponse
+			if err := runJSONCommand([]string{
+				"compute", "instances", "describe", vm.ProviderID,
+				"--project", p.GetProject(),
+				"--zone", vm.Zone,
+				"--format", "json(disks)",
+			}, &described); err != nil {
+				return "", err
+			}
+			if found = hasDisk(described.Disks); found {
+				break
+			}
+		}
+		if !found {
+			return "", errors.Newf("Could not find created disk '%s' in list of disks for %s",
+				volume.ProviderResourceID, vm.ProviderID)
+		}
 	}
-	if !found {
-		return "", errors.Newf("Could not find created disk '%s' in list of disks for %s",
-			volume.ProviderResourceID, vm.ProviderID)
+
+	// Volume auto delete. N.B. a disk which is kept is explicitly set not to
+	// auto-delete as well, so that its state is verified rather than assumed.
+	if err := p.setDiskAutoDelete(vm, volume, deviceName, !opts.KeepOnTerminate); err != nil {
+		return "", err
 	}
 
-	// Volume auto delete.
-	args = []string{
+	return "/dev/disk/by-id/google-" + deviceName, nil
+}
+
+// setDiskAutoDelete sets whether the disk attached to the given VM under the
+// given device name is deleted along with the VM, and verifies the outcome.
+func (p *Provider) setDiskAutoDelete(
+	vm *vm.VM, volume vm.Volume, deviceName string, autoDelete bool,
+) error {
+	flag, desc := "--auto-delete", "to auto-delete"
+	if !autoDelete {
+		flag, desc = "--no-auto-delete", "not to auto-delete"
+	}
+	args := []string{
 		"compute",
 		"--project", p.GetProject(),
 		"instances",
 		"set-disk-auto-delete", vm.ProviderID,
-		"--auto-delete",
-		"--device-name", volume.ProviderResourceID,
+		flag,
+		"--device-name", deviceName,
 		"--zone", vm.Zone,
 		"--format=json(disks)",
 	}
 
+	var commandResponse []instanceDisksResponse
 	if err := runJSONCommand(args, &commandResponse); err != nil {
-		return "", err
+		return err
 	}
 
 	if len(commandResponse) != 1 {
-		return "", errors.Newf("Expected to get back json with just a single item got %d", len(commandResponse))
+		return errors.Newf("Expected to get back json with just a single item got %d", len(commandResponse))
 	}
-	cmdRespDisks = commandResponse[0].Disks
-	for _, response := range cmdRespDisks {
-		if response.DeviceName == volume.ProviderResourceID && !response.AutoDelete {
-			return "", errors.Newf("Could not set disk '%s' to auto-delete on instance termination",
-				volume.ProviderResourceID)
+	for _, response := range commandResponse[0].Disks {
+		if response.DeviceName == deviceName && response.AutoDelete != autoDelete {
+			return errors.Newf("Could not set disk '%s' %s on instance termination",
+				volume.ProviderResourceID, desc)
 		}
 	}
+	return nil
+}
 
-	return "/dev/disk/by-id/google-" + volume.ProviderResourceID, nil
+// VolumeAttachment pairs a volume with the VM it is to be attached to.
+type VolumeAttachment struct {
+	Vol vm.Volume
+	VM  *vm.VM
 }
 
-// ProjectsVal is the implementation for the --gce-projects flag. It populates
-// (Provider.Projects).
-type ProjectsVal struct {
-	AcceptMultipleProjects bool
+// maxConcurrentVolumeAttachments is the maximum number of volumes attached
+// concurrently by AttachVolumesToVMs.
+const maxConcurrentVolumeAttachments = 8
+
+// AttachVolumesToVMs attaches the given volumes to their VMs concurrently, see
+// AttachVolume. It returns the device paths of the attached volumes, keyed by
+// disk name (ProviderResourceID). On error, the paths of the volumes which were
+// successfully attached are returned along with an error naming the ones which
+// weren't.
+func (p *Provider) AttachVolumesToVMs(
+	l *logger.Logger, pairs []VolumeAttachment,
+) (map[string]string, error) {
+	paths := make([]string, len(pairs))
+	errs := make([]error, len(pairs))
+	var g errgroup.Group
+	g.SetLimit(maxConcurrentVolumeAttachments)
+	for i := range pairs {
+		i := i
+		g.Go(func() error {
+			paths[i], errs[i] = p.AttachVolume(l, pairs[i].Vol, pairs[i].VM)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	attached := make(map[string]string, len(pairs))
+	var err error
+	for i, pair := range pairs {
+		if errs[i] != nil {
+			err = errors.CombineErrors(err, errors.Wrapf(errs[i], "attaching volume %s to %s",
+				pair.Vol.ProviderResourceID, pair.VM.Name))
+			continue
+		}
+		attached[pair.Vol.ProviderResourceID] = paths[i]
+	}
+	return attached, err
 }
 
-// defaultZones is the list of  zones used by default for cluster creation.
-// If the geo flag is specified, nodes are distributed between zones.
-// These are GCP zones available according to this page:
-// https://cloud.google.com/compute/docs/regions-zones#available
-var defaultZones = []string{
-	"us-east1-b",
-	"us-west1-b",
-	"europe-west2-b",
-	"us-east1-c",
-	"us-west1-c",
-	"europe-west2-c",
-	"us-east1-d",
-	"us-west1-a",
-	"europe-west2-a",
+// attachDiskRetryOpts are the retry options used when an attached disk isn't
+// listed among the disks of the instance yet.
+var attachDiskRetryOpts = retry.Options{
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	MaxRetries:     5,
 }
 
-// Set is part of the pflag.Value interface.
-func (v ProjectsVal) Set(projects string) error {
-	if projects == "" {
-		return fmt.Errorf("empty GCE project")
-	}
+// AttachExistingDiskByName attaches the disk with the given name and zone to
+// the target VM. Unlike AttachVolume, the disk doesn't need to come from
+// CreateVolume: it can have been created out of band, e.g. restored from a
+// snapshot separately. It returns the device path of the attached disk.
+func (p *Provider) AttachExistingDiskByName(
+	l *logger.Logger, diskName, zone string, target *vm.VM,
+) (string, error) {
+	args := []string{
+		"compute",
+		"--project", p.GetProject(),
+		"disks",
+		"describe", diskName,
+		"--zone", zone,
+		"--format", "json",
+	}
+	var describedVolume describeVolumeCommandResponse
+	if err := runJSONCommand(args, &describedVolume); err != nil {
+		return "", err
+	}
+	volume, err := describedVolume.toVolume()
+	if err != nil {
+		return "", err
+	}
+	if volume.Zone != target.Zone {
+		return "", errors.Newf("disk %s is in zone %s, but VM %s is in zone %s; "+
+			"disks can only be attached to VMs in the same zone",
+			diskName, volume.Zone, target.Name, target.Zone)
+	}
+	return p.AttachVolume(l, volume, target)
+}
+
+// RestoreSnapshotToVM creates a disk of the given size and type from the
+// given snapshot, in the zone of the target VM, and attaches it to the target.
+// Following the GCE naming convention, the disk is named after the VM and the
+// number of its non-boot volumes, e.g. <vm>-1 for the first one. It returns
+// the created volume and its device path; if attaching fails, the created
+// volume is still returned so that the caller can clean it up.
+func (p *Provider) RestoreSnapshotToVM(
+	l *logger.Logger, snapshotID string, target *vm.VM, size int, diskType string,
+) (vm.Volume, string, error) {
+	vco := vm.VolumeCreateOpts{
+		Name:             fmt.Sprintf("%s-%d", target.Name, len(target.NonBootAttachedVolumes)+1),
+		Size:             size,
+		Type:             diskType,
+		SourceSnapshotID: snapshotID,
+		Zone:             target.Zone,
+		// N.B. CreateVolume adds the created and created-by labels.
+		Labels: map[string]string{
+			vm.TagLifetime:  target.Lifetime.String(),
+			vm.TagRoachprod: "true",
+		},
+	}
+	if cluster, ok := target.Labels[vm.TagCluster]; ok {
+		vco.Labels[vm.TagCluster] = cluster
+	}
+	volume, err := p.CreateVolume(l, vco)
+	if err != nil {
+		return vm.Volume{}, "", errors.Wrapf(err, "creating volume from snapshot %s", snapshotID)
+	}
+	device, err := p.AttachVolume(l, volume, target)
+	if err != nil {
+		return volume, "", errors.Wrapf(err, "attaching volume %s to %s", volume.ProviderResourceID, target.Name)
+	}
+	return volume, device, nil
+}
+
+// OptionalBool is a pflag.Value for tri-state boolean flags: unset, true or
+// false.
+type OptionalBool struct {
+	IsSet bool
+	Value bool
+}
+
+var _ pflag.Value = &OptionalBool{}
+
+// Set is part of the pflag.Value interface.
+func (b *OptionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*b = OptionalBool{IsSet: true, Value: v}
+	return nil
+}
+
+// String is part of the pflag.Value interface.
+func (b *OptionalBool) String() string {
+	if !b.IsSet {
+		return "unset"
+	}
+	return strconv.FormatBool(b.Value)
+}
+
+// Type is part of the pflag.Value interface.
+func (b *OptionalBool) Type() string {
+	return "bool"
+}
+
+// ProjectsVal is the implementation for the --gce-projects flag. It populates
+// (Provider.Projects).
+type ProjectsVal struct {
+	AcceptMultipleProjects bool
+}
+
+// defaultZones is the list of  zones used by default for cluster creation.
+// If the geo flag is specified, nodes are distributed between zones.
+// These are GCP zones available according to this page:
+// https://cloud.google.com/compute/docs/regions-zones#available
+var defaultZones = []string{
+	"us-east1-b",
+	"us-west1-b",
+	"europe-west2-b",
+	"us-east1-c",
+	"us-west1-c",
+	"europe-west2-c",
+	"us-east1-d",
+	"us-west1-a",
+	"europe-west2-a",
+}
+
+// Set is part of the pflag.Value interface.
+func (v ProjectsVal) Set(projects string) error {
+	if projects == "" {
+		return fmt.Errorf("empty GCE project")
+	}
 	prj := strings.Split(projects, ",")
 	if !v.AcceptMultipleProjects && len(prj) > 1 {
 		return fmt.Errorf("multiple GCE projects not supported for command")
 	}
 	providerInstance.Projects = prj
+	providerInstance.projectsFromFlag = true
 	return nil
 }
 
@@ -868,6 +1643,25 @@ func (v ProjectsVal) String() string {
 	return strings.Join(providerInstance.Projects, ",")
 }
 
+// ReloadEnv (re-)reads the GCE_PROJECT and GCE_SERVICE_ACCOUNT environment
+// variables, so that long-lived processes can switch projects without
+// re-initializing the provider. Values set explicitly via flags take
+// precedence and are preserved.
+func (p *Provider) ReloadEnv() {
+	if !p.projectsFromFlag {
+		p.Projects = []string{defaultProject}
+		if projectFromEnv := os.Getenv("GCE_PROJECT"); projectFromEnv != "" {
+			p.Projects = []string{projectFromEnv}
+		}
+	}
+	// N.B. the service account flag defaults to the value read from the
+	// environment, hence it was set explicitly iff it differs from that value.
+	if p.ServiceAccount == p.envServiceAccount {
+		p.envServiceAccount = os.Getenv("GCE_SERVICE_ACCOUNT")
+		p.ServiceAccount = p.envServiceAccount
+	}
+}
+
 // GetProject returns the GCE project on which we're configured to operate.
 // If multiple projects were configured, this panics.
 func (p *Provider) GetProject() string {
@@ -898,13 +1692,26 @@ func (o *ProviderOpts) ConfigureCreateFlags(flags *pflag.FlagSet) {
 		"Machine type (see https://cloud.google.com/compute/docs/machine-types)")
 	flags.StringVar(&o.MinCPUPlatform, ProviderName+"-min-cpu-platform", "Intel Ice Lake",
 		"Minimum CPU platform (see https://cloud.google.com/compute/docs/instances/specify-min-cpu-platform)")
+	flags.IntVar(&o.MinNodeCPUs, ProviderName+"-min-node-cpus", 0,
+		"Minimum number of vCPUs of custom machine types, e.g. n2-custom-8-65536; "+
+			"creation fails if the machine type has fewer")
 	flags.StringVar(&o.Image, ProviderName+"-image", DefaultImage,
 		"Image to use to create the vm, "+
 			"use `gcloud compute images list --filter=\"family=ubuntu-2004-lts\"` to list available images. "+
 			"Note: this option is ignored if --fips is passed.")
+	flags.StringVar(&o.ImageProject, ProviderName+"-image-project", "",
+		"Project in which to look up the image passed via --"+ProviderName+"-image "+
+			"(default "+defaultImageProject+"). Note: this option is ignored if --fips is passed.")
+
+	flags.BoolVar(&o.ImageLatest, ProviderName+"-image-latest", false,
+		"Use the newest Ubuntu image of the family corresponding to the architecture "+
+			"(amd64, arm64 or fips) and Ubuntu version, instead of the pinned image. "+
+			"Note: this option overrides --"+ProviderName+"-image.")
 
 	flags.IntVar(&o.SSDCount, ProviderName+"-local-ssd-count", 1,
 		"Number of local SSDs to create, only used if local-ssd=true")
+	flags.StringVar(&o.LocalSSDInterface, ProviderName+"-local-ssd-interface", "NVME",
+		"Interface of the local SSDs, NVME or SCSI, only used if local-ssd=true")
 	flags.StringVar(&o.PDVolumeType, ProviderName+"-pd-volume-type", "pd-ssd",
 		"Type of the persistent disk volume, only used if local-ssd=false")
 	flags.IntVar(&o.PDVolumeSize, ProviderName+"-pd-volume-size", 500,
@@ -913,17 +1720,62 @@ func (o *ProviderOpts) ConfigureCreateFlags(flags *pflag.FlagSet) {
 		false, "Enable the use of multiple stores by creating one store directory per disk. "+
 			"Default is to raid0 stripe all disks.")
 
+	flags.StringToStringVar(&o.Labels, ProviderName+"-labels", nil,
+		"Additional labels to apply to the instances, in key=value,key2=value2 format. "+
+			"Keys and values are sanitized according to the GCE label naming requirements.")
+	flags.StringVar(&o.LabelsFromFile, ProviderName+"-labels-from-file", "",
+		"File of additional labels to apply to the instances, one key=value pair per line. "+
+			"Empty lines and lines starting with # are ignored.")
 	flags.StringSliceVar(&o.Zones, ProviderName+"-zones", nil,
 		fmt.Sprintf("Zones for cluster. If zones are formatted as AZ:N where N is an integer, the zone\n"+
 			"will be repeated N times. If > 1 zone specified, nodes will be geo-distributed\n"+
 			"regardless of geo (default [%s])",
 			strings.Join(defaultZones, ",")))
+	flags.StringSliceVar(&o.WeightedZones, ProviderName+"-zones-weighted", nil,
+		"Zones for cluster, formatted as AZ:W where W is the weight of the zone (default 1).\n"+
+			"Nodes are distributed across the zones in proportion to their weights.\n"+
+			"Cannot be combined with --"+ProviderName+"-zones")
 	flags.BoolVar(&o.preemptible, ProviderName+"-preemptible", false,
 		"use preemptible GCE instances (lifetime cannot exceed 24h)")
 	flags.BoolVar(&o.UseSpot, ProviderName+"-use-spot", false,
 		"use spot GCE instances (like preemptible but lifetime can exceed 24h)")
 	flags.BoolVar(&o.TerminateOnMigration, ProviderName+"-terminateOnMigration", false,
 		"use 'TERMINATE' maintenance policy (for GCE live migrations)")
+	flags.StringVar(&o.MaintenancePolicy, ProviderName+"-maintenance-policy", "",
+		"maintenance policy of the instances, MIGRATE or TERMINATE; "+
+			"overrides --"+ProviderName+"-terminateOnMigration when set")
+	flags.Var(&o.RestartOnFailure, ProviderName+"-restart-on-failure",
+		"automatically restart instances terminated by GCE (default: unset, i.e. the gcloud default)")
+	flags.Lookup(ProviderName + "-restart-on-failure").NoOptDefVal = "true"
+	flags.BoolVar(&o.SkipDiskLabels, ProviderName+"-skip-disk-labels", false,
+		"skip propagating the VM labels to the disks, which speeds up the creation of large clusters")
+	flags.BoolVar(&o.DryRun, ProviderName+"-dry-run", false,
+		"print the gcloud commands which would be run to create the VMs, without running them")
+	flags.StringSliceVar(&o.ExtraCreateArgs, ProviderName+"-extra-create-args", nil,
+		"Additional arguments appended verbatim to `gcloud compute instances create`, "+
+			"e.g. --"+ProviderName+"-extra-create-args=--enable-nested-virtualization. "+
+			"Note: these are not validated, use at your own risk.")
+	flags.StringToStringVar(&o.Hostnames, ProviderName+"-hostnames", nil,
+		"Custom guest OS hostnames, in instance-name=hostname format, "+
+			"e.g. --"+ProviderName+"-hostnames=test-0001=db1.example.com. "+
+			"Hostnames must be fully qualified domain names.")
+	flags.DurationVar(&o.StartupScriptTimeout, ProviderName+"-startup-script-timeout", 0,
+		"if non-zero, wait up to this long for the startup script of every instance to complete, "+
+			"and fail the creation otherwise")
+	flags.StringToStringVar(&o.Subnets, ProviderName+"-subnets", nil,
+		"Subnets in which to place the instances, per zone or region, in zone-or-region=subnet format, "+
+			"e.g. --"+ProviderName+"-subnets=us-east1=east-subnet,us-west1-b=west-subnet. "+
+			"If set, all zones must be covered (default: the default subnet)")
+	flags.BoolVar(&o.DeletionProtection, ProviderName+"-deletion-protection", false,
+		"protect the instances against deletion, e.g. for long-lived clusters")
+	flags.BoolVar(&o.SkipExisting, ProviderName+"-skip-existing", false,
+		"skip the instances which already exist, e.g. to retry a partially failed creation; "+
+			"the existing instances must be in the expected zone and of the expected machine type")
+	flags.BoolVar(&o.MultiProject, ProviderName+"-multi-project", false,
+		"spread the instances across all of the configured projects in a round-robin fashion")
+	flags.BoolVar(&o.UseMIG, ProviderName+"-use-mig", false,
+		"back the cluster by a self-healing regional managed instance group spanning the zones, "+
+			"which must be in a single region")
 }
 
 // ConfigureClusterFlags implements vm.ProviderFlags.
@@ -952,9 +1804,7 @@ func (o *ProviderOpts) ConfigureClusterFlags(flags *pflag.FlagSet, opt vm.Multip
 func (p *Provider) CleanSSH(l *logger.Logger) error {
 	for _, prj := range p.GetProjects() {
 		args := []string{"compute", "config-ssh", "--project", prj, "--quiet", "--remove"}
-		cmd := exec.Command("gcloud", args...)
-
-		output, err := cmd.CombinedOutput()
+		output, err := runner.CombinedOutput(context.Background(), args...)
 		if err != nil {
 			return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", args, output)
 		}
@@ -967,9 +1817,7 @@ func (p *Provider) ConfigSSH(l *logger.Logger, zones []string) error {
 	// Populate SSH config files with Host entries from each instance in active projects.
 	for _, prj := range p.GetProjects() {
 		args := []string{"compute", "config-ssh", "--project", prj, "--quiet"}
-		cmd := exec.Command("gcloud", args...)
-
-		output, err := cmd.CombinedOutput()
+		output, err := runner.CombinedOutput(context.Background(), args...)
 		if err != nil {
 			return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", args, output)
 		}
@@ -977,6 +1825,10 @@ func (p *Provider) ConfigSSH(l *logger.Logger, zones []string) error {
 	return nil
 }
 
+// maxConcurrentLabelEdits is the maximum number of concurrent gcloud commands
+// issued by editLabels.
+const maxConcurrentLabelEdits = 16
+
 func (p *Provider) editLabels(
 	l *logger.Logger, vms vm.List, labels map[string]string, remove bool,
 ) error {
@@ -998,18 +1850,24 @@ func (p *Provider) editLabels(
 	tagArgsString := strings.Join(tagArgs, ",")
 	commonArgs := []string{"--project", p.GetProject(), fmt.Sprintf("--labels=%s", tagArgsString)}
 
+	// N.B. `gcloud compute instances add-labels` (and remove-labels) only
+	// accept a single instance, so we run the per-VM commands concurrently.
+	var g errgroup.Group
+	g.SetLimit(maxConcurrentLabelEdits)
 	for _, v := range vms {
 		vmArgs := make([]string, len(cmdArgs))
 		copy(vmArgs, cmdArgs)
 
 		vmArgs = append(vmArgs, v.Name, "--zone", v.Zone)
 		vmArgs = append(vmArgs, commonArgs...)
-		cmd := exec.Command("gcloud", vmArgs...)
-		if b, err := cmd.CombinedOutput(); err != nil {
-			return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", vmArgs, string(b))
-		}
+		g.Go(func() error {
+			if b, err := runner.CombinedOutput(context.Background(), vmArgs...); err != nil {
+				return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", vmArgs, string(b))
+			}
+			return nil
+		})
 	}
-	return nil
+	return g.Wait()
 }
 
 // AddLabels adds the given labels to the given VMs.
@@ -1025,11 +1883,140 @@ func (p *Provider) RemoveLabels(l *logger.Logger, vms vm.List, labels []string)
 	return p.editLabels(l, vms, labelsMap, true /* remove */)
 }
 
+// RemoveLabelsByPrefix removes all the labels whose key starts with the given
+// prefix from the given VMs. The labels of each VM are taken from vm.VM.Labels
+// or, if they haven't been populated, described.
+func (p *Provider) RemoveLabelsByPrefix(l *logger.Logger, vms vm.List, prefix string) error {
+	if prefix == "" {
+		return errors.New("refusing to remove all labels: empty prefix")
+	}
+	var g errgroup.Group
+	g.SetLimit(maxConcurrentLabelEdits)
+	for _, v := range vms {
+		v := v
+		g.Go(func() error {
+			labels := v.Labels
+			if labels == nil {
+				var err error
+				if labels, err = p.GetLabels(l, &v); err != nil {
+					return err
+				}
+			}
+			toRemove := make(map[string]string)
+			for key := range labels {
+				if strings.HasPrefix(key, prefix) {
+					toRemove[key] = ""
+				}
+			}
+			if len(toRemove) == 0 {
+				return nil
+			}
+			return p.editLabels(l, vm.List{v}, toRemove, true /* remove */)
+		})
+	}
+	return g.Wait()
+}
+
+// protectedLabels are the labels which SetLabels never removes nor
+// overwrites, since roachprod relies on them to manage the VMs (see
+// vm.IsRoachprodManaged).
+var protectedLabels = map[string]struct{}{
+	vm.TagCluster: {}, vm.TagCreated: {}, vm.TagRoachprod: {},
+}
+
+// SetLabels sets the labels of the given VMs to exactly the desired ones: the
+// current labels of each VM are described, and the ones missing from desired
+// are removed, while the ones which are missing or differ are added. Protected
+// labels (see protectedLabels) are left untouched.
+func (p *Provider) SetLabels(l *logger.Logger, vms vm.List, desired map[string]string) error {
+	var g errgroup.Group
+	g.SetLimit(maxConcurrentLabelEdits)
+	for _, v := range vms {
+		v := v
+		g.Go(func() error {
+			current, err := p.GetLabels(l, &v)
+			if err != nil {
+				return err
+			}
+			toRemove := make(map[string]string)
+			for key := range current {
+				if _, ok := protectedLabels[key]; ok {
+					continue
+				}
+				if _, ok := desired[key]; !ok {
+					toRemove[key] = ""
+				}
+			}
+			toAdd := make(map[string]string)
+			for key, value := range desired {
+				if _, ok := protectedLabels[key]; ok {
+					continue
+				}
+				if currentValue, ok := current[key]; !ok || currentValue != vm.SanitizeLabel(value) {
+					toAdd[key] = value
+				}
+			}
+			if len(toRemove) > 0 {
+				if err := p.editLabels(l, vm.List{v}, toRemove, true /* remove */); err != nil {
+					return err
+				}
+			}
+			if len(toAdd) > 0 {
+				return p.editLabels(l, vm.List{v}, toAdd, false /* remove */)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// GetLabels returns the current labels of the given VM, as read from GCE.
+// Unlike v.Labels, which is populated by List and may have gone stale since,
+// these reflect any concurrent changes, which allows callers of AddLabels and
+// RemoveLabels to read-modify-write the labels.
+func (p *Provider) GetLabels(l *logger.Logger, v *vm.VM) (map[string]string, error) {
+	args := []string{
+		"compute", "instances", "describe", v.Name,
+		"--project", p.GetProject(),
+		"--zone", v.Zone,
+		"--format", "json(labels)",
+	}
+	var described struct {
+		Labels map[string]string `json:"labels"`
+	}
+	if err := runJSONCommand(args, &described); err != nil {
+		return nil, err
+	}
+	return described.Labels, nil
+}
+
 // Create TODO(peter): document
 func (p *Provider) Create(
 	l *logger.Logger, names []string, opts vm.CreateOpts, vmProviderOpts vm.ProviderOpts,
 ) error {
+	_, err := p.CreateInstances(l, names, opts, vmProviderOpts)
+	return err
+}
+
+// CreateInstances is like Create, but also returns the zone of each of the
+// created instances, keyed by instance name, so that callers don't need to
+// List them. The instances are returned even if waiting for their startup
+// scripts or labeling their disks fails afterwards. Nothing is returned in dry
+// runs.
+func (p *Provider) CreateInstances(
+	l *logger.Logger, names []string, opts vm.CreateOpts, vmProviderOpts vm.ProviderOpts,
+) (map[string]string, error) {
 	providerOpts := vmProviderOpts.(*ProviderOpts)
+	if projects := p.GetProjects(); len(projects) > 1 {
+		if !providerOpts.MultiProject {
+			return nil, errors.Newf("multiple projects not supported (%d specified); "+
+				"use --%s-multi-project to spread the instances across them", len(projects), ProviderName)
+		}
+		if providerOpts.UseMIG {
+			return nil, errors.Newf("--%s-use-mig can't be used with multiple projects", ProviderName)
+		}
+		return p.createInProjects(l, names, opts, providerOpts, projects)
+	}
 	project := p.GetProject()
 	var gcJob bool
 	for _, prj := range projectsWithGC {
@@ -1043,46 +2030,40 @@ func (p *Provider) Create(
 			"`roachprod gc --gce-project=%s` cronjob", project)
 	}
 
-	zones, err := vm.ExpandZonesFlag(providerOpts.Zones)
+	zones, weights, err := createZones(opts, providerOpts)
 	if err != nil {
-		return err
-	}
-	if len(zones) == 0 {
-		if opts.GeoDistributed {
-			zones = defaultZones
-		} else {
-			zones = []string{defaultZones[0]}
-		}
+		return nil, err
 	}
 
 	// Fixed args.
 	image := providerOpts.Image
 	imageProject := defaultImageProject
+	if providerOpts.ImageProject != "" {
+		imageProject = providerOpts.ImageProject
+	}
 	useArmAMI := strings.HasPrefix(strings.ToLower(providerOpts.MachineType), "t2a-")
 	if useArmAMI && (opts.Arch != "" && opts.Arch != string(vm.ArchARM64)) {
-		return errors.Errorf("machine type %s is arm64, but requested arch is %s", providerOpts.MachineType, opts.Arch)
+		return nil, errors.Errorf("machine type %s is arm64, but requested arch is %s", providerOpts.MachineType, opts.Arch)
 	}
 	if useArmAMI && opts.SSDOpts.UseLocalSSD {
-		return errors.New("local SSDs are not supported with T2A instances, use --local-ssd=false")
+		return nil, errors.New("local SSDs are not supported with T2A instances, use --local-ssd=false")
+	}
+	if err := validateMinNodeCPUs(providerOpts.MachineType, providerOpts.MinNodeCPUs); err != nil {
+		return nil, err
 	}
 	if useArmAMI {
-		if len(providerOpts.Zones) == 0 {
-			zones = []string{"us-central1-a"}
-		} else {
-			supportedT2ARegions := []string{"us-central1", "asia-southeast1", "europe-west4"}
-			for _, zone := range providerOpts.Zones {
-				for _, region := range supportedT2ARegions {
-					if !strings.HasPrefix(zone, region) {
-						return errors.Newf("T2A instances are not supported outside of [%s]", strings.Join(supportedT2ARegions, ","))
-					}
-				}
-			}
-		}
 		if providerOpts.MinCPUPlatform != "" {
 			l.Printf("WARNING: --gce-min-cpu-platform is ignored for T2A instances")
 			providerOpts.MinCPUPlatform = ""
 		}
 	}
+	if providerOpts.MinCPUPlatform != "" {
+		minCPUPlatform, err := resolveMinCPUPlatform(l, providerOpts.MachineType, providerOpts.MinCPUPlatform)
+		if err != nil {
+			return nil, err
+		}
+		providerOpts.MinCPUPlatform = minCPUPlatform
+	}
 	// TODO(srosenberg): remove this once we have a better way to detect ARM64 machines
 	if useArmAMI {
 		image = ARM64Image
@@ -1090,6 +2071,7 @@ func (p *Provider) Create(
 	}
 	if opts.Arch == string(vm.ArchFIPS) {
 		// NB: if FIPS is enabled, it overrides the image passed via CLI (--gce-image)
+		// as well as the image project (--gce-image-project).
 		image = FIPSImage
 		imageProject = FIPSImageProject
 		l.Printf("Using FIPS-enabled AMI: %s for machine type: %s", image, providerOpts.MachineType)
@@ -1098,34 +2080,61 @@ func (p *Provider) Create(
 	if opts.UbuntuVersion.IsOverridden() {
 		image, err = getUbuntuImage(opts.UbuntuVersion, opts.Arch)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		l.Printf("Overriding default Ubuntu image with %s", image)
 	}
+	if providerOpts.ImageLatest {
+		arch := opts.Arch
+		if useArmAMI {
+			arch = string(vm.ArchARM64)
+		}
+		image, err = getLatestUbuntuImage(imageProject, opts.UbuntuVersion, arch)
+		if err != nil {
+			return nil, err
+		}
+		l.Printf("Using latest Ubuntu image: %s", image)
+	}
 	args := []string{
 		"compute", "instances", "create",
-		"--subnet", "default",
 		"--scopes", "cloud-platform",
 		"--image", image,
 		"--image-project", imageProject,
 		"--boot-disk-type", "pd-ssd",
 	}
 
-	if project == defaultProject && p.ServiceAccount == "" {
-		p.ServiceAccount = "21965078311-compute@developer.gserviceaccount.com"
-
+	serviceAccount := p.ServiceAccount
+	if project == defaultProject && serviceAccount == "" {
+		serviceAccount = "21965078311-compute@developer.gserviceaccount.com"
 	}
-	if p.ServiceAccount != "" {
-		args = append(args, "--service-account", p.ServiceAccount)
+	if serviceAccount != "" {
+		args = append(args, "--service-account", serviceAccount)
 	}
 
+	if providerOpts.MaintenancePolicy != "" {
+		policy, err := validateMaintenancePolicy(
+			providerOpts.MaintenancePolicy, providerOpts.preemptible, providerOpts.UseSpot)
+		if err != nil {
+			return nil, err
+		}
+		providerOpts.MaintenancePolicy = policy
+	}
+	if providerOpts.RestartOnFailure.IsSet && providerOpts.RestartOnFailure.Value {
+		if providerOpts.preemptible {
+			return nil, errors.New("preemptible instances cannot be restarted on failure")
+		}
+		if providerOpts.UseSpot {
+			return nil, errors.New("spot instances cannot be restarted on failure")
+		}
+	}
 	if providerOpts.preemptible {
 		// Make sure the lifetime is no longer than 24h
 		if opts.Lifetime > time.Hour*24 {
-			return errors.New("lifetime cannot be longer than 24 hours for preemptible instances")
+			return nil, errors.New("lifetime cannot be longer than 24 hours for preemptible instances")
 		}
 		if !providerOpts.TerminateOnMigration {
-			return errors.New("preemptible instances require 'TERMINATE' maintenance policy; use --gce-terminateOnMigration")
+			l.Printf("WARNING: preemptible instances require 'TERMINATE' maintenance policy; setting --gce-terminateOnMigration")
+			providerOpts.TerminateOnMigration = true
 		}
 		args = append(args, "--preemptible")
 		// Preemptible instances require the following arguments set explicitly
@@ -1133,19 +2142,40 @@ func (p *Provider) Create(
 		args = append(args, "--no-restart-on-failure")
 	} else if providerOpts.UseSpot {
 		args = append(args, "--provisioning-model", "SPOT")
+		if providerOpts.MaintenancePolicy != "" {
+			args = append(args, "--maintenance-policy", providerOpts.MaintenancePolicy)
+		}
 	} else {
-		if providerOpts.TerminateOnMigration {
+		if providerOpts.MaintenancePolicy != "" {
+			args = append(args, "--maintenance-policy", providerOpts.MaintenancePolicy)
+		} else if providerOpts.TerminateOnMigration {
 			args = append(args, "--maintenance-policy", "TERMINATE")
 		} else {
 			args = append(args, "--maintenance-policy", "MIGRATE")
 		}
 	}
+	if providerOpts.DeletionProtection {
+		args = append(args, "--deletion-protection")
+	}
+	// N.B. preemptible instances are never restarted on failure, and already
+	// pass --no-restart-on-failure above.
+	if providerOpts.RestartOnFailure.IsSet && !providerOpts.preemptible {
+		if providerOpts.RestartOnFailure.Value {
+			args = append(args, "--restart-on-failure")
+		} else {
+			args = append(args, "--no-restart-on-failure")
+		}
+	}
 
 	extraMountOpts := ""
 	// Dynamic args.
 	if opts.SSDOpts.UseLocalSSD {
+		ssdInterface, err := validateLocalSSDInterface(providerOpts.MachineType, providerOpts.LocalSSDInterface)
+		if err != nil {
+			return nil, err
+		}
 		if counts, err := AllowedLocalSSDCount(providerOpts.MachineType); err != nil {
-			return err
+			return nil, err
 		} else {
 			// Make sure the minimum number of local SSDs is met.
 			minCount := counts[0]
@@ -1155,7 +2185,7 @@ func (p *Provider) Create(
 			}
 		}
 		for i := 0; i < providerOpts.SSDCount; i++ {
-			args = append(args, "--local-ssd", "interface=NVME")
+			args = append(args, "--local-ssd", "interface="+ssdInterface)
 		}
 		if opts.SSDOpts.NoExt4Barrier {
 			extraMountOpts = "nobarrier"
@@ -1178,7 +2208,7 @@ func (p *Provider) Create(
 	// Create GCE startup script file.
 	filename, err := writeStartupScript(extraMountOpts, opts.SSDOpts.FileSystem, providerOpts.UseMultipleDisks, opts.Arch == string(vm.ArchFIPS), !shouldEnableRSAForSSH(opts.UbuntuVersion, opts.Arch))
 	if err != nil {
-		return errors.Wrapf(err, "could not write GCE startup script to temp file")
+		return nil, errors.Wrapf(err, "could not write GCE startup script to temp file")
 	}
 	defer func() {
 		_ = os.Remove(filename)
@@ -1190,10 +2220,10 @@ func (p *Provider) Create(
 	}
 
 	m := vm.GetDefaultLabelMap(opts)
-	// Format according to gce label naming convention requirement.
-	time := timeutil.Now().Format(time.RFC3339)
-	time = strings.ToLower(strings.ReplaceAll(time, ":", "_"))
-	m[vm.TagCreated] = time
+	m[vm.TagCreated] = createdLabel(timeutil.Now())
+	if providerOpts.UseMIG {
+		m[migLabel] = opts.ClusterName
+	}
 
 	var labelPairs []string
 	addLabel := func(key, value string) {
@@ -1203,10 +2233,41 @@ func (p *Provider) Create(
 	for key, value := range opts.CustomLabels {
 		_, ok := m[strings.ToLower(key)]
 		if ok {
-			return fmt.Errorf("duplicate label name defined: %s", key)
+			return nil, fmt.Errorf("duplicate label name defined: %s", key)
 		}
 		addLabel(key, value)
 	}
+	for key, value := range providerOpts.Labels {
+		key = serializeLabel(key)
+		_, ok := m[key]
+		if !ok {
+			for customKey := range opts.CustomLabels {
+				ok = ok || strings.ToLower(customKey) == key
+			}
+		}
+		if ok {
+			return nil, fmt.Errorf("duplicate label name defined: %s", key)
+		}
+		addLabel(key, serializeLabel(value))
+	}
+	fileLabels, err := readLabelsFile(providerOpts.LabelsFromFile)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range fileLabels {
+		key = serializeLabel(key)
+		_, ok := m[key]
+		for customKey := range opts.CustomLabels {
+			ok = ok || strings.ToLower(customKey) == key
+		}
+		for flagKey := range providerOpts.Labels {
+			ok = ok || serializeLabel(flagKey) == key
+		}
+		if ok {
+			return nil, fmt.Errorf("duplicate label name defined: %s", key)
+		}
+		addLabel(key, serializeLabel(value))
+	}
 	for key, value := range m {
 		addLabel(key, value)
 	}
@@ -1214,62 +2275,1122 @@ func (p *Provider) Create(
 
 	args = append(args, "--labels", labels)
 	args = append(args, "--metadata-from-file", fmt.Sprintf("startup-script=%s", filename))
+	if providerOpts.StartupScriptTimeout > 0 {
+		// The startup script signals its completion via a guest attribute.
+		args = append(args, "--metadata", "enable-guest-attributes=TRUE")
+	}
 	args = append(args, "--project", project)
 	args = append(args, fmt.Sprintf("--boot-disk-size=%dGB", opts.OsVolumeSize))
+	if providerOpts.UseMIG {
+		zoneToHostNames, err := p.createMIG(l, project, names, opts, providerOpts, zones, weights, args)
+		if err != nil || zoneToHostNames == nil {
+			return nil, err
+		}
+		return awaitCreated(l, project, labels, providerOpts, zoneToHostNames)
+	}
 	var g errgroup.Group
 
-	nodeZones := vm.ZonePlacement(len(zones), len(names))
+	nodeZones := placeNodes(zones, weights, len(names))
 	// N.B. when len(zones) > len(names), we don't need to map unused zones
 	zoneToHostNames := make(map[string][]string, min(len(zones), len(names)))
 	for i, name := range names {
 		zone := zones[nodeZones[i]]
 		zoneToHostNames[zone] = append(zoneToHostNames[zone], name)
 	}
-	l.Printf("Creating %d instances, distributed across [%s]", len(names), strings.Join(zones, ", "))
+	if err := validateHostnames(names, providerOpts.Hostnames); err != nil {
+		return nil, err
+	}
+	if err := validateSubnets(providerOpts.Subnets, maps.Keys(zoneToHostNames)); err != nil {
+		return nil, err
+	}
+	// createArgs returns the commands creating the instances of the given zone.
+	// Since gcloud's --hostname applies to all of the instances created by a
+	// command, each instance with a custom hostname is created separately.
+	createArgs := func(zone string) [][]string {
+		command := func(hostname string, names ...string) []string {
+			argsWithZone := args[:len(args):len(args)]
+			argsWithZone = append(argsWithZone, "--subnet", subnetForZone(providerOpts.Subnets, zone))
+			if hostname != "" {
+				argsWithZone = append(argsWithZone, "--hostname", hostname)
+			}
+			argsWithZone = append(argsWithZone, "--zone", zone)
+			argsWithZone = append(argsWithZone, names...)
+			return append(argsWithZone, providerOpts.ExtraCreateArgs...)
+		}
+		var commands [][]string
+		var defaultNames []string
+		for _, name := range zoneToHostNames[zone] {
+			if hostname, ok := providerOpts.Hostnames[name]; ok {
+				commands = append(commands, command(hostname, name))
+			} else {
+				defaultNames = append(defaultNames, name)
+			}
+		}
+		if len(defaultNames) > 0 {
+			commands = append(commands, command("" /* hostname */, defaultNames...))
+		}
+		return commands
+	}
 
-	for zone, zoneHosts := range zoneToHostNames {
-		argsWithZone := append(args[:len(args):len(args)], "--zone", zone)
-		argsWithZone = append(argsWithZone, zoneHosts...)
-		g.Go(func() error {
-			cmd := exec.Command("gcloud", argsWithZone...)
+	if providerOpts.DryRun {
+		l.Printf("Dry run: would create %d instances, distributed across [%s]", len(names), strings.Join(zones, ", "))
+		dryRunZones := maps.Keys(zoneToHostNames)
+		sort.Strings(dryRunZones)
+		for _, zone := range dryRunZones {
+			for _, argsWithZone := range createArgs(zone) {
+				l.Printf("Dry run: gcloud %s", strings.Join(argsWithZone, " "))
+			}
+		}
+		if !providerOpts.SkipDiskLabels {
+			printDiskLabelCommands(l, project, labels, zoneToHostNames)
+		}
+		return nil, nil
+	}
 
-			output, err := cmd.CombinedOutput()
-			if err != nil {
-				return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", argsWithZone, output)
+	if err := p.validateZones(project, zones); err != nil {
+		return nil, err
+	}
+	if err := checkMachineTypeAvailability(project, providerOpts.MachineType, zones); err != nil {
+		return nil, err
+	}
+	numToCreate := len(names)
+	if providerOpts.SkipExisting {
+		skipped, err := skipExistingInstances(project, providerOpts.MachineType, zoneToHostNames)
+		if err != nil {
+			return nil, err
+		}
+		if len(skipped) > 0 {
+			l.Printf("Skipping %d existing instances: %s", len(skipped), strings.Join(skipped, ", "))
+		}
+		numToCreate -= len(skipped)
+	}
+
+	l.Printf("Creating %d instances, distributed across [%s]", numToCreate, strings.Join(zones, ", "))
+
+	progress := newCreateProgress(l, len(zoneToHostNames), numToCreate)
+	for zone := range zoneToHostNames {
+		zone := zone
+		commands := createArgs(zone)
+		g.Go(func() error {
+			for _, argsWithZone := range commands {
+				output, err := runner.CombinedOutput(context.Background(), argsWithZone...)
+				if err != nil {
+					return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", argsWithZone, output)
+				}
+				logOperations(l, argsWithZone, output)
 			}
+			progress.zoneDone(zone, len(zoneToHostNames[zone]))
 			return nil
 		})
 
 	}
 	err = g.Wait()
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	return propagateDiskLabels(l, project, labels, zoneToHostNames, &opts)
+	progress.finish()
+	return awaitCreated(l, project, labels, providerOpts, zoneToHostNames)
 }
 
-// Given a machine type, return the allowed number (> 0) of local SSDs, sorted in ascending order.
-// N.B. Only n1, n2 and c2 instances are supported since we don't typically use other instance types.
-// Consult https://cloud.google.com/compute/docs/disks/#local_ssd_machine_type_restrictions for other types of instances.
-func AllowedLocalSSDCount(machineType string) ([]int, error) {
-	// E.g., n2-standard-4, n2-custom-8-16384.
-	machineTypes := regexp.MustCompile(`^([cn])(\d+)-[a-z]+-(\d+)(?:-\d+)?$`)
-	matches := machineTypes.FindStringSubmatch(machineType)
-
-	if len(matches) >= 3 {
-		family := matches[1] + matches[2]
-		numCpus, err := strconv.Atoi(matches[3])
-		if err != nil {
-			return nil, err
+// awaitCreated returns the zone of each of the created instances, keyed by
+// instance name, once their startup scripts completed (if configured) and
+// their disks were labeled.
+func awaitCreated(
+	l *logger.Logger,
+	project, labels string,
+	providerOpts *ProviderOpts,
+	zoneToHostNames map[string][]string,
+) (map[string]string, error) {
+	created := make(map[string]string)
+	for zone, zoneHosts := range zoneToHostNames {
+		for _, host := range zoneHosts {
+			created[host] = zone
 		}
-		if family == "n1" {
-			return []int{1, 2, 3, 4, 5, 6, 7, 8, 16, 24}, nil
+	}
+
+	if providerOpts.StartupScriptTimeout > 0 {
+		if err := waitForStartupScripts(l, project, zoneToHostNames, providerOpts.StartupScriptTimeout); err != nil {
+			return created, err
 		}
-		switch family {
-		case "n2":
-			if numCpus <= 10 {
-				return []int{1, 2, 4, 8, 16, 24}, nil
+	}
+
+	if providerOpts.SkipDiskLabels {
+		l.Printf("Skipping the propagation of labels to disks")
+		return created, nil
+	}
+	if err := propagateDiskLabels(l, project, labels, zoneToHostNames); err != nil {
+		return created, err
+	}
+	return created, nil
+}
+
+// createMIG creates the named instances via a regional managed instance group
+// (MIG) spanning the given zones, see ProviderOpts.UseMIG: an instance template
+// is created from the given instance creation args, from which an empty MIG is
+// created, and each of the instances is then added to the MIG under its
+// requested name. Once the MIG is stable, the names of its instances are
+// returned, keyed by the zone the MIG placed them in. Nothing is returned in
+// dry runs.
+func (p *Provider) createMIG(
+	l *logger.Logger,
+	project string,
+	names []string,
+	opts vm.CreateOpts,
+	providerOpts *ProviderOpts,
+	zones []string,
+	weights []int,
+	args []string,
+) (map[string][]string, error) {
+	for _, unsupported := range []struct {
+		flag string
+		set  bool
+	}{
+		{"hostnames", len(providerOpts.Hostnames) > 0},
+		{"subnets", len(providerOpts.Subnets) > 0},
+		{"skip-existing", providerOpts.SkipExisting},
+		{"deletion-protection", providerOpts.DeletionProtection},
+		{"zones-weighted", len(weights) > 0},
+	} {
+		if unsupported.set {
+			return nil, errors.Newf("--%[1]s-%[2]s can't be used with --%[1]s-use-mig",
+				ProviderName, unsupported.flag)
+		}
+	}
+	region := ZoneToRegion(zones[0])
+	if region == "" {
+		return nil, errors.Newf("malformed zone %q; expected e.g. us-east1-b", zones[0])
+	}
+	// A zone may be repeated, see vm.ExpandZonesFlag, but the MIG distributes
+	// the instances evenly across its distinct zones.
+	var migZones []string
+	seen := make(map[string]struct{}, len(zones))
+	for _, zone := range zones {
+		if r := ZoneToRegion(zone); r != region {
+			return nil, errors.Newf("the zones of a regional MIG must be in a single region, "+
+				"got %s and %s", zones[0], zone)
+		}
+		if _, ok := seen[zone]; !ok {
+			seen[zone] = struct{}{}
+			migZones = append(migZones, zone)
+		}
+	}
+
+	group := opts.ClusterName
+	template := migTemplateName(group)
+	// N.B. the instance creation args start with "compute instances create".
+	templateArgs := append([]string{"compute", "instance-templates", "create", template}, args[3:]...)
+	templateArgs = append(templateArgs, providerOpts.ExtraCreateArgs...)
+	migArgs := func(command string, extraArgs ...string) []string {
+		return managedGroupArgs(project, region, group, command, extraArgs...)
+	}
+	commands := [][]string{
+		templateArgs,
+		migArgs("create",
+			"--template", template,
+			"--zones", strings.Join(migZones, ","),
+			"--size", "0",
+		),
+	}
+	// Unlike resizing the MIG, which names the instances after a base name and
+	// a random suffix, creating the instances one by one preserves the names
+	// roachprod expects.
+	for _, name := range names {
+		commands = append(commands, migArgs("create-instance", "--instance", name))
+	}
+	commands = append(commands, migArgs("wait-until", "--stable"))
+
+	if providerOpts.DryRun {
+		l.Printf("Dry run: would create a MIG of %d instances, distributed across [%s]",
+			len(names), strings.Join(migZones, ", "))
+		for _, command := range commands {
+			l.Printf("Dry run: gcloud %s", strings.Join(command, " "))
+		}
+		return nil, nil
+	}
+
+	if err := p.validateZones(project, migZones); err != nil {
+		return nil, err
+	}
+	if err := checkMachineTypeAvailability(project, providerOpts.MachineType, migZones); err != nil {
+		return nil, err
+	}
+	l.Printf("Creating a MIG of %d instances, distributed across [%s]",
+		len(names), strings.Join(migZones, ", "))
+	for _, command := range commands {
+		output, err := runner.CombinedOutput(context.Background(), command...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", command, output)
+		}
+		logOperations(l, command, output)
+	}
+
+	zoneToHostNames, err := listMIGInstances(project, region, group)
+	if err != nil {
+		return nil, err
+	}
+	requested := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		requested[name] = struct{}{}
+	}
+	var numInstances int
+	for _, zoneHosts := range zoneToHostNames {
+		for _, name := range zoneHosts {
+			if _, ok := requested[name]; !ok {
+				return nil, errors.Errorf("unexpected instance %s in MIG %s", name, group)
+			}
+		}
+		numInstances += len(zoneHosts)
+	}
+	if numInstances != len(names) {
+		return nil, errors.Errorf("expected %d instances in MIG %s, found %d",
+			len(names), group, numInstances)
+	}
+	for zone := range zoneToHostNames {
+		l.Printf("MIG %s created instances %s in %s",
+			group, strings.Join(zoneToHostNames[zone], ", "), zone)
+	}
+	return zoneToHostNames, nil
+}
+
+// migLabel is the label carried by the instances of a cluster backed by a MIG
+// (see ProviderOpts.UseMIG), whose value is the name of the MIG. It allows
+// Delete to delete the instances via the MIG, which would otherwise recreate
+// them, along with the MIG and its instance template.
+const migLabel = "roachprod-mig"
+
+// migTemplateName returns the name of the instance template of the given MIG.
+func migTemplateName(group string) string {
+	return group + "-template"
+}
+
+// managedGroupArgs returns the gcloud arguments to run the given command on
+// the given regional MIG.
+func managedGroupArgs(project, region, group, command string, extraArgs ...string) []string {
+	return append([]string{"compute", "instance-groups", "managed", command, group,
+		"--project", project,
+		"--region", region,
+	}, extraArgs...)
+}
+
+// listMIGInstances returns the sorted names of the instances of the given
+// regional MIG, keyed by zone.
+func listMIGInstances(project, region, group string) (map[string][]string, error) {
+	var jsonInstances []struct {
+		Instance string `json:"instance"`
+	}
+	args := managedGroupArgs(project, region, group, "list-instances", "--format", "json(instance)")
+	if err := runJSONCommand(args, &jsonInstances); err != nil {
+		return nil, err
+	}
+	zoneToHostNames := make(map[string][]string)
+	for _, instance := range jsonInstances {
+		// The instance is a URL like .../projects/P/zones/Z/instances/NAME.
+		parts := strings.Split(instance.Instance, "/")
+		if len(parts) < 4 || parts[len(parts)-2] != "instances" || parts[len(parts)-4] != "zones" {
+			return nil, errors.Errorf("unexpected MIG instance %q", instance.Instance)
+		}
+		zone := parts[len(parts)-3]
+		zoneToHostNames[zone] = append(zoneToHostNames[zone], parts[len(parts)-1])
+	}
+	for zone := range zoneToHostNames {
+		sort.Strings(zoneToHostNames[zone])
+	}
+	return zoneToHostNames, nil
+}
+
+// deleteFromMIG deletes the given instances of the given regional MIG. The
+// instances are deleted via the MIG, since it would recreate them otherwise.
+// If they are all of its instances, the MIG itself is deleted instead, along
+// with its instance template.
+func deleteFromMIG(
+	ctx context.Context, l *logger.Logger, project, region, group string, names []string,
+) error {
+	zoneToHostNames, err := listMIGInstances(project, region, group)
+	if err != nil {
+		return err
+	}
+	deleted := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		deleted[name] = struct{}{}
+	}
+	all := true
+	for _, zoneHosts := range zoneToHostNames {
+		for _, name := range zoneHosts {
+			if _, ok := deleted[name]; !ok {
+				all = false
+			}
+		}
+	}
+
+	var commands [][]string
+	if all {
+		commands = [][]string{
+			managedGroupArgs(project, region, group, "delete", "--quiet"),
+			{"compute", "instance-templates", "delete", migTemplateName(group),
+				"--project", project,
+				"--quiet",
+			},
+		}
+	} else {
+		sorted := append([]string(nil), names...)
+		sort.Strings(sorted)
+		commands = [][]string{managedGroupArgs(project, region, group, "delete-instances",
+			"--instances", strings.Join(sorted, ","))}
+	}
+	for _, args := range commands {
+		output, err := runner.CombinedOutput(ctx, args...)
+		if err != nil {
+			return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", args, output)
+		}
+		logOperations(l, args, output)
+	}
+	return nil
+}
+
+// skipExistingInstances removes the instances which already exist from the
+// given mapping of zones to instance names, and returns their sorted names.
+// Zones left without instances are removed altogether. An existing instance
+// which isn't in the expected zone, or isn't of the given machine type, is an
+// error, since it doesn't match the requested configuration.
+func skipExistingInstances(
+	project, machineType string, zoneToHostNames map[string][]string,
+) ([]string, error) {
+	var names []string
+	for _, zoneHosts := range zoneToHostNames {
+		names = append(names, zoneHosts...)
+	}
+	sort.Strings(names)
+	args := []string{"compute", "instances", "list",
+		"--project", project,
+		"--filter", fmt.Sprintf("name=(%s)", strings.Join(names, " ")),
+		"--format", "json(name,zone,machineType)",
+	}
+	var jsonInstances []struct {
+		Name        string `json:"name"`
+		Zone        string `json:"zone"`
+		MachineType string `json:"machineType"`
+	}
+	if err := runJSONCommand(args, &jsonInstances); err != nil {
+		return nil, err
+	}
+	existingZones := make(map[string]string, len(jsonInstances))
+	for _, instance := range jsonInstances {
+		if lastComponent(instance.MachineType) != machineType {
+			return nil, errors.Errorf("existing instance %s is of machine type %s rather than %s",
+				instance.Name, lastComponent(instance.MachineType), machineType)
+		}
+		existingZones[instance.Name] = lastComponent(instance.Zone)
+	}
+
+	var skipped []string
+	for zone, zoneHosts := range zoneToHostNames {
+		var remaining []string
+		for _, host := range zoneHosts {
+			existingZone, ok := existingZones[host]
+			if !ok {
+				remaining = append(remaining, host)
+				continue
+			}
+			if existingZone != zone {
+				return nil, errors.Errorf("existing instance %s is in zone %s rather than %s",
+					host, existingZone, zone)
+			}
+			skipped = append(skipped, host)
+		}
+		if len(remaining) == 0 {
+			delete(zoneToHostNames, zone)
+		} else {
+			zoneToHostNames[zone] = remaining
+		}
+	}
+	sort.Strings(skipped)
+	return skipped, nil
+}
+
+// createZones returns the zones across which Create places the instances:
+// the ones given via --gce-zones or, by default, the first default zone, or
+// all of them for geo-distributed clusters. If the zones are given via
+// --gce-zones-weighted, their weights are returned as well.
+func createZones(
+	opts vm.CreateOpts, providerOpts *ProviderOpts,
+) (zones []string, weights []int, _ error) {
+	if len(providerOpts.WeightedZones) > 0 {
+		if len(providerOpts.Zones) > 0 {
+			return nil, nil, errors.Newf("--%[1]s-zones and --%[1]s-zones-weighted cannot be combined",
+				ProviderName)
+		}
+		return vm.ParseWeightedZonesFlag(providerOpts.WeightedZones)
+	}
+	zones, err := vm.ExpandZonesFlag(providerOpts.Zones)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(zones) > 0 {
+		return zones, nil, nil
+	}
+	if strings.HasPrefix(strings.ToLower(providerOpts.MachineType), "t2a-") {
+		// T2A instances are only offered in a few zones.
+		return []string{"us-central1-a"}, nil, nil
+	}
+	if opts.GeoDistributed {
+		return defaultZones, nil, nil
+	}
+	return []string{defaultZones[0]}, nil, nil
+}
+
+// placeNodes returns the index of the zone of each of the numNodes instances,
+// placing them in proportion to the weights of the zones if any, and evenly
+// across the zones otherwise.
+func placeNodes(zones []string, weights []int, numNodes int) []int {
+	if len(weights) > 0 {
+		return vm.WeightedZonePlacement(weights, numNodes)
+	}
+	return vm.ZonePlacement(len(zones), numNodes)
+}
+
+// createInProjects creates the given instances across the given projects, in
+// a round-robin fashion: the i-th instance is created in the project at index
+// i modulo the number of projects. The instances are placed across zones as
+// if they were created in a single project, and each project then creates
+// its own instances, see CreateInstances. The zones of the instances which
+// were created are returned, even if some projects failed.
+func (p *Provider) createInProjects(
+	l *logger.Logger,
+	names []string,
+	opts vm.CreateOpts,
+	providerOpts *ProviderOpts,
+	projects []string,
+) (map[string]string, error) {
+	zones, weights, err := createZones(opts, providerOpts)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateHostnames(names, providerOpts.Hostnames); err != nil {
+		return nil, err
+	}
+	nodeZones := placeNodes(zones, weights, len(names))
+	projectNames := make(map[string][]string, len(projects))
+	projectZones := make(map[string][]string, len(projects))
+	for i, name := range names {
+		project := projects[i%len(projects)]
+		projectNames[project] = append(projectNames[project], name)
+		projectZones[project] = append(projectZones[project], zones[nodeZones[i]])
+	}
+
+	var mu syncutil.Mutex
+	created := make(map[string]string, len(names))
+	var g errgroup.Group
+	for project, names := range projectNames {
+		project, names := project, names
+		// N.B. each instance is given its own (possibly repeated) zone, which
+		// preserves the placement computed above.
+		projectOpts := *providerOpts
+		projectOpts.Zones = projectZones[project]
+		projectOpts.WeightedZones = nil
+		if providerOpts.Hostnames != nil {
+			projectOpts.Hostnames = make(map[string]string)
+			for _, name := range names {
+				if hostname, ok := providerOpts.Hostnames[name]; ok {
+					projectOpts.Hostnames[name] = hostname
+				}
+			}
+		}
+		projectProvider := &Provider{
+			DNSProvider:         p.DNSProvider,
+			Projects:            []string{project},
+			ServiceAccount:      p.ServiceAccount,
+			AllowedEmailDomains: p.AllowedEmailDomains,
+		}
+		g.Go(func() error {
+			projectCreated, err := projectProvider.CreateInstances(l, names, opts, &projectOpts)
+			mu.Lock()
+			defer mu.Unlock()
+			for name, zone := range projectCreated {
+				created[name] = zone
+			}
+			return errors.Wrapf(err, "creating instances in project %s", project)
+		})
+	}
+	err = g.Wait()
+	if providerOpts.DryRun {
+		return nil, err
+	}
+	return created, err
+}
+
+// subnetForZone returns the subnet in which to place the instances of the
+// given zone, as per the given mapping of zones or regions to subnets (see
+// ProviderOpts.Subnets). A zone takes precedence over its region.
+func subnetForZone(subnets map[string]string, zone string) string {
+	if subnet, ok := subnets[zone]; ok {
+		return subnet
+	}
+	if subnet, ok := subnets[ZoneToRegion(zone)]; ok {
+		return subnet
+	}
+	return "default"
+}
+
+// validateSubnets checks that, if set, the given mapping of zones or regions
+// to subnets covers all of the given zones.
+func validateSubnets(subnets map[string]string, zones []string) error {
+	if len(subnets) == 0 {
+		return nil
+	}
+	var missing []string
+	for _, zone := range zones {
+		if _, ok := subnets[zone]; ok {
+			continue
+		}
+		if _, ok := subnets[ZoneToRegion(zone)]; ok {
+			continue
+		}
+		missing = append(missing, zone)
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return errors.Errorf("no subnet specified for zones %s (--%s-subnets)",
+			strings.Join(missing, ", "), ProviderName)
+	}
+	return nil
+}
+
+// readLabelsFile reads the labels from the given file, if any, which contains
+// one key=value pair per line (see ProviderOpts.LabelsFromFile).
+func readLabelsFile(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading labels file")
+	}
+	labels := make(map[string]string)
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" {
+			return nil, errors.Errorf("%s:%d: expected key=value, got %q", path, i+1, line)
+		}
+		if _, ok := labels[key]; ok {
+			return nil, errors.Errorf("%s:%d: duplicate label name defined: %s", path, i+1, key)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// hostnameLabelRE matches a single label of a hostname, as per RFC 1035.
+var hostnameLabelRE = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// validateHostnames checks that each custom hostname is a legal fully
+// qualified domain name, as required by GCE, for one of the instances being
+// created.
+func validateHostnames(names []string, hostnames map[string]string) error {
+	known := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		known[name] = struct{}{}
+	}
+	for name, hostname := range hostnames {
+		if _, ok := known[name]; !ok {
+			return errors.Errorf("custom hostname %q specified for unknown instance %s", hostname, name)
+		}
+		labels := strings.Split(hostname, ".")
+		if len(hostname) > 253 || len(labels) < 2 {
+			return errors.Errorf("invalid hostname %q for instance %s: must be a fully qualified domain name", hostname, name)
+		}
+		for _, label := range labels {
+			if !hostnameLabelRE.MatchString(label) {
+				return errors.Errorf("invalid hostname %q for instance %s: "+
+					"labels must be 1-63 lowercase letters, digits or hyphens", hostname, name)
+			}
+		}
+	}
+	return nil
+}
+
+// maxCreateProgressLogs bounds the number of progress logs emitted by Create,
+// so that creating clusters across many zones doesn't spam the log.
+const maxCreateProgressLogs = 10
+
+// createProgress logs the progress of Create as the instances of each zone
+// are created.
+type createProgress struct {
+	l          *logger.Logger
+	start      time.Time
+	totalZones int
+	totalVMs   int
+	// logEvery is the number of zones between two progress logs.
+	logEvery int
+
+	mu struct {
+		syncutil.Mutex
+		doneZones int
+		doneVMs   int
+	}
+}
+
+func newCreateProgress(l *logger.Logger, totalZones, totalVMs int) *createProgress {
+	return &createProgress{
+		l:          l,
+		start:      timeutil.Now(),
+		totalZones: totalZones,
+		totalVMs:   totalVMs,
+		logEvery:   (totalZones + maxCreateProgressLogs - 1) / maxCreateProgressLogs,
+	}
+}
+
+// zoneDone records that the given number of instances were created in zone.
+func (p *createProgress) zoneDone(zone string, numVMs int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.doneZones++
+	p.mu.doneVMs += numVMs
+	if p.mu.doneZones%p.logEvery == 0 || p.mu.doneZones == p.totalZones {
+		p.l.Printf("zone %s: %d/%d instances created (%d/%d instances, %d/%d zones overall)",
+			zone, numVMs, numVMs, p.mu.doneVMs, p.totalVMs, p.mu.doneZones, p.totalZones)
+	}
+}
+
+// finish logs a summary once all the instances were created.
+func (p *createProgress) finish() {
+	p.l.Printf("Created %d instances across %d zones in %s",
+		p.totalVMs, p.totalZones, timeutil.Since(p.start).Round(time.Second))
+}
+
+// sshDialTimeout is the timeout of a single SSH connectivity check.
+const sshDialTimeout = 5 * time.Second
+
+// checkSSH checks whether sshd on the given VM accepts connections. It's a
+// variable so that tests can substitute a fake.
+var checkSSH = func(ctx context.Context, v vm.VM) error {
+	ip := v.PublicIP
+	if ip == "" {
+		ip = v.PrivateIP
+	}
+	ctx, cancel := context.WithTimeout(ctx, sshDialTimeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip, "22"))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// waitForSSHRetryOpts are the options used to poll the SSH connectivity of
+// VMs in WaitForSSH.
+var waitForSSHRetryOpts = retry.Options{
+	InitialBackoff: time.Second,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     2,
+}
+
+// WaitForSSH waits until sshd on all the given VMs accepts connections, or
+// the timeout elapses. Instances are RUNNING once Create returns, but sshd may
+// not be up yet. On timeout, the error names the VMs which remain unreachable.
+func (p *Provider) WaitForSSH(l *logger.Logger, vms vm.List, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	pending := vms
+	for r := retry.StartWithCtx(ctx, waitForSSHRetryOpts); r.Next(); {
+		errs := make([]error, len(pending))
+		var g errgroup.Group
+		for i := range pending {
+			i := i
+			g.Go(func() error {
+				errs[i] = checkSSH(ctx, pending[i])
+				return nil
+			})
+		}
+		_ = g.Wait()
+
+		var unreachable vm.List
+		for i, err := range errs {
+			if err != nil {
+				unreachable = append(unreachable, pending[i])
+			}
+		}
+		pending = unreachable
+		if len(pending) == 0 {
+			l.Printf("All %d VMs are reachable via SSH", len(vms))
+			return nil
+		}
+		l.Printf("Waiting for SSH on %d/%d VMs: %s", len(pending), len(vms), strings.Join(pending.Names(), ", "))
+	}
+	return errors.Newf("timed out after %s waiting for SSH; unreachable VMs: %s",
+		timeout, strings.Join(pending.Names(), ", "))
+}
+
+// startupScriptGuestAttribute is the guest attribute, as namespace/key, which
+// the startup script sets once it completes, to either startupScriptSucceeded
+// or startupScriptFailed.
+const startupScriptGuestAttribute = "roachprod/startup-script"
+
+// The values of startupScriptGuestAttribute. The startup script only reports
+// success once it verified its result, e.g. that the data disks are mounted.
+const (
+	startupScriptSucceeded = "done"
+	startupScriptFailed    = "failed"
+)
+
+// startupScriptRetryOpts are the retry options used to poll the instances for
+// the completion of their startup script.
+var startupScriptRetryOpts = retry.Options{
+	InitialBackoff: 5 * time.Second,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+}
+
+// waitForStartupScripts waits until the startup script of all the given
+// instances has completed, or the timeout elapses. On timeout, the error names
+// the instances whose startup script hasn't completed. If the startup script
+// failed on any of the instances, an error naming them is returned right away.
+func waitForStartupScripts(
+	l *logger.Logger, project string, zoneToHostNames map[string][]string, timeout time.Duration,
+) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	type instance struct {
+		zone, name string
+	}
+	var pending []instance
+	for zone, hosts := range zoneToHostNames {
+		for _, host := range hosts {
+			pending = append(pending, instance{zone: zone, name: host})
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].name < pending[j].name })
+	total := len(pending)
+	pendingNames := func() string {
+		var names []string
+		for _, inst := range pending {
+			names = append(names, inst.name)
+		}
+		return strings.Join(names, ", ")
+	}
+
+	for r := retry.StartWithCtx(ctx, startupScriptRetryOpts); r.Next(); {
+		statuses := make([]string, len(pending))
+		var g errgroup.Group
+		for i := range pending {
+			i := i
+			g.Go(func() error {
+				statuses[i] = startupScriptStatus(ctx, project, pending[i].zone, pending[i].name)
+				return nil
+			})
+		}
+		_ = g.Wait()
+
+		var stillPending []instance
+		var failed []string
+		for i, status := range statuses {
+			switch status {
+			case startupScriptSucceeded:
+			case startupScriptFailed:
+				failed = append(failed, pending[i].name)
+			default:
+				stillPending = append(stillPending, pending[i])
+			}
+		}
+		if len(failed) > 0 {
+			return errors.Newf("the startup script failed on: %s; see its output via "+
+				"`gcloud compute instances get-serial-port-output`", strings.Join(failed, ", "))
+		}
+		pending = stillPending
+		if len(pending) == 0 {
+			l.Printf("The startup script completed on all %d instances", total)
+			return nil
+		}
+		l.Printf("Waiting for the startup script on %d/%d instances: %s",
+			len(pending), total, pendingNames())
+	}
+	return errors.Newf("timed out after %s waiting for the startup script to complete on: %s",
+		timeout, pendingNames())
+}
+
+// startupScriptStatus returns the value of the guest attribute which the
+// startup script of the given instance sets once it completes, or the empty
+// string if it isn't set. Errors, e.g. because the guest attribute doesn't
+// exist yet, are treated as not set.
+func startupScriptStatus(ctx context.Context, project, zone, instance string) string {
+	args := []string{
+		"compute", "instances", "get-guest-attributes", instance,
+		"--project", project,
+		"--zone", zone,
+		"--query-path", startupScriptGuestAttribute,
+		"--format", "json",
+	}
+	output, err := runner.Output(ctx, args...)
+	if err != nil {
+		return ""
+	}
+	var attributes []struct {
+		Namespace string `json:"namespace"`
+		Key       string `json:"key"`
+		Value     string `json:"value"`
+	}
+	if err := json.Unmarshal(output, &attributes); err != nil {
+		return ""
+	}
+	for _, a := range attributes {
+		if a.Namespace+"/"+a.Key == startupScriptGuestAttribute {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// validateZones checks that all the given zones exist in the project, and
+// returns an error naming the unknown ones otherwise.
+func (p *Provider) validateZones(project string, zones []string) error {
+	for _, zone := range zones {
+		if ZoneToRegion(zone) == "" {
+			return errors.Newf("malformed zone %q; expected e.g. us-east1-b", zone)
+		}
+	}
+	knownZones, err := p.listZones(project)
+	if err != nil {
+		return err
+	}
+	var unknownZones []string
+	for _, zone := range zones {
+		if _, ok := knownZones[zone]; !ok {
+			unknownZones = append(unknownZones, zone)
+		}
+	}
+	if len(unknownZones) > 0 {
+		return errors.Newf("unknown zones in project %s: %s", project, strings.Join(unknownZones, ", "))
+	}
+	return nil
+}
+
+// listZones returns the set of zones available in the given project. The
+// result is cached.
+func (p *Provider) listZones(project string) (map[string]struct{}, error) {
+	p.zonesCache.mu.Lock()
+	defer p.zonesCache.mu.Unlock()
+	if zones, ok := p.zonesCache.zones[project]; ok {
+		return zones, nil
+	}
+
+	args := []string{"compute", "zones", "list", "--project", project, "--format", "json(name)"}
+	var jsonZones []struct {
+		Name string `json:"name"`
+	}
+	if err := runJSONCommand(args, &jsonZones); err != nil {
+		return nil, err
+	}
+	zones := make(map[string]struct{}, len(jsonZones))
+	for _, z := range jsonZones {
+		zones[z.Name] = struct{}{}
+	}
+	if p.zonesCache.zones == nil {
+		p.zonesCache.zones = make(map[string]map[string]struct{})
+	}
+	p.zonesCache.zones[project] = zones
+	return zones, nil
+}
+
+// amdCPUPlatforms maps the AMD machine families to the minimum CPU platforms
+// they support, the first of which is used by default. See
+// https://cloud.google.com/compute/docs/cpu-platforms.
+var amdCPUPlatforms = map[string][]string{
+	"n2d": {"AMD Milan", "AMD Rome"},
+	"c2d": {"AMD Milan"},
+	"t2d": {"AMD Milan"},
+	"c3d": {"AMD Genoa"},
+}
+
+// resolveMinCPUPlatform returns the minimum CPU platform to request for the
+// given machine type. For AMD machine families, a non-AMD platform (e.g. the
+// default, Intel Ice Lake) is replaced by the family's default AMD platform,
+// while an AMD platform is validated against the ones the family supports.
+// Requesting an AMD platform for a non-AMD machine family is an error.
+func resolveMinCPUPlatform(
+	l *logger.Logger, machineType, minCPUPlatform string,
+) (string, error) {
+	family := strings.SplitN(strings.ToLower(machineType), "-", 2)[0]
+	platforms, isAMD := amdCPUPlatforms[family]
+	requestedAMD := strings.HasPrefix(minCPUPlatform, "AMD ")
+	switch {
+	case isAMD && !requestedAMD:
+		l.Printf("WARNING: min CPU platform %q is not supported by machine type %s; using %q",
+			minCPUPlatform, machineType, platforms[0])
+		return platforms[0], nil
+	case isAMD:
+		for _, platform := range platforms {
+			if platform == minCPUPlatform {
+				return minCPUPlatform, nil
+			}
+		}
+		return "", errors.Newf("min CPU platform %q is not supported by machine type %s; expected one of [%s]",
+			minCPUPlatform, machineType, strings.Join(platforms, ", "))
+	case requestedAMD:
+		return "", errors.Newf("min CPU platform %q is only supported by AMD machine types, got %s",
+			minCPUPlatform, machineType)
+	}
+	return minCPUPlatform, nil
+}
+
+// checkMachineTypeAvailability checks that the given machine type is offered
+// in each of the given zones, and returns an error naming the zones in which
+// it isn't otherwise.
+func checkMachineTypeAvailability(project, machineType string, zones []string) error {
+	args := []string{"compute", "machine-types", "list",
+		"--project", project,
+		"--zones", strings.Join(zones, ","),
+		"--filter", fmt.Sprintf("name=%s", machineType),
+		"--format", "json(name,zone)",
+	}
+	var jsonMachineTypes []struct {
+		Name string `json:"name"`
+		Zone string `json:"zone"`
+	}
+	if err := runJSONCommand(args, &jsonMachineTypes); err != nil {
+		return err
+	}
+	offered := make(map[string]struct{}, len(jsonMachineTypes))
+	for _, mt := range jsonMachineTypes {
+		if mt.Name == machineType {
+			offered[lastComponent(mt.Zone)] = struct{}{}
+		}
+	}
+	var unsupportedZones []string
+	for _, zone := range zones {
+		if _, ok := offered[zone]; !ok {
+			unsupportedZones = append(unsupportedZones, zone)
+		}
+	}
+	if len(unsupportedZones) > 0 {
+		return errors.Newf("machine type %s is not available in zones: %s",
+			machineType, strings.Join(unsupportedZones, ", "))
+	}
+	return nil
+}
+
+// ZonesForMachineType returns the sorted zones of the provider's project in
+// which the given machine type is offered.
+func (p *Provider) ZonesForMachineType(l *logger.Logger, machineType string) ([]string, error) {
+	args := []string{"compute", "machine-types", "list",
+		"--project", p.GetProject(),
+		"--filter", fmt.Sprintf("name=%s", machineType),
+		"--format", "json(name,zone)",
+	}
+	var jsonMachineTypes []struct {
+		Name string `json:"name"`
+		Zone string `json:"zone"`
+	}
+	if err := runJSONCommand(args, &jsonMachineTypes); err != nil {
+		return nil, err
+	}
+	var zones []string
+	seen := make(map[string]struct{}, len(jsonMachineTypes))
+	for _, mt := range jsonMachineTypes {
+		zone := lastComponent(mt.Zone)
+		if _, ok := seen[zone]; mt.Name != machineType || ok {
+			continue
+		}
+		seen[zone] = struct{}{}
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	return zones, nil
+}
+
+// ZonesForLocalSSDs returns the sorted zones in which the given machine type
+// is offered, provided that it supports the given number of local SSDs; see
+// AllowedLocalSSDCount.
+func (p *Provider) ZonesForLocalSSDs(
+	l *logger.Logger, machineType string, localSSDCount int,
+) ([]string, error) {
+	allowed, err := AllowedLocalSSDCount(machineType)
+	if err != nil {
+		return nil, err
+	}
+	supported := false
+	for _, n := range allowed {
+		if n == localSSDCount {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return nil, errors.Newf("machine type %s does not support %d local SSDs; allowed counts: %v",
+			machineType, localSSDCount, allowed)
+	}
+	return p.ZonesForMachineType(l, machineType)
+}
+
+// GetProjectQuota returns the quotas of the given region of the project
+// which are relevant when creating clusters: CPUs (overall, and per family),
+// SSD and disk sizes, and instances. The quotas are keyed by metric name,
+// e.g. CPUS or SSD_TOTAL_GB.
+func (p *Provider) GetProjectQuota(
+	l *logger.Logger, project, region string,
+) (map[string]vm.Quota, error) {
+	args := []string{"compute", "regions", "describe", region, "--project", project, "--format", "json"}
+	var jsonRegion struct {
+		Quotas []struct {
+			Metric string  `json:"metric"`
+			Limit  float64 `json:"limit"`
+			Usage  float64 `json:"usage"`
+		} `json:"quotas"`
+	}
+	if err := runJSONCommand(args, &jsonRegion); err != nil {
+		return nil, err
+	}
+	quotas := make(map[string]vm.Quota)
+	for _, q := range jsonRegion.Quotas {
+		if !isCreateQuotaMetric(q.Metric) {
+			continue
+		}
+		quotas[q.Metric] = vm.Quota{Limit: q.Limit, Usage: q.Usage}
+	}
+	return quotas, nil
+}
+
+// isCreateQuotaMetric returns whether the given regional quota metric is
+// relevant when creating clusters.
+func isCreateQuotaMetric(metric string) bool {
+	switch metric {
+	case "CPUS", "SSD_TOTAL_GB", "LOCAL_SSD_TOTAL_GB", "DISKS_TOTAL_GB", "INSTANCES":
+		return true
+	}
+	// Family-specific CPU quotas, e.g. N2_CPUS or PREEMPTIBLE_CPUS.
+	return strings.HasSuffix(metric, "_CPUS")
+}
+
+// machineTypeRE matches the N and C series machine types, capturing the series
+// letter, the generation and the number of vCPUs, e.g. n2-standard-4 or
+// n2-custom-8-16384.
+var machineTypeRE = regexp.MustCompile(`^([cn])(\d+)-[a-z]+-(\d+)(?:-\d+)?$`)
+
+// Given a machine type, return the allowed number (> 0) of local SSDs, sorted in ascending order.
+// N.B. Only n1, n2 and c2 instances are supported since we don't typically use other instance types.
+// Consult https://cloud.google.com/compute/docs/disks/#local_ssd_machine_type_restrictions for other types of instances.
+func AllowedLocalSSDCount(machineType string) ([]int, error) {
+	matches := machineTypeRE.FindStringSubmatch(machineType)
+
+	if len(matches) >= 3 {
+		family := matches[1] + matches[2]
+		numCpus, err := strconv.Atoi(matches[3])
+		if err != nil {
+			return nil, err
+		}
+		if family == "n1" {
+			return []int{1, 2, 3, 4, 5, 6, 7, 8, 16, 24}, nil
+		}
+		switch family {
+		case "n2":
+			if numCpus <= 10 {
+				return []int{1, 2, 4, 8, 16, 24}, nil
 			}
 			if numCpus <= 20 {
 				return []int{2, 4, 8, 16, 24}, nil
@@ -1301,70 +3422,289 @@ func AllowedLocalSSDCount(machineType string) ([]int, error) {
 	return nil, fmt.Errorf("unsupported machine type: %q, matches: %v", machineType, matches)
 }
 
+// scsiLocalSSDMachineFamilies are the machine families which support SCSI
+// local SSDs; all of the machine families support NVME local SSDs. See:
+// https://cloud.google.com/compute/docs/disks/local-ssd#choose_an_interface
+var scsiLocalSSDMachineFamilies = map[string]struct{}{
+	"n1": {}, "n2": {}, "n2d": {}, "c2": {}, "c2d": {}, "a2": {}, "m1": {}, "m3": {},
+}
+
+// validateLocalSSDInterface checks that the given local SSD interface is
+// supported by the machine type, and returns it in the canonical, upper-case
+// form expected by gcloud.
+func validateLocalSSDInterface(machineType, ssdInterface string) (string, error) {
+	ssdInterface = strings.ToUpper(ssdInterface)
+	switch ssdInterface {
+	case "NVME":
+		return ssdInterface, nil
+	case "SCSI":
+		family, _, _ := strings.Cut(machineType, "-")
+		if _, ok := scsiLocalSSDMachineFamilies[family]; !ok {
+			return "", errors.Errorf("machine type %s doesn't support SCSI local SSDs, use NVME", machineType)
+		}
+		return ssdInterface, nil
+	default:
+		return "", errors.Errorf("unknown local SSD interface %q, expected NVME or SCSI", ssdInterface)
+	}
+}
+
+// customMachineTypeRE matches custom machine types of any series, capturing
+// the number of vCPUs, e.g. custom-8-16384 (N1), e2-custom-4-8192 or
+// n2d-custom-8-32768-ext.
+var customMachineTypeRE = regexp.MustCompile(`^(?:[a-z0-9]+-)?custom-(\d+)-\d+(?:-ext)?$`)
+
+// validateMinNodeCPUs checks that the given custom machine type has at least
+// minCPUs vCPUs. Predefined machine types are not checked.
+func validateMinNodeCPUs(machineType string, minCPUs int) error {
+	if minCPUs <= 0 ||
+		!(strings.HasPrefix(machineType, "custom-") || strings.Contains(machineType, "-custom-")) {
+		return nil
+	}
+	matches := customMachineTypeRE.FindStringSubmatch(machineType)
+	if len(matches) < 2 {
+		return errors.Errorf("cannot determine the number of vCPUs of custom machine type %s", machineType)
+	}
+	numCPUs, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return err
+	}
+	if numCPUs < minCPUs {
+		return errors.Errorf("custom machine type %s has %d vCPUs, below the minimum of %d (--%s-min-node-cpus)",
+			machineType, numCPUs, minCPUs, ProviderName)
+	}
+	return nil
+}
+
+// validateMaintenancePolicy checks that the given maintenance policy is known
+// to GCE and supported by the instances' provisioning model, and returns it in
+// the canonical, upper-case form expected by gcloud. Preemptible and spot
+// instances cannot be live migrated, so they require TERMINATE.
+func validateMaintenancePolicy(policy string, preemptible, spot bool) (string, error) {
+	policy = strings.ToUpper(policy)
+	switch policy {
+	case "MIGRATE", "TERMINATE":
+	default:
+		return "", errors.Errorf("unknown maintenance policy %q, expected MIGRATE or TERMINATE", policy)
+	}
+	if policy != "TERMINATE" {
+		if preemptible {
+			return "", errors.Errorf("preemptible instances require 'TERMINATE' maintenance policy, got %s", policy)
+		}
+		if spot {
+			return "", errors.Errorf("spot instances require 'TERMINATE' maintenance policy, got %s", policy)
+		}
+	}
+	return policy, nil
+}
+
 // N.B. neither boot disk nor additional persistent disks are assigned VM labels by default.
 // Hence, we must propagate them. See: https://cloud.google.com/compute/docs/labeling-resources#labeling_boot_disks
 func propagateDiskLabels(
-	l *logger.Logger,
-	project string,
-	labels string,
-	zoneToHostNames map[string][]string,
-	opts *vm.CreateOpts,
+	l *logger.Logger, project string, labels string, zoneToHostNames map[string][]string,
 ) error {
 	var g errgroup.Group
 
 	l.Printf("Propagating labels across all disks")
-	argsPrefix := []string{"compute", "disks", "update"}
-	argsPrefix = append(argsPrefix, "--update-labels", labels)
-	argsPrefix = append(argsPrefix, "--project", project)
 
 	for zone, zoneHosts := range zoneToHostNames {
-		zoneArg := []string{"--zone", zone}
-
 		for _, host := range zoneHosts {
-			hostName := host
-
+			zone, host := zone, host
 			g.Go(func() error {
-				bootDiskArgs := append([]string(nil), argsPrefix...)
-				bootDiskArgs = append(bootDiskArgs, zoneArg...)
-				// N.B. boot disk has the same name as the host.
-				bootDiskArgs = append(bootDiskArgs, hostName)
-				cmd := exec.Command("gcloud", bootDiskArgs...)
-
-				output, err := cmd.CombinedOutput()
+				// N.B. rather than relying on the naming convention of the disks,
+				// label the disks which are actually attached to the instance.
+				disks, err := attachedDisks(project, zone, host)
 				if err != nil {
-					return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", bootDiskArgs, output)
+					return err
+				}
+				for _, disk := range disks {
+					if err := updateDiskLabels(project, zone, disk.name, disk.labels(labels)); err != nil {
+						return err
+					}
 				}
 				return nil
 			})
+		}
+	}
+	return g.Wait()
+}
 
-			if !opts.SSDOpts.UseLocalSSD {
-				g.Go(func() error {
-					persistentDiskArgs := append([]string(nil), argsPrefix...)
-					persistentDiskArgs = append(persistentDiskArgs, zoneArg...)
-					// N.B. additional persistent disks are suffixed with the offset, starting at 1.
-					persistentDiskArgs = append(persistentDiskArgs, fmt.Sprintf("%s-1", hostName))
-					cmd := exec.Command("gcloud", persistentDiskArgs...)
+// Placeholders for the disk and its role in the disk labeling commands printed
+// in dry runs, since the disks are only known once the instances exist.
+const (
+	dryRunDiskPlaceholder = "DISK"
+	dryRunRolePlaceholder = "ROLE"
+)
 
-					output, err := cmd.CombinedOutput()
-					if err != nil {
-						return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", persistentDiskArgs, output)
-					}
-					return nil
-				})
-			}
+// printDiskLabelCommands prints the commands propagateDiskLabels would run:
+// for each of the instances, the command describing its disks, followed by
+// the command labeling each of the described persistent disks.
+func printDiskLabelCommands(
+	l *logger.Logger, project string, labels string, zoneToHostNames map[string][]string,
+) {
+	zones := maps.Keys(zoneToHostNames)
+	sort.Strings(zones)
+	for _, zone := range zones {
+		for _, host := range zoneToHostNames[zone] {
+			l.Printf("Dry run: gcloud %s", strings.Join(attachedDisksArgs(project, zone, host), " "))
+			l.Printf("Dry run: for each persistent disk %s of %s, of %s %s or %s: gcloud %s",
+				dryRunDiskPlaceholder, host, dryRunRolePlaceholder, diskRoleBoot, diskRoleData,
+				strings.Join(updateDiskLabelsArgs(project, zone, dryRunDiskPlaceholder,
+					withDiskRole(labels, dryRunRolePlaceholder)), " "))
 		}
 	}
-	return g.Wait()
+}
+
+// attachedDisksArgs returns the gcloud arguments to describe the disks
+// attached to the given instance.
+func attachedDisksArgs(project, zone, instance string) []string {
+	return []string{
+		"compute", "instances", "describe", instance,
+		"--project", project,
+		"--zone", zone,
+		"--format", "json(disks)",
+	}
+}
+
+// attachedDisks returns the persistent disks, including the boot disk, which
+// are attached to the given instance.
+func attachedDisks(project, zone, instance string) ([]zonalDisk, error) {
+	var described instanceDisksResponse
+	if err := runJSONCommand(attachedDisksArgs(project, zone, instance), &described); err != nil {
+		return nil, err
+	}
+	var disks []zonalDisk
+	for _, disk := range described.Disks {
+		// Scratch disks, i.e. local SSDs, have no source and can't be labeled.
+		if disk.Source == "" {
+			continue
+		}
+		disks = append(disks, zonalDisk{zone: zone, name: lastComponent(disk.Source), boot: disk.Boot})
+	}
+	return disks, nil
+}
+
+// diskRoleLabel is the label propagated to the disks of an instance, on top of
+// the instance labels, which tells boot disks (diskRoleBoot) apart from data
+// disks (diskRoleData), e.g. for cost attribution.
+const (
+	diskRoleLabel = "disk"
+	diskRoleBoot  = "boot"
+	diskRoleData  = "data"
+)
+
+// zonalDisk identifies a disk by name and zone.
+type zonalDisk struct {
+	zone, name string
+	// boot is set for boot disks.
+	boot bool
+}
+
+// labels returns the labels to propagate to the disk, i.e. the given instance
+// labels, formatted as a comma-separated list of key=value pairs, along with
+// the role of the disk.
+func (d zonalDisk) labels(instanceLabels string) string {
+	role := diskRoleData
+	if d.boot {
+		role = diskRoleBoot
+	}
+	return withDiskRole(instanceLabels, role)
+}
+
+// withDiskRole returns the given instance labels along with the given disk role,
+// formatted as a comma-separated list of key=value pairs.
+func withDiskRole(instanceLabels, role string) string {
+	return fmt.Sprintf("%s,%s=%s", instanceLabels, diskRoleLabel, role)
+}
+
+// diskLabelsRetryOpts are the retry options used when updating the labels of
+// a disk fails because the disk is not ready yet.
+var diskLabelsRetryOpts = retry.Options{
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	MaxRetries:     5,
+}
+
+// resourceNotReadyRE matches the output of gcloud commands which failed
+// because the resource they operate on is not ready yet. This is transient,
+// and commonly happens right after instance creation.
+var resourceNotReadyRE = regexp.MustCompile(`resourceNotReady|The resource '[^']*' is not ready`)
+
+// updateDiskLabels adds the given labels, formatted as a comma-separated list
+// of key=value pairs, to the given disk. The update is retried if the disk is
+// not ready yet. Once it succeeds, the disk is described to verify that the
+// labels were applied.
+func updateDiskLabels(project, zone, disk, labels string) error {
+	args := updateDiskLabelsArgs(project, zone, disk, labels)
+	var output []byte
+	var err error
+	for r := retry.Start(diskLabelsRetryOpts); r.Next(); {
+		output, err = runner.CombinedOutput(context.Background(), args...)
+		if err == nil || !resourceNotReadyRE.Match(output) {
+			break
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", args, output)
+	}
+	return verifyDiskLabels(project, zone, disk, labels)
+}
+
+// updateDiskLabelsArgs returns the gcloud arguments to add the given labels to
+// the given disk.
+func updateDiskLabelsArgs(project, zone, disk, labels string) []string {
+	return []string{
+		"compute", "disks", "update",
+		"--update-labels", labels,
+		"--project", project,
+		"--zone", zone,
+		disk,
+	}
+}
+
+// verifyDiskLabels checks that the given disk carries the given labels,
+// formatted as a comma-separated list of key=value pairs.
+func verifyDiskLabels(project, zone, disk, labels string) error {
+	args := []string{
+		"compute", "disks", "describe", disk,
+		"--project", project,
+		"--zone", zone,
+		"--format", "json(labels)",
+	}
+	var described struct {
+		Labels map[string]string `json:"labels"`
+	}
+	if err := runJSONCommand(args, &described); err != nil {
+		return err
+	}
+	for _, pair := range strings.Split(labels, ",") {
+		key, value, _ := strings.Cut(pair, "=")
+		if actual, ok := described.Labels[key]; !ok || actual != value {
+			return errors.Newf("label %s=%s was not applied to disk %s (found %q)", key, value, disk, actual)
+		}
+	}
+	return nil
 }
 
 // Delete TODO(peter): document
+//
+// The instances of a cluster backed by a MIG (see ProviderOpts.UseMIG) are
+// deleted via the MIG, see deleteFromMIG.
 func (p *Provider) Delete(l *logger.Logger, vms vm.List) error {
 	// Map from project to map of zone to list of machines in that project/zone.
 	projectZoneMap := make(map[string]map[string][]string)
+	// Map from the MIGs, identified by project, region and name, to the list
+	// of their machines.
+	type migKey struct{ project, region, group string }
+	migMap := make(map[migKey][]string)
 	for _, v := range vms {
 		if v.Provider != ProviderName {
 			return errors.Errorf("%s received VM instance from %s", ProviderName, v.Provider)
 		}
+		if group := v.Labels[migLabel]; group != "" {
+			key := migKey{project: v.Project, region: ZoneToRegion(v.Zone), group: group}
+			migMap[key] = append(migMap[key], v.Name)
+			continue
+		}
 		if projectZoneMap[v.Project] == nil {
 			projectZoneMap[v.Project] = make(map[string][]string)
 		}
@@ -1375,6 +3715,12 @@ func (p *Provider) Delete(l *logger.Logger, vms vm.List) error {
 	var g errgroup.Group
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
+	for key, names := range migMap {
+		key, names := key, names
+		g.Go(func() error {
+			return deleteFromMIG(ctx, l, key.project, key.region, key.group, names)
+		})
+	}
 	for project, zoneMap := range projectZoneMap {
 		for zone, names := range zoneMap {
 			args := []string{
@@ -1386,13 +3732,19 @@ func (p *Provider) Delete(l *logger.Logger, vms vm.List) error {
 			args = append(args, "--zone", zone)
 			args = append(args, names...)
 
+			project, zone, names := project, zone, names
 			g.Go(func() error {
-				cmd := exec.CommandContext(ctx, "gcloud", args...)
-
-				output, err := cmd.CombinedOutput()
+				output, err := runner.CombinedOutput(ctx, args...)
 				if err != nil {
+					if deletionProtectedRE.Match(output) {
+						return errors.Newf("cannot delete %s: protected against deletion; "+
+							"disable the protection with `gcloud compute instances update --project %s --zone %s "+
+							"--no-deletion-protection NAME` and retry\nOutput: %s",
+							strings.Join(names, ", "), project, zone, output)
+					}
 					return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", args, output)
 				}
+				logOperations(l, args, output)
 				return nil
 			})
 		}
@@ -1401,8 +3753,251 @@ func (p *Provider) Delete(l *logger.Logger, vms vm.List) error {
 	return g.Wait()
 }
 
+// SetDeletionProtection enables or disables the protection of the given VMs
+// against deletion (see ProviderOpts.DeletionProtection).
+func (p *Provider) SetDeletionProtection(l *logger.Logger, vms vm.List, enabled bool) error {
+	// Map from project to map of zone to list of machines in that project/zone.
+	projectZoneMap := make(map[string]map[string][]string)
+	for _, v := range vms {
+		if v.Provider != ProviderName {
+			return errors.Errorf("%s received VM instance from %s", ProviderName, v.Provider)
+		}
+		if projectZoneMap[v.Project] == nil {
+			projectZoneMap[v.Project] = make(map[string][]string)
+		}
+
+		projectZoneMap[v.Project][v.Zone] = append(projectZoneMap[v.Project][v.Zone], v.Name)
+	}
+
+	flag := "--no-deletion-protection"
+	if enabled {
+		flag = "--deletion-protection"
+	}
+	var g errgroup.Group
+	for project, zoneMap := range projectZoneMap {
+		for zone, names := range zoneMap {
+			project, zone, names := project, zone, names
+			// N.B. unlike most instance commands, update takes a single instance.
+			g.Go(func() error {
+				for _, name := range names {
+					args := []string{
+						"compute", "instances", "update", name,
+						"--project", project,
+						"--zone", zone,
+						flag,
+					}
+					output, err := runner.CombinedOutput(context.Background(), args...)
+					if err != nil {
+						return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", args, output)
+					}
+				}
+				return nil
+			})
+		}
+	}
+	return g.Wait()
+}
+
+// deletionProtectedRE matches the output of gcloud commands which failed
+// because an instance is protected against deletion (see
+// ProviderOpts.DeletionProtection).
+var deletionProtectedRE = regexp.MustCompile(`protected against deletion|deletionProtection`)
+
+// deleteSnapshotTimeFormat is the format of the timestamp suffixed to the
+// names of the snapshots created by DeleteWithSnapshots.
+const deleteSnapshotTimeFormat = "20060102150405"
+
+// DeleteWithSnapshots is like Delete, but first snapshots the non-boot
+// persistent disks attached to the given VMs so that their data outlives the
+// instances. Boot disks and local SSDs are not snapshotted. It returns the IDs
+// of the created snapshots. No instance is deleted unless all the snapshots
+// were created successfully.
+func (p *Provider) DeleteWithSnapshots(l *logger.Logger, vms vm.List) ([]string, error) {
+	suffix := timeutil.Now().Format(deleteSnapshotTimeFormat)
+	snapshotIDs := make([][]string, len(vms))
+	for _, v := range vms {
+		if v.Provider != ProviderName {
+			return nil, errors.Errorf("%s received VM instance from %s", ProviderName, v.Provider)
+		}
+	}
+	var g errgroup.Group
+	for i, v := range vms {
+		i, v := i, v
+		g.Go(func() error {
+			labels := map[string]string{
+				vm.TagRoachprod:          "true",
+				vm.TagCreated:            createdLabel(timeutil.Now()),
+				"roachprod-cluster-node": v.Name,
+			}
+			if cluster, ok := v.Labels[vm.TagCluster]; ok {
+				labels[vm.TagCluster] = cluster
+			}
+			for _, volume := range v.NonBootAttachedVolumes {
+				name := volume.Name
+				// GCE resource names are at most 63 characters long.
+				if maxLen := 63 - len(suffix) - 1; len(name) > maxLen {
+					name = strings.TrimSuffix(name[:maxLen], "-")
+				}
+				// N.B. the snapshot is created in the project of the VM, which may
+				// not be the default project, see ProviderOpts.MultiProject.
+				snapshot, err := createVolumeSnapshot(v.Project, volume, vm.VolumeSnapshotCreateOpts{
+					Name:        fmt.Sprintf("%s-%s", name, suffix),
+					Labels:      labels,
+					Description: fmt.Sprintf("snapshot of %s taken before deleting %s", volume.Name, v.Name),
+				})
+				if err != nil {
+					return errors.Wrapf(err, "snapshotting volume %s of %s", volume.Name, v.Name)
+				}
+				l.Printf("created snapshot %s (id=%s) of volume %s on %s",
+					snapshot.Name, snapshot.ID, volume.Name, v.Name)
+				snapshotIDs[i] = append(snapshotIDs[i], snapshot.ID)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, vmSnapshotIDs := range snapshotIDs {
+		ids = append(ids, vmSnapshotIDs...)
+	}
+	if err := p.Delete(l, vms); err != nil {
+		return ids, err
+	}
+	return ids, nil
+}
+
 // Reset implements the vm.Provider interface.
 func (p *Provider) Reset(l *logger.Logger, vms vm.List) error {
+	return p.reset(l, vms, false /* force */, false /* graceful */)
+}
+
+// ForceReset implements the vm.ForceReset interface. Unlike Reset, it starts
+// the instances which are TERMINATED or STOPPED, since they can't be reset.
+func (p *Provider) ForceReset(l *logger.Logger, vms vm.List) error {
+	return p.reset(l, vms, true /* force */, false /* graceful */)
+}
+
+// GracefulReset is like Reset, but stops and then starts the instances
+// instead of hard resetting them. This lets the guest OS shut down cleanly,
+// e.g. flushing in-flight disk writes, at the expense of a slower restart.
+func (p *Provider) GracefulReset(l *logger.Logger, vms vm.List) error {
+	return p.reset(l, vms, false /* force */, true /* graceful */)
+}
+
+// isStopped returns whether the given VM is stopped, i.e. needs to be started
+// rather than reset.
+func isStopped(v vm.VM) bool {
+	return v.Status == "TERMINATED" || v.Status == "STOPPED"
+}
+
+func (p *Provider) reset(l *logger.Logger, vms vm.List, force, graceful bool) error {
+	// Map from command to project to zone to list of machines in that
+	// project/zone.
+	commandProjectZoneMap := make(map[string]map[string]map[string][]string)
+	for _, v := range vms {
+		if v.Provider != ProviderName {
+			return errors.Errorf("%s received VM instance from %s", ProviderName, v.Provider)
+		}
+		command := "reset"
+		if isStopped(v) {
+			if !force {
+				return errors.Errorf("cannot reset %s: instance is %s rather than RUNNING; "+
+					"use --force to start it instead", v.Name, v.Status)
+			}
+			l.Printf("%s is %s; starting it instead of resetting it", v.Name, v.Status)
+			command = "start"
+		}
+		if commandProjectZoneMap[command] == nil {
+			commandProjectZoneMap[command] = make(map[string]map[string][]string)
+		}
+		projectZoneMap := commandProjectZoneMap[command]
+		if projectZoneMap[v.Project] == nil {
+			projectZoneMap[v.Project] = make(map[string][]string)
+		}
+
+		projectZoneMap[v.Project][v.Zone] = append(projectZoneMap[v.Project][v.Zone], v.Name)
+	}
+
+	var g errgroup.Group
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	for command, projectZoneMap := range commandProjectZoneMap {
+		for project, zoneMap := range projectZoneMap {
+			for zone, names := range zoneMap {
+				subcommands := []string{command}
+				if command == "reset" && graceful {
+					subcommands = []string{"stop", "start"}
+				}
+				var commands [][]string
+				for _, subcommand := range subcommands {
+					args := []string{
+						"compute", "instances", subcommand,
+					}
+
+					args = append(args, "--project", project)
+					args = append(args, "--zone", zone)
+					args = append(args, names...)
+					commands = append(commands, args)
+				}
+
+				g.Go(func() error {
+					for _, args := range commands {
+						output, err := runner.CombinedOutput(ctx, args...)
+						if err != nil {
+							return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", args, output)
+						}
+					}
+					return nil
+				})
+			}
+		}
+	}
+
+	return g.Wait()
+}
+
+// suspendUnsupportedMachineFamilies are the machine families whose instances
+// can't be suspended. See:
+// https://cloud.google.com/compute/docs/instances/suspend-resume-instance#limitations
+var suspendUnsupportedMachineFamilies = map[string]struct{}{
+	"a2": {}, "a3": {}, "g2": {}, "m1": {}, "m2": {}, "m3": {}, "h3": {}, "z3": {},
+}
+
+// validateSuspend checks that the given machine type supports suspending its
+// instances.
+func validateSuspend(machineType string) error {
+	family, _, _ := strings.Cut(strings.ToLower(machineType), "-")
+	if _, ok := suspendUnsupportedMachineFamilies[family]; ok {
+		return errors.Errorf("machine type %s doesn't support suspending instances, use stop instead", machineType)
+	}
+	return nil
+}
+
+// Suspend suspends the given VMs, preserving the contents of their memory.
+// Unlike stopping them, this allows quickly pausing and resuming a cluster.
+func (p *Provider) Suspend(l *logger.Logger, vms vm.List) error {
+	for _, v := range vms {
+		if v.Provider != ProviderName {
+			continue
+		}
+		if err := validateSuspend(v.MachineType); err != nil {
+			return errors.Wrapf(err, "cannot suspend %s", v.Name)
+		}
+	}
+	return p.runInstancesCommand(vms, "suspend")
+}
+
+// Resume resumes the given VMs, previously suspended with Suspend.
+func (p *Provider) Resume(l *logger.Logger, vms vm.List) error {
+	return p.runInstancesCommand(vms, "resume")
+}
+
+// runInstancesCommand runs the given `gcloud compute instances` command on
+// the given VMs, once per project and zone.
+func (p *Provider) runInstancesCommand(vms vm.List, command string) error {
 	// Map from project to map of zone to list of machines in that project/zone.
 	projectZoneMap := make(map[string]map[string][]string)
 	for _, v := range vms {
@@ -1422,17 +4017,14 @@ func (p *Provider) Reset(l *logger.Logger, vms vm.List) error {
 	for project, zoneMap := range projectZoneMap {
 		for zone, names := range zoneMap {
 			args := []string{
-				"compute", "instances", "reset",
+				"compute", "instances", command,
+				"--project", project,
+				"--zone", zone,
 			}
-
-			args = append(args, "--project", project)
-			args = append(args, "--zone", zone)
 			args = append(args, names...)
 
 			g.Go(func() error {
-				cmd := exec.CommandContext(ctx, "gcloud", args...)
-
-				output, err := cmd.CombinedOutput()
+				output, err := runner.CombinedOutput(ctx, args...)
 				if err != nil {
 					return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", args, output)
 				}
@@ -1440,10 +4032,70 @@ func (p *Provider) Reset(l *logger.Logger, vms vm.List) error {
 			})
 		}
 	}
-
 	return g.Wait()
 }
 
+// ResizeVM changes the machine type of the given VM in place. The instance is
+// stopped, its machine type is updated and it is then restarted. The new
+// machine type is validated against the VM's architecture and local SSD
+// configuration before any command is run.
+func (p *Provider) ResizeVM(l *logger.Logger, v *vm.VM, newMachineType string) error {
+	if v.Provider != ProviderName {
+		return errors.Errorf("%s received VM instance from %s", ProviderName, v.Provider)
+	}
+	if err := validateResize(v, newMachineType); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	for _, args := range [][]string{
+		{"compute", "instances", "stop", v.Name},
+		{"compute", "instances", "set-machine-type", v.Name, "--machine-type", newMachineType},
+		{"compute", "instances", "start", v.Name},
+	} {
+		args = append(args, "--project", v.Project, "--zone", v.Zone)
+		output, err := runner.CombinedOutput(ctx, args...)
+		if err != nil {
+			return errors.Wrapf(err, "Command: gcloud %s\nOutput: %s", args, output)
+		}
+	}
+	l.Printf("Resized %s from %s to %s", v.Name, v.MachineType, newMachineType)
+	v.MachineType = newMachineType
+	return nil
+}
+
+// validateResize checks that the given VM can be resized to newMachineType.
+func validateResize(v *vm.VM, newMachineType string) error {
+	if newMachineType == "" {
+		return errors.New("new machine type must be specified")
+	}
+	if newMachineType == v.MachineType {
+		return errors.Newf("%s already has machine type %s", v.Name, newMachineType)
+	}
+	isArm := func(machineType string) bool {
+		return strings.HasPrefix(strings.ToLower(machineType), "t2a-")
+	}
+	if isArm(v.MachineType) != isArm(newMachineType) {
+		return errors.Newf("cannot resize %s from %s to %s: the architectures differ",
+			v.Name, v.MachineType, newMachineType)
+	}
+	if len(v.LocalDisks) > 0 {
+		counts, err := AllowedLocalSSDCount(newMachineType)
+		if err != nil {
+			return errors.Wrapf(err, "cannot resize %s to %s", v.Name, newMachineType)
+		}
+		for _, c := range counts {
+			if c == len(v.LocalDisks) {
+				return nil
+			}
+		}
+		return errors.Newf("cannot resize %s to %s: %d local SSDs are attached, but %s supports %v",
+			v.Name, newMachineType, len(v.LocalDisks), newMachineType, counts)
+	}
+	return nil
+}
+
 // Extend TODO(peter): document
 func (p *Provider) Extend(l *logger.Logger, vms vm.List, lifetime time.Duration) error {
 	return p.AddLabels(l, vms, map[string]string{
@@ -1464,9 +4116,10 @@ func (p *Provider) FindActiveAccount(l *logger.Logger) (string, error) {
 		return "", fmt.Errorf("no active accounts found, please configure gcloud")
 	}
 
-	if !strings.HasSuffix(accounts[0].Account, config.EmailDomain) {
+	domains := p.allowedEmailDomains()
+	if !hasEmailDomain(accounts[0].Account, domains) {
 		return "", fmt.Errorf("active account %q does not belong to domain %s",
-			accounts[0].Account, config.EmailDomain)
+			accounts[0].Account, strings.Join(domains, ", "))
 	}
 	_ = accounts[0].Status // silence unused warning
 
@@ -1474,8 +4127,70 @@ func (p *Provider) FindActiveAccount(l *logger.Logger) (string, error) {
 	return username, nil
 }
 
+// allowedEmailDomainsEnvVar is the environment variable listing the domains
+// of the accounts accepted by FindActiveAccount, separated by commas.
+const allowedEmailDomainsEnvVar = "GCE_ALLOWED_EMAIL_DOMAINS"
+
+// allowedEmailDomains returns the domains of the accounts accepted by
+// FindActiveAccount, see Provider.AllowedEmailDomains.
+func (p *Provider) allowedEmailDomains() []string {
+	if len(p.AllowedEmailDomains) > 0 {
+		return p.AllowedEmailDomains
+	}
+	var domains []string
+	for _, domain := range strings.Split(os.Getenv(allowedEmailDomainsEnvVar), ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	if len(domains) == 0 {
+		return []string{config.EmailDomain}
+	}
+	return domains
+}
+
+// hasEmailDomain returns whether the given account belongs to one of the given
+// domains, which may be prefixed with '@' like config.EmailDomain.
+func hasEmailDomain(account string, domains []string) bool {
+	_, accountDomain, ok := strings.Cut(account, "@")
+	if !ok {
+		return false
+	}
+	for _, domain := range domains {
+		if strings.EqualFold(accountDomain, strings.TrimPrefix(domain, "@")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListActiveAccounts returns the usernames of all the active gcloud accounts.
+// Unlike FindActiveAccount, it allows for multiple active accounts and
+// doesn't restrict them to config.EmailDomain, which is useful for
+// diagnostics.
+func (p *Provider) ListActiveAccounts(l *logger.Logger) ([]string, error) {
+	args := []string{"auth", "list", "--format", "json", "--filter", "status~ACTIVE"}
+
+	accounts := make([]jsonAuth, 0)
+	if err := runJSONCommand(args, &accounts); err != nil {
+		return nil, err
+	}
+
+	usernames := make([]string, 0, len(accounts))
+	for _, account := range accounts {
+		usernames = append(usernames, strings.Split(account.Account, "@")[0])
+	}
+	return usernames, nil
+}
+
 // List queries gcloud to produce a list of VM info objects.
 func (p *Provider) List(l *logger.Logger, opts vm.ListOptions) (vm.List, error) {
+	return p.list(l, opts, "" /* filter */)
+}
+
+// list is like List, but only lists the instances matching the given gcloud
+// filter expression, if not empty.
+func (p *Provider) list(l *logger.Logger, opts vm.ListOptions, filter string) (vm.List, error) {
 	if opts.IncludeVolumes {
 		l.Printf("WARN: --include-volumes is disabled; attached disks info will be partial")
 	}
@@ -1483,6 +4198,9 @@ func (p *Provider) List(l *logger.Logger, opts vm.ListOptions) (vm.List, error)
 	var vms vm.List
 	for _, prj := range p.GetProjects() {
 		args := []string{"compute", "instances", "list", "--project", prj, "--format", "json"}
+		if filter != "" {
+			args = append(args, "--filter", filter)
+		}
 
 		// Run the command, extracting the JSON payload
 		jsonVMS := make([]jsonVM, 0)
@@ -1527,10 +4245,16 @@ func (p *Provider) List(l *logger.Logger, opts vm.ListOptions) (vm.List, error)
 				// The former is a subset of the latter. Some information like `Labels` will be missing.
 				disks = toDescribeVolumeCommandResponse(jsonVM.Disks, jsonVM.Zone)
 			}
-			vms = append(vms, *jsonVM.toVM(prj, disks, defaultOpts))
+			v := jsonVM.toVM(prj, disks, defaultOpts)
+			if opts.OnlyRoachprodManaged && !vm.IsRoachprodManaged(*v) {
+				continue
+			}
+			vms = append(vms, *v)
 		}
 	}
 
+	warnVMErrors(l, vms)
+
 	if opts.ComputeEstimatedCost {
 		if err := populateCostPerHour(l, vms); err != nil {
 			// N.B. We continue despite the error since it doesn't prevent 'List' and other commands which may depend on it.
@@ -1545,6 +4269,123 @@ func (p *Provider) List(l *logger.Logger, opts vm.ListOptions) (vm.List, error)
 	return vms, nil
 }
 
+// DeleteByLabel deletes the instances carrying all of the given labels, which
+// are matched server-side, and returns them. Keys and values are sanitized as
+// they are when labeling the instances. An empty set of labels, which would
+// match every instance, is rejected.
+func (p *Provider) DeleteByLabel(l *logger.Logger, labels map[string]string) (vm.List, error) {
+	if len(labels) == 0 {
+		return nil, errors.New("refusing to delete all instances: no labels specified")
+	}
+	keys := maps.Keys(labels)
+	sort.Strings(keys)
+	var filters []string
+	for _, key := range keys {
+		filters = append(filters, fmt.Sprintf("labels.%s=%s", serializeLabel(key), serializeLabel(labels[key])))
+	}
+	vms, err := p.list(l, vm.ListOptions{}, strings.Join(filters, " AND "))
+	if err != nil {
+		return nil, err
+	}
+	if len(vms) == 0 {
+		return nil, nil
+	}
+	l.Printf("Deleting %d instances labeled %s: %s", len(vms), strings.Join(filters, " AND "),
+		strings.Join(vms.Names(), ", "))
+	if err := p.Delete(l, vms); err != nil {
+		return nil, err
+	}
+	return vms, nil
+}
+
+// ExpiredVMs lists the VMs and returns those which have outlived their
+// lifetime label, i.e. whose CreatedAt + Lifetime is in the past. VMs whose
+// expiration can't be determined (vm.ErrNoExpiration), e.g. because they have
+// no lifetime label, are only returned if includeUnlabeled is set.
+func (p *Provider) ExpiredVMs(l *logger.Logger, includeUnlabeled bool) (vm.List, error) {
+	vms, err := p.List(l, vm.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	now := timeutil.Now()
+	var expired vm.List
+	for _, v := range vms {
+		if hasNoExpiration(v) {
+			if includeUnlabeled {
+				expired = append(expired, v)
+			}
+			continue
+		}
+		if v.CreatedAt.Add(v.Lifetime).Before(now) {
+			expired = append(expired, v)
+		}
+	}
+	return expired, nil
+}
+
+// hasNoExpiration returns whether the expiration of the given VM can't be
+// determined.
+func hasNoExpiration(v vm.VM) bool {
+	for _, err := range v.Errors {
+		if errors.Is(err, vm.ErrNoExpiration) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxVMErrorWarnings is the maximum number of VMs for which List logs a
+// warning about parse errors; the remaining ones are only counted.
+const maxVMErrorWarnings = 10
+
+// warnVMErrors logs a warning for each VM with non-empty Errors, summarizing
+// the failed checks. To avoid flooding the log, at most maxVMErrorWarnings VMs
+// are reported individually.
+func warnVMErrors(l *logger.Logger, vms vm.List) {
+	var numWithErrors int
+	for _, v := range vms {
+		if len(v.Errors) == 0 {
+			continue
+		}
+		numWithErrors++
+		if numWithErrors > maxVMErrorWarnings {
+			continue
+		}
+		errStrs := make([]string, len(v.Errors))
+		for i, err := range v.Errors {
+			errStrs[i] = err.Error()
+		}
+		l.Printf("WARNING: VM %s in project %s has errors: %s", v.Name, v.Project, strings.Join(errStrs, "; "))
+	}
+	if numWithErrors > maxVMErrorWarnings {
+		l.Printf("WARNING: %d more VMs have errors", numWithErrors-maxVMErrorWarnings)
+	}
+}
+
+// FindVMByDNS lists the VMs in the configured projects and returns the one
+// whose internal (name.zone.project) or public (name.subdomain) DNS name
+// matches the given one. This is useful for reverse lookups when only a
+// hostname is known.
+func (p *Provider) FindVMByDNS(l *logger.Logger, dns string) (*vm.VM, error) {
+	vms, err := p.List(l, vm.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return findVMByDNS(vms, dns)
+}
+
+// findVMByDNS returns the VM in the given list matching the DNS name.
+func findVMByDNS(vms vm.List, dns string) (*vm.VM, error) {
+	// DNS names are case-insensitive and may be fully qualified.
+	dns = strings.ToLower(strings.TrimSuffix(dns, "."))
+	for i := range vms {
+		if strings.ToLower(vms[i].DNS) == dns || strings.ToLower(vms[i].PublicDNS) == dns {
+			return &vms[i], nil
+		}
+	}
+	return nil, errors.Newf("no VM found with DNS name %q", dns)
+}
+
 // Convert attachDiskCmdDisk to describeVolumeCommandResponse and link via SelfLink, Source.
 func toDescribeVolumeCommandResponse(
 	disks []attachDiskCmdDisk, zone string,
@@ -1633,7 +4474,7 @@ func populateCostPerHour(l *logger.Logger, vms vm.List) error {
 					},
 					Preemptible:     vm.Preemptible,
 					PersistentDisks: []*cloudbilling.PersistentDisk{},
-					Region:          zone[:len(zone)-2],
+					Region:          ZoneToRegion(zone),
 				},
 			}
 			if !strings.Contains(machineType, "custom") {
@@ -1744,6 +4585,19 @@ func (p *Provider) ProjectActive(project string) bool {
 	return false
 }
 
+// zoneRE matches GCE zone names, e.g. us-east1-b, capturing the region.
+var zoneRE = regexp.MustCompile(`^([a-z]+-[a-z]+[0-9]+)-[a-z]$`)
+
+// ZoneToRegion returns the region of the given zone, e.g. us-east1 for
+// us-east1-b, or the empty string if the zone is malformed.
+func ZoneToRegion(zone string) string {
+	matches := zoneRE.FindStringSubmatch(zone)
+	if matches == nil {
+		return ""
+	}
+	return matches[1]
+}
+
 // lastComponent splits a url path and returns only the last part. This is
 // used because some fields in GCE APIs are defined using URLs like:
 //
@@ -1767,8 +4621,107 @@ var (
 	gceUbuntuImages = map[vm.UbuntuVersion]vm.UbuntuImages{
 		vm.FocalFossa: focalFossa,
 	}
+
+	// gceUbuntuImageFamilies are the image families of the Ubuntu images,
+	// keyed by Ubuntu version; the empty version denotes the default images.
+	// They are used to resolve the latest images if --gce-image-latest is set.
+	gceUbuntuImageFamilies = map[vm.UbuntuVersion]vm.UbuntuImages{
+		"": {
+			DefaultImage: "ubuntu-2204-lts",
+			ARM64Image:   "ubuntu-2204-lts-arm64",
+			FIPSImage:    "ubuntu-pro-fips-2004-lts",
+		},
+		vm.FocalFossa: {
+			DefaultImage: "ubuntu-2004-lts",
+			ARM64Image:   "ubuntu-2004-lts-arm64",
+			FIPSImage:    "ubuntu-pro-fips-2004-lts",
+		},
+	}
 )
 
+// getLatestUbuntuImage returns the newest image in the image family which
+// corresponds to the specified Ubuntu version and architecture.
+func getLatestUbuntuImage(project string, version vm.UbuntuVersion, arch string) (string, error) {
+	families, ok := gceUbuntuImageFamilies[version]
+	if !ok {
+		return "", errors.Errorf("Unknown Ubuntu version specified.")
+	}
+	var family string
+	switch arch {
+	case "", string(vm.ArchAMD64):
+		family = families.DefaultImage
+	case string(vm.ArchARM64):
+		family = families.ARM64Image
+	case string(vm.ArchFIPS):
+		family = families.FIPSImage
+	default:
+		return "", errors.Errorf("Unknown architecture specified.")
+	}
+
+	args := []string{"compute", "images", "list",
+		"--project", project,
+		"--filter", fmt.Sprintf("family=%s", family),
+		"--sort-by", "~creationTimestamp",
+		"--limit", "1",
+		"--format", "json(name,creationTimestamp)",
+	}
+	var images []struct {
+		Name              string    `json:"name"`
+		CreationTimestamp time.Time `json:"creationTimestamp"`
+	}
+	if err := runJSONCommand(args, &images); err != nil {
+		return "", err
+	}
+	if len(images) == 0 {
+		return "", errors.Newf("no images found in family %s of project %s", family, project)
+	}
+	// N.B. the images are already sorted by gcloud; picking the newest one
+	// explicitly guards against the sorting and limit being ignored.
+	latest := images[0]
+	for _, image := range images[1:] {
+		if image.CreationTimestamp.After(latest.CreationTimestamp) {
+			latest = image
+		}
+	}
+	return latest.Name, nil
+}
+
+// ListImages returns the images in the given project, newest first. If
+// family is non-empty, only the images of that family are returned. If
+// project is empty, the default image project is used.
+func (p *Provider) ListImages(l *logger.Logger, family, project string) ([]vm.Image, error) {
+	if project == "" {
+		project = defaultImageProject
+	}
+	args := []string{"compute", "images", "list", "--project", project}
+	if family != "" {
+		args = append(args, "--filter", fmt.Sprintf("family=%s", family))
+	}
+	args = append(args, "--format", "json(name,family,creationTimestamp,architecture)")
+	var jsonImages []struct {
+		Name              string    `json:"name"`
+		Family            string    `json:"family"`
+		CreationTimestamp time.Time `json:"creationTimestamp"`
+		Architecture      string    `json:"architecture"`
+	}
+	if err := runJSONCommand(args, &jsonImages); err != nil {
+		return nil, err
+	}
+	images := make([]vm.Image, 0, len(jsonImages))
+	for _, image := range jsonImages {
+		images = append(images, vm.Image{
+			Name:         image.Name,
+			Family:       image.Family,
+			CreatedAt:    image.CreationTimestamp,
+			Architecture: image.Architecture,
+		})
+	}
+	sort.SliceStable(images, func(i, j int) bool {
+		return images[i].CreatedAt.After(images[j].CreatedAt)
+	})
+	return images, nil
+}
+
 // getUbuntuImage returns the correct Ubuntu image for the specified Ubuntu version and architecture.
 func getUbuntuImage(version vm.UbuntuVersion, arch string) (string, error) {
 	image, ok := gceUbuntuImages[version]
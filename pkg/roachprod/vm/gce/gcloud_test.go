@@ -11,15 +11,30 @@
 package gce
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/roachprod/config"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/vm"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
+	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAllowedLocalSSDCount(t *testing.T) {
@@ -188,3 +203,3194 @@ func Test_buildFilterPreemptionCliArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestFindVMByDNS(t *testing.T) {
+	const fixture = `[
+  {
+    "name": "test-cluster-0001",
+    "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b",
+    "labels": {"lifetime": "12h0m0s"}
+  },
+  {
+    "name": "test-cluster-0002",
+    "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-west1-a",
+    "labels": {"lifetime": "12h0m0s"}
+  }
+]`
+	var jsonVMs []jsonVM
+	require.NoError(t, json.Unmarshal([]byte(fixture), &jsonVMs))
+	var vms vm.List
+	for _, jsonVM := range jsonVMs {
+		vms = append(vms, *jsonVM.toVM("test-project", nil /* disks */, DefaultProviderOpts()))
+	}
+
+	for _, tc := range []struct {
+		dns      string
+		expected string
+	}{
+		{"test-cluster-0001.us-east1-b.test-project", "test-cluster-0001"},
+		{"test-cluster-0002.us-west1-a.test-project", "test-cluster-0002"},
+		{"test-cluster-0002." + Subdomain, "test-cluster-0002"},
+		{"TEST-cluster-0001." + Subdomain + ".", "test-cluster-0001"},
+	} {
+		t.Run(tc.dns, func(t *testing.T) {
+			v, err := findVMByDNS(vms, tc.dns)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, v.Name)
+		})
+	}
+
+	_, err := findVMByDNS(vms, "test-cluster-0003.us-east1-b.test-project")
+	require.ErrorContains(t, err, "no VM found with DNS name")
+}
+
+// fakeRunner is a commandRunner which records the gcloud commands it is asked
+// to run, instead of running them, and responds using the respond function,
+// if set.
+type fakeRunner struct {
+	syncutil.Mutex
+	commands []string
+	respond  func(args []string) ([]byte, error)
+}
+
+var _ commandRunner = &fakeRunner{}
+
+// Output implements the commandRunner interface.
+func (r *fakeRunner) Output(_ context.Context, args ...string) ([]byte, error) {
+	return r.run(args)
+}
+
+// CombinedOutput implements the commandRunner interface.
+func (r *fakeRunner) CombinedOutput(_ context.Context, args ...string) ([]byte, error) {
+	return r.run(args)
+}
+
+func (r *fakeRunner) run(args []string) ([]byte, error) {
+	r.Lock()
+	r.commands = append(r.commands, strings.Join(args, " "))
+	r.Unlock()
+	if r.respond == nil {
+		return nil, nil
+	}
+	return r.respond(args)
+}
+
+// Commands returns the commands run so far.
+func (r *fakeRunner) Commands() []string {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string(nil), r.commands...)
+}
+
+// withFakeRunner installs the given fakeRunner for the duration of the test.
+func withFakeRunner(t *testing.T, r *fakeRunner) {
+	prev := runner
+	runner = r
+	t.Cleanup(func() { runner = prev })
+}
+
+func TestAttachExistingDiskByName(t *testing.T) {
+	const diskJSON = `{
+  "name": "test-disk",
+  "sizeGb": "100",
+  "type": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/diskTypes/pd-ssd",
+  "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b"
+}`
+	r := &fakeRunner{
+		respond: func(args []string) ([]byte, error) {
+			return []byte(diskJSON), nil
+		},
+	}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	target := &vm.VM{Name: "test-vm", ProviderID: "test-vm", Zone: "us-west1-a"}
+	_, err := p.AttachExistingDiskByName(nil /* l */, "test-disk", "us-east1-b", target)
+	require.ErrorContains(t, err,
+		"disk test-disk is in zone us-east1-b, but VM test-vm is in zone us-west1-a")
+	// Only the disk should have been described; nothing was attached.
+	require.Equal(t, []string{
+		"compute --project test-project disks describe test-disk --zone us-east1-b --format json",
+	}, r.Commands())
+}
+
+// nilLogger returns a logger which discards its output.
+func nilLogger() *logger.Logger {
+	lcfg := logger.Config{
+		Stdout: io.Discard,
+		Stderr: io.Discard,
+	}
+	l, err := lcfg.NewLogger("" /* path */)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+func TestPropagateDiskLabelsRetry(t *testing.T) {
+	defer func(opts retry.Options) { diskLabelsRetryOpts = opts }(diskLabelsRetryOpts)
+	diskLabelsRetryOpts = retry.Options{
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		MaxRetries:     3,
+	}
+
+	var mu syncutil.Mutex
+	updates := make(map[string]int)
+	r := &fakeRunner{
+		respond: func(args []string) ([]byte, error) {
+			if isInstanceDescribe(args) {
+				return instanceDisks(args[3], 1 /* dataDisks */)
+			}
+			disk := args[len(args)-1]
+			switch args[2] {
+			case "update":
+				mu.Lock()
+				defer mu.Unlock()
+				updates[disk]++
+				if updates[disk] == 1 {
+					// Fail the first update of each disk with the transient error.
+					output := fmt.Sprintf("ERROR: (gcloud.compute.disks.update) Could not fetch resource:\n"+
+						" - The resource 'projects/test-project/zones/us-east1-b/disks/%s' is not ready", disk)
+					return []byte(output), errors.New("exit status 1")
+				}
+				return nil, nil
+			case "describe":
+				return []byte(fmt.Sprintf(`{"labels": {"cluster": "test", "lifetime": "12h0m0s", "disk": %q}}`,
+					instanceDiskRole(args[3]))), nil
+			}
+			return nil, errors.Newf("unexpected command: %v", args)
+		},
+	}
+	withFakeRunner(t, r)
+
+	zoneToHostNames := map[string][]string{"us-east1-b": {"test-0001"}}
+	require.NoError(t, propagateDiskLabels(
+		nilLogger(), "test-project", "cluster=test,lifetime=12h0m0s", zoneToHostNames,
+	))
+	// Both the boot disk and the persistent disk were updated twice.
+	require.Equal(t, map[string]int{"test-0001": 2, "test-0001-1": 2}, updates)
+
+	t.Run("labels not applied", func(t *testing.T) {
+		r.respond = func(args []string) ([]byte, error) {
+			if isInstanceDescribe(args) {
+				return instanceDisks(args[3], 0 /* dataDisks */)
+			}
+			if args[2] == "describe" {
+				return []byte(`{"labels": {"cluster": "test"}}`), nil
+			}
+			return nil, nil
+		}
+		err := propagateDiskLabels(
+			nilLogger(), "test-project", "cluster=test,lifetime=12h0m0s", zoneToHostNames,
+		)
+		require.ErrorContains(t, err, "label lifetime=12h0m0s was not applied to disk test-0001")
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		r.respond = func(args []string) ([]byte, error) {
+			if isInstanceDescribe(args) {
+				return instanceDisks(args[3], 0 /* dataDisks */)
+			}
+			return []byte("resourceNotReady"), errors.New("exit status 1")
+		}
+		err := propagateDiskLabels(
+			nilLogger(), "test-project", "cluster=test", map[string][]string{"us-east1-b": {"test-0002"}},
+		)
+		require.ErrorContains(t, err, "resourceNotReady")
+	})
+}
+
+// isInstanceDescribe returns whether args describe an instance.
+func isInstanceDescribe(args []string) bool {
+	return len(args) >= 4 && args[1] == "instances" && args[2] == "describe"
+}
+
+// instanceDisks returns the output of `gcloud compute instances describe
+// --format json(disks)` for an instance with a boot disk, the given number of
+// data disks named <instance>-1, <instance>-2, etc., and a local SSD.
+func instanceDisks(instance string, dataDisks int) ([]byte, error) {
+	const source = "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/disks/"
+	disks := []attachDiskCmdDisk{{Boot: true, Source: source + instance}}
+	for i := 1; i <= dataDisks; i++ {
+		disks = append(disks, attachDiskCmdDisk{Source: fmt.Sprintf("%s%s-%d", source, instance, i)})
+	}
+	disks = append(disks, attachDiskCmdDisk{Type: "SCRATCH", Interface: "NVME"})
+	return json.Marshal(instanceDisksResponse{Disks: disks})
+}
+
+// instanceDiskRole returns the value of the role label propagated to the given
+// disk, as named by instanceDisks.
+func instanceDiskRole(disk string) string {
+	if strings.Count(disk, "-") > 1 {
+		return diskRoleData
+	}
+	return diskRoleBoot
+}
+
+// argValue returns the value following the given flag in args, or the empty
+// string if the flag isn't present.
+func argValue(args []string, flag string) string {
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == flag {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// machineTypesResponse returns the output of `gcloud compute machine-types
+// list` offering the filtered machine type in each of the given zones.
+func machineTypesResponse(args []string, zones []string) ([]byte, error) {
+	machineType := strings.TrimPrefix(argValue(args, "--filter"), "name=")
+	var jsonMachineTypes []map[string]string
+	for _, zone := range zones {
+		jsonMachineTypes = append(jsonMachineTypes, map[string]string{"name": machineType, "zone": zone})
+	}
+	return json.Marshal(jsonMachineTypes)
+}
+
+// isListCommand returns whether args list the given compute resource.
+func isListCommand(args []string, resource string) bool {
+	return len(args) >= 3 && args[1] == resource && args[2] == "list"
+}
+
+// createResponder returns a respond function for a fakeRunner, which allows
+// Create to proceed in the given zones: the zones are listed as available,
+// every machine type is offered in all of them, instances only have a boot
+// disk and a local SSD, the labels of a disk are described as last updated,
+// and other commands succeed without output.
+func createResponder(zones ...string) func(args []string) ([]byte, error) {
+	var mu syncutil.Mutex
+	diskLabels := make(map[string]string)
+	return func(args []string) ([]byte, error) {
+		switch {
+		case isListCommand(args, "zones"):
+			var jsonZones []map[string]string
+			for _, zone := range zones {
+				jsonZones = append(jsonZones, map[string]string{"name": zone})
+			}
+			return json.Marshal(jsonZones)
+		case isListCommand(args, "machine-types"):
+			return machineTypesResponse(args, strings.Split(argValue(args, "--zones"), ","))
+		case isInstanceDescribe(args):
+			return instanceDisks(args[3], 0 /* dataDisks */)
+		case len(args) >= 3 && args[1] == "disks" && args[2] == "update":
+			mu.Lock()
+			defer mu.Unlock()
+			diskLabels[args[len(args)-1]] = argValue(args, "--update-labels")
+			return nil, nil
+		case len(args) >= 4 && args[1] == "disks" && args[2] == "describe":
+			mu.Lock()
+			defer mu.Unlock()
+			labels := make(map[string]string)
+			for _, pair := range strings.Split(diskLabels[args[3]], ",") {
+				key, value, _ := strings.Cut(pair, "=")
+				labels[key] = value
+			}
+			return json.Marshal(map[string]interface{}{"labels": labels})
+		}
+		return nil, nil
+	}
+}
+
+func TestCreateSkipDiskLabels(t *testing.T) {
+	p := &Provider{Projects: []string{"test-project"}}
+	names := []string{"test-0001", "test-0002"}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+
+	countDiskUpdates := func(commands []string) int {
+		var n int
+		for _, c := range commands {
+			if strings.HasPrefix(c, "compute disks update") {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Run("default", func(t *testing.T) {
+		r := &fakeRunner{respond: createResponder(defaultZones...)}
+		withFakeRunner(t, r)
+		require.NoError(t, p.Create(nilLogger(), names, opts, DefaultProviderOpts()))
+		require.Equal(t, len(names), countDiskUpdates(r.Commands()))
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		r := &fakeRunner{respond: createResponder(defaultZones...)}
+		withFakeRunner(t, r)
+		providerOpts := DefaultProviderOpts()
+		providerOpts.SkipDiskLabels = true
+		require.NoError(t, p.Create(nilLogger(), names, opts, providerOpts))
+		require.Equal(t, 0, countDiskUpdates(r.Commands()))
+		require.Len(t, r.Commands(), 3 /* zones list, machine types list and instances create */)
+	})
+}
+
+func TestProvisionedPerformanceReadback(t *testing.T) {
+	const diskJSON = `{
+  "name": "test-disk",
+  "selfLink": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/disks/test-disk",
+  "sizeGb": "500",
+  "type": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/diskTypes/pd-extreme",
+  "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b",
+  "provisionedIops": "10000",
+  "provisionedThroughput": "240"
+}`
+	var disk describeVolumeCommandResponse
+	require.NoError(t, json.Unmarshal([]byte(diskJSON), &disk))
+
+	t.Run("describe", func(t *testing.T) {
+		volume, err := disk.toVolume()
+		require.NoError(t, err)
+		require.Equal(t, "pd-extreme", volume.ProviderVolumeType)
+		require.Equal(t, 10000, volume.IOPS)
+		require.Equal(t, 240, volume.Throughput)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		jsonVM := jsonVM{Name: "test-vm", Zone: disk.Zone}
+		jsonVM.Disks = []attachDiskCmdDisk{{Source: disk.SelfLink, Type: "PERSISTENT"}}
+		v := jsonVM.toVM("test-project", []describeVolumeCommandResponse{disk}, DefaultProviderOpts())
+		require.Len(t, v.NonBootAttachedVolumes, 1)
+		require.Equal(t, 10000, v.NonBootAttachedVolumes[0].IOPS)
+		require.Equal(t, 240, v.NonBootAttachedVolumes[0].Throughput)
+	})
+
+	t.Run("not provisioned", func(t *testing.T) {
+		disk := disk
+		disk.ProvisionedIops, disk.ProvisionedThroughput = "", ""
+		volume, err := disk.toVolume()
+		require.NoError(t, err)
+		require.Zero(t, volume.IOPS)
+		require.Zero(t, volume.Throughput)
+	})
+}
+
+func TestPhysicalBlockSize(t *testing.T) {
+	const diskJSON = `{
+  "name": "test-disk",
+  "selfLink": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/disks/test-disk",
+  "sizeGb": "500",
+  "type": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/diskTypes/pd-ssd",
+  "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b",
+  "physicalBlockSizeBytes": "16384"
+}`
+	var disk describeVolumeCommandResponse
+	require.NoError(t, json.Unmarshal([]byte(diskJSON), &disk))
+
+	t.Run("describe", func(t *testing.T) {
+		volume, err := disk.toVolume()
+		require.NoError(t, err)
+		require.Equal(t, 16384, volume.PhysicalBlockSizeBytes)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		jsonVM := jsonVM{Name: "test-vm", Zone: disk.Zone}
+		jsonVM.Disks = []attachDiskCmdDisk{{Source: disk.SelfLink, Type: "PERSISTENT"}}
+		v := jsonVM.toVM("test-project", []describeVolumeCommandResponse{disk}, DefaultProviderOpts())
+		require.Len(t, v.NonBootAttachedVolumes, 1)
+		require.Equal(t, 16384, v.NonBootAttachedVolumes[0].PhysicalBlockSizeBytes)
+	})
+
+	t.Run("create", func(t *testing.T) {
+		withFakeRunner(t, &fakeRunner{respond: func(args []string) ([]byte, error) {
+			if args[4] != "create" {
+				return nil, nil
+			}
+			return []byte("[" + diskJSON + "]"), nil
+		}})
+		p := &Provider{Projects: []string{"test-project"}}
+		volume, err := p.CreateVolume(nilLogger(), vm.VolumeCreateOpts{
+			Name: "test-disk", Size: 500, Zone: "us-east1-b", SkipDefaultLabels: true,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 16384, volume.PhysicalBlockSizeBytes)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		disk := disk
+		disk.PhysicalBlockSizeBytes = "16K"
+		_, err := disk.toVolume()
+		require.ErrorContains(t, err, "invalid physical block size")
+	})
+}
+
+func TestWaitForVolumeReady(t *testing.T) {
+	defer func(opts retry.Options) { volumeReadyRetryOpts = opts }(volumeReadyRetryOpts)
+	volumeReadyRetryOpts = retry.Options{
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	}
+
+	// statuses returns a respond function reporting the given statuses in
+	// turn, and the last one thereafter.
+	statuses := func(statuses ...string) func(args []string) ([]byte, error) {
+		var mu syncutil.Mutex
+		return func(args []string) ([]byte, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			status := statuses[0]
+			if len(statuses) > 1 {
+				statuses = statuses[1:]
+			}
+			return json.Marshal(map[string]string{"status": status})
+		}
+	}
+	p := &Provider{Projects: []string{"test-project"}}
+	volume := vm.Volume{ProviderResourceID: "test-disk", Zone: "us-east1-b"}
+
+	t.Run("ready", func(t *testing.T) {
+		r := &fakeRunner{respond: statuses("CREATING", "CREATING", "READY")}
+		withFakeRunner(t, r)
+		l, logged := fileLogger(t)
+		require.NoError(t, p.WaitForVolumeReady(l, volume, time.Minute))
+		require.Len(t, r.Commands(), 3)
+		require.Equal(t, "compute --project test-project disks describe test-disk --zone us-east1-b "+
+			"--format json(status)", r.Commands()[0])
+		require.Contains(t, logged(), "Waiting for volume test-disk to be READY (status: CREATING)")
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		withFakeRunner(t, &fakeRunner{respond: statuses("CREATING", "FAILED")})
+		err := p.WaitForVolumeReady(nilLogger(), volume, time.Minute)
+		require.ErrorContains(t, err, "volume test-disk failed to be created")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		withFakeRunner(t, &fakeRunner{respond: statuses("CREATING")})
+		err := p.WaitForVolumeReady(nilLogger(), volume, 20*time.Millisecond)
+		require.ErrorContains(t, err, "waiting for volume test-disk to be READY (status: CREATING)")
+	})
+}
+
+func TestListOrphanedVolumes(t *testing.T) {
+	const disksJSON = `[{
+  "name": "test-0001-data",
+  "selfLink": "https://www.googleapis.com/compute/v1/projects/other-project/zones/us-east1-b/disks/test-0001-data",
+  "sizeGb": "500",
+  "type": "https://www.googleapis.com/compute/v1/projects/other-project/zones/us-east1-b/diskTypes/pd-ssd",
+  "zone": "https://www.googleapis.com/compute/v1/projects/other-project/zones/us-east1-b",
+  "users": ["https://www.googleapis.com/compute/v1/projects/other-project/zones/us-east1-b/instances/test-0001"]
+}, {
+  "name": "test-0002-data",
+  "selfLink": "https://www.googleapis.com/compute/v1/projects/other-project/zones/us-east1-b/disks/test-0002-data",
+  "sizeGb": "100",
+  "type": "https://www.googleapis.com/compute/v1/projects/other-project/zones/us-east1-b/diskTypes/pd-ssd",
+  "zone": "https://www.googleapis.com/compute/v1/projects/other-project/zones/us-east1-b"
+}]`
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		return []byte(disksJSON), nil
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	volumes, err := p.ListOrphanedVolumes(nilLogger(), "other-project")
+	require.NoError(t, err)
+	require.Len(t, volumes, 1)
+	require.Equal(t, "test-0002-data", volumes[0].Name)
+	require.Equal(t, 100, volumes[0].Size)
+	require.Equal(t, []string{
+		"compute disks list --project other-project --filter -users:* --format json",
+	}, r.Commands())
+
+	// The provider's project is used by default.
+	_, err = p.ListOrphanedVolumes(nilLogger(), "")
+	require.NoError(t, err)
+	require.Equal(t, "compute disks list --project test-project --filter -users:* --format json", r.Commands()[1])
+}
+
+// fileLogger returns a logger writing to a file in a temporary directory,
+// along with a function returning what was logged so far.
+func fileLogger(t *testing.T) (*logger.Logger, func() string) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	lcfg := logger.Config{
+		Stdout: io.Discard,
+		Stderr: io.Discard,
+	}
+	l, err := lcfg.NewLogger(path)
+	require.NoError(t, err)
+	t.Cleanup(l.Close)
+	return l, func() string {
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(b)
+	}
+}
+
+func TestCreateDryRun(t *testing.T) {
+	r := &fakeRunner{}
+	withFakeRunner(t, r)
+	l, logged := fileLogger(t)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	opts.SSDOpts.UseLocalSSD = false
+	providerOpts := DefaultProviderOpts()
+	providerOpts.Zones = []string{"us-east1-b", "us-west1-b"}
+	providerOpts.DryRun = true
+	require.NoError(t, p.Create(l, []string{"test-0001", "test-0002", "test-0003"}, opts, providerOpts))
+
+	require.Empty(t, r.Commands())
+	out := logged()
+	require.Regexp(t, `Dry run: gcloud compute instances create .* --zone us-east1-b test-0001 test-0003\n`, out)
+	require.Regexp(t, `Dry run: gcloud compute instances create .* --zone us-west1-b test-0002\n`, out)
+	// The disks are only known once the instances exist, so the disks are
+	// described and labeled as per a template rather than named.
+	for _, host := range []string{"test-0001", "test-0002", "test-0003"} {
+		require.Regexp(t, `Dry run: gcloud compute instances describe `+host+` --project test-project --zone us-\w+1-b --format json\(disks\)\n`, out)
+		require.Regexp(t, `Dry run: for each persistent disk DISK of `+host+`, of ROLE boot or data: `+
+			`gcloud compute disks update --update-labels \S+,disk=ROLE --project test-project --zone us-\w+1-b DISK\n`, out)
+	}
+	require.NotContains(t, out, "test-0001-1")
+}
+
+// TestCreateDryRunMatchesCreate verifies that the disk labeling commands
+// printed in dry runs target the same disks as the ones Create runs.
+func TestCreateDryRunMatchesCreate(t *testing.T) {
+	respond := createResponder("us-east1-b", "us-west1-b")
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		if isInstanceDescribe(args) {
+			return instanceDisks(args[3], 1 /* dataDisks */)
+		}
+		return respond(args)
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	providerOpts := DefaultProviderOpts()
+	providerOpts.Zones = []string{"us-east1-b", "us-west1-b"}
+	names := []string{"test-0001", "test-0002", "test-0003"}
+	require.NoError(t, p.Create(nilLogger(), names, opts, providerOpts))
+
+	// The labels carry the creation time, which differs across the runs, so
+	// only the role of the disk is compared.
+	labelsRE := regexp.MustCompile(`--update-labels \S+,disk=`)
+	var describes []string
+	updates := make(map[string][]string) // commands by instance
+	for _, c := range r.Commands() {
+		args := strings.Fields(c)
+		switch {
+		case isInstanceDescribe(args):
+			describes = append(describes, c)
+		case strings.HasPrefix(c, "compute disks update"):
+			disk := args[len(args)-1]
+			host := strings.TrimSuffix(disk, "-1")
+			role := instanceDiskRole(disk)
+			templated := strings.TrimSuffix(c, disk) + dryRunDiskPlaceholder
+			templated = strings.Replace(templated, "disk="+role, "disk="+dryRunRolePlaceholder, 1)
+			updates[host] = append(updates[host], labelsRE.ReplaceAllString(templated, "--update-labels disk="))
+		}
+	}
+	require.Len(t, describes, len(names))
+
+	l, logged := fileLogger(t)
+	providerOpts.DryRun = true
+	require.NoError(t, p.Create(l, names, opts, providerOpts))
+	out := labelsRE.ReplaceAllString(logged(), "--update-labels disk=")
+	// The dry run describes the same instances...
+	for _, c := range describes {
+		require.Contains(t, out, "Dry run: gcloud "+c+"\n")
+	}
+	// ...and labels each of their disks with the same command, up to the name
+	// and role of the disk.
+	for _, host := range names {
+		require.Len(t, updates[host], 2, "boot and data disk of %s", host)
+		for _, c := range updates[host] {
+			require.Contains(t, out, fmt.Sprintf("of %s, of ROLE boot or data: gcloud %s\n", host, c))
+		}
+	}
+}
+
+func TestCreateInstances(t *testing.T) {
+	r := &fakeRunner{respond: createResponder("us-east1-b", "us-west1-b")}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	providerOpts := DefaultProviderOpts()
+	providerOpts.Zones = []string{"us-east1-b", "us-west1-b"}
+	created, err := p.CreateInstances(nilLogger(), []string{"test-0001", "test-0002", "test-0003"}, opts, providerOpts)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"test-0001": "us-east1-b",
+		"test-0002": "us-west1-b",
+		"test-0003": "us-east1-b",
+	}, created)
+
+	// Nothing is created in dry runs.
+	providerOpts.DryRun = true
+	created, err = p.CreateInstances(nilLogger(), []string{"test-0004"}, opts, providerOpts)
+	require.NoError(t, err)
+	require.Empty(t, created)
+}
+
+func TestCreateInstancesMultipleProjects(t *testing.T) {
+	r := &fakeRunner{respond: createResponder("us-east1-b", "us-west1-b")}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"project-a", "project-b"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	providerOpts := DefaultProviderOpts()
+	providerOpts.Zones = []string{"us-east1-b", "us-west1-b"}
+	// Spreading the instances across the projects is opt-in.
+	_, err := p.CreateInstances(nilLogger(), []string{"test-0001", "test-0002"}, opts, providerOpts)
+	require.ErrorContains(t, err, "multiple projects not supported")
+	require.Empty(t, r.Commands())
+
+	providerOpts.MultiProject = true
+	created, err := p.CreateInstances(nilLogger(), []string{"test-0001", "test-0002"}, opts, providerOpts)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"test-0001": "us-east1-b",
+		"test-0002": "us-west1-b",
+	}, created)
+
+	creates := make(map[string]string)
+	for _, c := range r.Commands() {
+		if strings.HasPrefix(c, "compute instances create") {
+			project := regexp.MustCompile(` --project (\S+)`).FindStringSubmatch(c)[1]
+			require.NotContains(t, creates, project)
+			creates[project] = c
+		}
+	}
+	require.Len(t, creates, 2)
+	require.True(t, strings.HasSuffix(creates["project-a"], "--zone us-east1-b test-0001"), creates["project-a"])
+	require.True(t, strings.HasSuffix(creates["project-b"], "--zone us-west1-b test-0002"), creates["project-b"])
+	// The disk labels are propagated within each project.
+	require.Contains(t, r.Commands(), "compute instances describe test-0001 --project project-a --zone us-east1-b --format json(disks)")
+	require.Contains(t, r.Commands(), "compute instances describe test-0002 --project project-b --zone us-west1-b --format json(disks)")
+}
+
+func TestCreateWeightedZones(t *testing.T) {
+	r := &fakeRunner{respond: createResponder("us-east1-b", "us-west1-b")}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	providerOpts := DefaultProviderOpts()
+	providerOpts.WeightedZones = []string{"us-east1-b:3", "us-west1-b:1"}
+	names := []string{"test-0001", "test-0002", "test-0003", "test-0004",
+		"test-0005", "test-0006", "test-0007", "test-0008"}
+	created, err := p.CreateInstances(nilLogger(), names, opts, providerOpts)
+	require.NoError(t, err)
+	perZone := make(map[string]int)
+	for _, zone := range created {
+		perZone[zone]++
+	}
+	require.Equal(t, map[string]int{"us-east1-b": 6, "us-west1-b": 2}, perZone)
+
+	// The weighted zones can't be combined with --gce-zones.
+	providerOpts.Zones = []string{"us-east1-b"}
+	_, err = p.CreateInstances(nilLogger(), names, opts, providerOpts)
+	require.ErrorContains(t, err, "cannot be combined")
+}
+
+func TestCreateMIG(t *testing.T) {
+	respond := createResponder("us-east1-b", "us-east1-c")
+	migInstances := []map[string]string{
+		{"instance": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-c/instances/test-0002"},
+		{"instance": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/instances/test-0003"},
+		{"instance": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/instances/test-0001"},
+	}
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		if len(args) > 4 && args[1] == "instance-groups" && args[3] == "list-instances" {
+			return json.Marshal(migInstances)
+		}
+		return respond(args)
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	providerOpts := DefaultProviderOpts()
+	providerOpts.Zones = []string{"us-east1-b", "us-east1-c"}
+	providerOpts.UseMIG = true
+	names := []string{"test-0001", "test-0002", "test-0003"}
+	created, err := p.CreateInstances(nilLogger(), names, opts, providerOpts)
+	require.NoError(t, err)
+	// The instances keep the requested names, in the zones the MIG picked.
+	require.Equal(t, map[string]string{
+		"test-0001": "us-east1-b",
+		"test-0002": "us-east1-c",
+		"test-0003": "us-east1-b",
+	}, created)
+
+	var migCommands []string
+	for _, c := range r.Commands() {
+		require.False(t, strings.HasPrefix(c, "compute instances create"), c)
+		if strings.HasPrefix(c, "compute instance-templates") || strings.HasPrefix(c, "compute instance-groups") {
+			migCommands = append(migCommands, c)
+		}
+	}
+	require.Len(t, migCommands, 7)
+	require.True(t, strings.HasPrefix(migCommands[0], "compute instance-templates create test-template "), migCommands[0])
+	require.Contains(t, migCommands[0], "--machine-type "+providerOpts.MachineType)
+	require.NotContains(t, migCommands[0], "--zone")
+	// The instances are labeled with the MIG, so that Delete can find it.
+	require.Regexp(t, `--labels \S*roachprod-mig=test`, migCommands[0])
+	require.Equal(t, []string{
+		"compute instance-groups managed create test --project test-project --region us-east1 " +
+			"--template test-template --zones us-east1-b,us-east1-c --size 0",
+		"compute instance-groups managed create-instance test --project test-project --region us-east1 --instance test-0001",
+		"compute instance-groups managed create-instance test --project test-project --region us-east1 --instance test-0002",
+		"compute instance-groups managed create-instance test --project test-project --region us-east1 --instance test-0003",
+		"compute instance-groups managed wait-until test --project test-project --region us-east1 --stable",
+		"compute instance-groups managed list-instances test --project test-project --region us-east1 " +
+			"--format json(instance)",
+	}, migCommands[1:])
+	// The disk labels are propagated to the MIG's instances.
+	require.Contains(t, r.Commands(), "compute instances describe test-0002 --project test-project --zone us-east1-c --format json(disks)")
+
+	// The dry run prints the same commands.
+	l, logged := fileLogger(t)
+	providerOpts.DryRun = true
+	_, err = p.CreateInstances(l, names, opts, providerOpts)
+	require.NoError(t, err)
+	for _, c := range migCommands[1:5] {
+		require.Contains(t, logged(), "Dry run: gcloud "+c)
+	}
+	providerOpts.DryRun = false
+
+	// An instance the MIG has which wasn't requested is an error.
+	migInstances[0]["instance"] = "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-c/instances/test-x9z2"
+	_, err = p.CreateInstances(nilLogger(), names, opts, providerOpts)
+	require.ErrorContains(t, err, "unexpected instance test-x9z2 in MIG test")
+
+	// The zones of a regional MIG must be in a single region.
+	providerOpts.Zones = []string{"us-east1-b", "us-west1-b"}
+	_, err = p.CreateInstances(nilLogger(), names, opts, providerOpts)
+	require.ErrorContains(t, err, "must be in a single region")
+}
+
+func TestCreateSkipExisting(t *testing.T) {
+	existing := []map[string]string{{
+		"name":        "test-0002",
+		"zone":        "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-west1-b",
+		"machineType": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-west1-b/machineTypes/n2-standard-4",
+	}}
+	respond := createResponder("us-east1-b", "us-west1-b")
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		if isListCommand(args, "instances") {
+			return json.Marshal(existing)
+		}
+		return respond(args)
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	providerOpts := DefaultProviderOpts()
+	providerOpts.MachineType = "n2-standard-4"
+	providerOpts.Zones = []string{"us-east1-b", "us-west1-b"}
+	providerOpts.SkipExisting = true
+	l, logged := fileLogger(t)
+	names := []string{"test-0001", "test-0002", "test-0003"}
+	created, err := p.CreateInstances(l, names, opts, providerOpts)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"test-0001": "us-east1-b",
+		"test-0003": "us-east1-b",
+	}, created)
+	require.Contains(t, logged(), "Skipping 1 existing instances: test-0002")
+
+	var creates []string
+	for _, c := range r.Commands() {
+		if strings.HasPrefix(c, "compute instances create") {
+			creates = append(creates, c)
+		}
+	}
+	require.Len(t, creates, 1)
+	require.True(t, strings.HasSuffix(creates[0], "--zone us-east1-b test-0001 test-0003"), creates[0])
+	require.Contains(t, r.Commands(), "compute instances list --project test-project "+
+		"--filter name=(test-0001 test-0002 test-0003) --format json(name,zone,machineType)")
+
+	// An existing instance which doesn't match the requested configuration is
+	// an error.
+	existing[0]["machineType"] = "n2-standard-8"
+	_, err = p.CreateInstances(nilLogger(), names, opts, providerOpts)
+	require.ErrorContains(t, err, "existing instance test-0002 is of machine type n2-standard-8 rather than n2-standard-4")
+	existing[0]["machineType"] = "n2-standard-4"
+	existing[0]["zone"] = "us-east1-b"
+	_, err = p.CreateInstances(nilLogger(), names, opts, providerOpts)
+	require.ErrorContains(t, err, "existing instance test-0002 is in zone us-east1-b rather than us-west1-b")
+}
+
+func TestCreateValidatesZones(t *testing.T) {
+	r := &fakeRunner{respond: createResponder("us-east1-b", "us-east1-c")}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	providerOpts := DefaultProviderOpts()
+	providerOpts.Zones = []string{"us-east1-b", "us-east1-z"}
+	err := p.Create(nilLogger(), []string{"test-0001", "test-0002"}, opts, providerOpts)
+	require.ErrorContains(t, err, "unknown zones in project test-project: us-east1-z")
+	// No instance was created.
+	require.Equal(t, []string{
+		"compute zones list --project test-project --format json(name)",
+	}, r.Commands())
+
+	// The zones are cached.
+	providerOpts.Zones = []string{"us-east1-c"}
+	require.NoError(t, p.Create(nilLogger(), []string{"test-0001"}, opts, providerOpts))
+	for _, c := range r.Commands()[1:] {
+		require.NotContains(t, c, "zones list")
+	}
+}
+
+func TestCreateChecksMachineTypeAvailability(t *testing.T) {
+	zones := []string{"us-central1-a", "us-east1-b"}
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		if isListCommand(args, "machine-types") {
+			// The machine type is only offered in the first zone.
+			return machineTypesResponse(args, zones[:1])
+		}
+		return createResponder(zones...)(args)
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	opts.SSDOpts.UseLocalSSD = false
+	providerOpts := DefaultProviderOpts()
+	providerOpts.MachineType = "c3-standard-4"
+	providerOpts.Zones = zones
+	err := p.Create(nilLogger(), []string{"test-0001", "test-0002"}, opts, providerOpts)
+	require.ErrorContains(t, err, "machine type c3-standard-4 is not available in zones: us-east1-b")
+	for _, c := range r.Commands() {
+		require.NotContains(t, c, "instances create")
+	}
+}
+
+func TestZonesForMachineType(t *testing.T) {
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		if isListCommand(args, "machine-types") {
+			// The machine type is only offered in two zones, which gcloud lists
+			// as URLs and in no particular order.
+			return machineTypesResponse(args, []string{
+				"https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b",
+				"us-central1-a",
+			})
+		}
+		return nil, nil
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	zones, err := p.ZonesForMachineType(nilLogger(), "n2-standard-16")
+	require.NoError(t, err)
+	require.Equal(t, []string{"us-central1-a", "us-east1-b"}, zones)
+	require.Len(t, r.Commands(), 1)
+	require.Contains(t, r.Commands()[0], "--filter name=n2-standard-16")
+	require.Contains(t, r.Commands()[0], "--project test-project")
+
+	zones, err = p.ZonesForLocalSSDs(nilLogger(), "n2-standard-16", 4)
+	require.NoError(t, err)
+	require.Equal(t, []string{"us-central1-a", "us-east1-b"}, zones)
+
+	// n2-standard-16 requires at least two local SSDs.
+	_, err = p.ZonesForLocalSSDs(nilLogger(), "n2-standard-16", 1)
+	require.ErrorContains(t, err, "machine type n2-standard-16 does not support 1 local SSDs")
+	require.Len(t, r.Commands(), 2)
+}
+
+func TestResizeVM(t *testing.T) {
+	newVM := func() *vm.VM {
+		return &vm.VM{
+			Name:        "test-0001",
+			Provider:    ProviderName,
+			Project:     "test-project",
+			Zone:        "us-east1-b",
+			MachineType: "n2-standard-4",
+			LocalDisks:  []vm.Volume{{Name: "local-ssd-0"}},
+		}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		r := &fakeRunner{}
+		withFakeRunner(t, r)
+		v := newVM()
+		require.NoError(t, (&Provider{}).ResizeVM(nilLogger(), v, "n2-standard-8"))
+		require.Equal(t, []string{
+			"compute instances stop test-0001 --project test-project --zone us-east1-b",
+			"compute instances set-machine-type test-0001 --machine-type n2-standard-8 --project test-project --zone us-east1-b",
+			"compute instances start test-0001 --project test-project --zone us-east1-b",
+		}, r.Commands())
+		require.Equal(t, "n2-standard-8", v.MachineType)
+	})
+
+	for _, tc := range []struct {
+		machineType string
+		expectedErr string
+	}{
+		{"n2-standard-4", "already has machine type"},
+		{"t2a-standard-4", "the architectures differ"},
+		// n2-standard-16 requires at least 2 local SSDs.
+		{"n2-standard-16", "1 local SSDs are attached"},
+		{"e2-standard-4", "unsupported machine type"},
+	} {
+		t.Run(tc.machineType, func(t *testing.T) {
+			r := &fakeRunner{}
+			withFakeRunner(t, r)
+			v := newVM()
+			require.ErrorContains(t, (&Provider{}).ResizeVM(nilLogger(), v, tc.machineType), tc.expectedErr)
+			require.Empty(t, r.Commands())
+			require.Equal(t, "n2-standard-4", v.MachineType)
+		})
+	}
+}
+
+func TestProvisioningModel(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		scheduling string
+		expected   string
+	}{
+		{"standard", `{"onHostMaintenance": "MIGRATE", "provisioningModel": "STANDARD"}`, ProvisioningModelStandard},
+		{"preemptible", `{"onHostMaintenance": "TERMINATE", "preemptible": true}`, ProvisioningModelPreemptible},
+		{"spot", `{"onHostMaintenance": "TERMINATE", "preemptible": true, "provisioningModel": "SPOT"}`, ProvisioningModelSpot},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fixture := fmt.Sprintf(`{
+  "name": "test-cluster-0001",
+  "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b",
+  "labels": {"lifetime": "12h0m0s"},
+  "scheduling": %s
+}`, tc.scheduling)
+			var v jsonVM
+			require.NoError(t, json.Unmarshal([]byte(fixture), &v))
+			parsed := v.toVM("test-project", nil /* disks */, DefaultProviderOpts())
+			require.Equal(t, tc.expected, parsed.ProvisioningModel)
+			require.Equal(t, tc.expected != ProvisioningModelStandard, parsed.Preemptible)
+		})
+	}
+}
+
+func TestInstanceTerminationAction(t *testing.T) {
+	const fixture = `{
+  "name": "test-cluster-0001",
+  "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b",
+  "labels": {"lifetime": "12h0m0s"},
+  "scheduling": {
+    "onHostMaintenance": "TERMINATE",
+    "preemptible": true,
+    "provisioningModel": "SPOT",
+    "instanceTerminationAction": "DELETE"
+  }
+}`
+	var v jsonVM
+	require.NoError(t, json.Unmarshal([]byte(fixture), &v))
+	parsed := v.toVM("test-project", nil /* disks */, DefaultProviderOpts())
+	require.Equal(t, ProvisioningModelSpot, parsed.ProvisioningModel)
+	require.Equal(t, "DELETE", parsed.InstanceTerminationAction)
+
+	v = jsonVM{}
+	require.NoError(t, json.Unmarshal([]byte(`{"name": "test-cluster-0002", "scheduling": {"onHostMaintenance": "MIGRATE"}}`), &v))
+	require.Empty(t, v.toVM("test-project", nil /* disks */, DefaultProviderOpts()).InstanceTerminationAction)
+}
+
+func TestExtendConcurrently(t *testing.T) {
+	const numVMs = 10
+	var vms vm.List
+	for i := 1; i <= numVMs; i++ {
+		vms = append(vms, vm.VM{Name: vm.Name("test", i), Zone: "us-east1-b"})
+	}
+
+	// Each command blocks until all of them are running, which only succeeds
+	// if the commands are issued concurrently.
+	var wg sync.WaitGroup
+	wg.Add(numVMs)
+	allRunning := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(allRunning)
+	}()
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		wg.Done()
+		select {
+		case <-allRunning:
+			return nil, nil
+		case <-time.After(30 * time.Second):
+			return nil, errors.New("timed out waiting for concurrent commands")
+		}
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	require.NoError(t, p.Extend(nilLogger(), vms, 24*time.Hour))
+	commands := r.Commands()
+	require.Len(t, commands, numVMs)
+	for _, c := range commands {
+		require.Contains(t, c, "compute instances add-labels test-")
+		require.Contains(t, c, "--labels=lifetime=24h0m0s")
+	}
+}
+
+func TestListOnlyRoachprodManaged(t *testing.T) {
+	const fixture = `[
+  {
+    "name": "test-cluster-0001",
+    "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b",
+    "labels": {"cluster": "test-cluster", "created": "2024-01-01t00_00_00z", "lifetime": "12h0m0s", "roachprod": "true"}
+  },
+  {
+    "name": "not-roachprod",
+    "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b",
+    "labels": {"lifetime": "12h0m0s"}
+  },
+  {
+    "name": "no-labels",
+    "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b"
+  }
+]`
+	withFakeRunner(t, &fakeRunner{respond: func(args []string) ([]byte, error) {
+		return []byte(fixture), nil
+	}})
+	p := &Provider{Projects: []string{"test-project"}}
+
+	vms, err := p.List(nilLogger(), vm.ListOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"test-cluster-0001", "not-roachprod", "no-labels"}, vms.Names())
+
+	vms, err = p.List(nilLogger(), vm.ListOptions{OnlyRoachprodManaged: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{"test-cluster-0001"}, vms.Names())
+}
+
+func TestCreateVolumes(t *testing.T) {
+	const numVolumes = 3
+	var specs []vm.VolumeCreateOpts
+	for i := 1; i <= numVolumes; i++ {
+		specs = append(specs, vm.VolumeCreateOpts{
+			Name:   fmt.Sprintf("test-disk-%d", i),
+			Size:   10,
+			Zone:   "us-east1-b",
+			Labels: map[string]string{"index": strconv.Itoa(i)},
+			// Keep the add-labels commands deterministic.
+			SkipDefaultLabels: true,
+		})
+	}
+
+	// Each creation blocks until all of them are running, which only succeeds
+	// if the volumes are created concurrently.
+	var wg sync.WaitGroup
+	wg.Add(numVolumes)
+	allRunning := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(allRunning)
+	}()
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		if args[4] != "create" {
+			return nil, nil
+		}
+		wg.Done()
+		select {
+		case <-allRunning:
+		case <-time.After(30 * time.Second):
+			return nil, errors.New("timed out waiting for concurrent creations")
+		}
+		return json.Marshal([]map[string]string{{
+			"name":   args[5],
+			"sizeGb": "10",
+			"zone":   "us-east1-b",
+		}})
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	volumes, err := p.CreateVolumes(nilLogger(), specs)
+	require.NoError(t, err)
+	require.Len(t, volumes, numVolumes)
+	for i, v := range volumes {
+		require.Equal(t, specs[i].Name, v.Name)
+	}
+	for i := 1; i <= numVolumes; i++ {
+		require.Contains(t, r.Commands(), fmt.Sprintf(
+			"compute --project test-project disks add-labels test-disk-%d --labels index=%d --zone us-east1-b", i, i,
+		))
+	}
+}
+
+// diskLabels returns the labels added to the given disk by the commands, as
+// CreateVolume does.
+func diskLabels(commands []string, disk string) map[string]string {
+	labels := make(map[string]string)
+	for _, c := range commands {
+		args := strings.Split(c, " ")
+		if !strings.Contains(c, "disks add-labels "+disk+" ") {
+			continue
+		}
+		for _, pair := range strings.Split(argValue(args, "--labels"), ",") {
+			key, value, _ := strings.Cut(pair, "=")
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+func TestCreateVolumeDefaultLabels(t *testing.T) {
+	newRunner := func() *fakeRunner {
+		return &fakeRunner{respond: func(args []string) ([]byte, error) {
+			if args[4] != "create" {
+				return nil, nil
+			}
+			return []byte(`[{"name": "test-disk", "sizeGb": "10", "zone": "us-east1-b"}]`), nil
+		}}
+	}
+	opts := vm.VolumeCreateOpts{Name: "test-disk", Size: 10, Zone: "us-east1-b"}
+	p := &Provider{Projects: []string{"test-project"}}
+
+	t.Run("default", func(t *testing.T) {
+		r := newRunner()
+		withFakeRunner(t, r)
+		_, err := p.CreateVolume(nilLogger(), opts)
+		require.NoError(t, err)
+
+		labels := diskLabels(r.Commands(), "test-disk")
+		require.Regexp(t, `^\d{4}-\d{2}-\d{2}t\d{2}_\d{2}_\d{2}`, labels[vm.TagCreated])
+		require.Equal(t, serializeLabel(config.OSUser.Username), labels[vm.TagCreatedBy])
+	})
+
+	t.Run("caller labels take precedence", func(t *testing.T) {
+		r := newRunner()
+		withFakeRunner(t, r)
+		opts := opts
+		opts.Labels = map[string]string{vm.TagCreatedBy: "someone-else", "usage": "roachtest"}
+		_, err := p.CreateVolume(nilLogger(), opts)
+		require.NoError(t, err)
+
+		labels := diskLabels(r.Commands(), "test-disk")
+		require.Contains(t, labels, vm.TagCreated)
+		require.Equal(t, "someone-else", labels[vm.TagCreatedBy])
+		require.Equal(t, "roachtest", labels["usage"])
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		r := newRunner()
+		withFakeRunner(t, r)
+		opts := opts
+		opts.SkipDefaultLabels = true
+		_, err := p.CreateVolume(nilLogger(), opts)
+		require.NoError(t, err)
+		for _, c := range r.Commands() {
+			require.NotContains(t, c, "add-labels")
+		}
+	})
+}
+
+func TestListWarnsOnVMErrors(t *testing.T) {
+	const fixture = `[
+  {
+    "name": "test-cluster-0001",
+    "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b",
+    "labels": {"lifetime": "12h0m0s"},
+    "scheduling": {"onHostMaintenance": "MIGRATE"}
+  }
+]`
+	withFakeRunner(t, &fakeRunner{respond: func(args []string) ([]byte, error) {
+		return []byte(fixture), nil
+	}})
+	l, logged := fileLogger(t)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	vms, err := p.List(l, vm.ListOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"test-cluster-0001"}, vms.Names())
+	require.Equal(t, []error{vm.ErrBadNetwork}, vms[0].Errors)
+	require.Contains(t, logged(),
+		"WARNING: VM test-cluster-0001 in project test-project has errors: "+vm.ErrBadNetwork.Error())
+}
+
+func TestCreateImageProject(t *testing.T) {
+	for _, tc := range []struct {
+		name                 string
+		arch                 string
+		imageProject         string
+		expectedImageProject string
+	}{
+		{"default", "", "", defaultImageProject},
+		{"custom", "", "my-project", "my-project"},
+		{"fips wins", string(vm.ArchFIPS), "my-project", FIPSImageProject},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withFakeRunner(t, &fakeRunner{})
+			l, logged := fileLogger(t)
+
+			p := &Provider{Projects: []string{"test-project"}}
+			opts := vm.DefaultCreateOpts()
+			opts.ClusterName = "test"
+			opts.Arch = tc.arch
+			providerOpts := DefaultProviderOpts()
+			providerOpts.Image = "my-image"
+			providerOpts.ImageProject = tc.imageProject
+			providerOpts.DryRun = true
+			require.NoError(t, p.Create(l, []string{"test-0001"}, opts, providerOpts))
+			require.Contains(t, logged(), " --image-project "+tc.expectedImageProject+" ")
+		})
+	}
+}
+
+func TestGetProjectQuota(t *testing.T) {
+	const fixture = `{
+  "name": "us-east1",
+  "quotas": [
+    {"limit": 2400.0, "metric": "CPUS", "usage": 968.0},
+    {"limit": 5000.0, "metric": "DISKS_TOTAL_GB", "usage": 1500.0},
+    {"limit": 8.0, "metric": "STATIC_ADDRESSES", "usage": 0.0},
+    {"limit": 100000.0, "metric": "SSD_TOTAL_GB", "usage": 48000.0},
+    {"limit": 1200.0, "metric": "N2_CPUS", "usage": 800.0},
+    {"limit": 6000.0, "metric": "INSTANCES", "usage": 242.0}
+  ]
+}`
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		return []byte(fixture), nil
+	}}
+	withFakeRunner(t, r)
+
+	quotas, err := (&Provider{}).GetProjectQuota(nilLogger(), "test-project", "us-east1")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"compute regions describe us-east1 --project test-project --format json",
+	}, r.Commands())
+	require.Equal(t, vm.Quota{Limit: 2400, Usage: 968}, quotas["CPUS"])
+	require.Equal(t, float64(1432), quotas["CPUS"].Remaining())
+	require.Equal(t, vm.Quota{Limit: 100000, Usage: 48000}, quotas["SSD_TOTAL_GB"])
+	require.Equal(t, vm.Quota{Limit: 1200, Usage: 800}, quotas["N2_CPUS"])
+	require.Equal(t, vm.Quota{Limit: 6000, Usage: 242}, quotas["INSTANCES"])
+	require.NotContains(t, quotas, "STATIC_ADDRESSES")
+}
+
+func TestCreatePreemptibleMaintenancePolicy(t *testing.T) {
+	for _, terminateOnMigration := range []bool{false, true} {
+		t.Run(fmt.Sprintf("terminateOnMigration=%t", terminateOnMigration), func(t *testing.T) {
+			withFakeRunner(t, &fakeRunner{})
+			l, logged := fileLogger(t)
+
+			p := &Provider{Projects: []string{"test-project"}}
+			opts := vm.DefaultCreateOpts()
+			opts.ClusterName = "test"
+			providerOpts := DefaultProviderOpts()
+			providerOpts.preemptible = true
+			providerOpts.TerminateOnMigration = terminateOnMigration
+			providerOpts.DryRun = true
+			require.NoError(t, p.Create(l, []string{"test-0001"}, opts, providerOpts))
+			require.True(t, providerOpts.TerminateOnMigration)
+			out := logged()
+			require.Contains(t, out, " --preemptible --maintenance-policy TERMINATE ")
+			if terminateOnMigration {
+				require.NotContains(t, out, "WARNING: preemptible instances require")
+			} else {
+				require.Contains(t, out, "WARNING: preemptible instances require 'TERMINATE' maintenance policy")
+			}
+		})
+	}
+}
+
+func TestCreateMaintenancePolicy(t *testing.T) {
+	withFakeRunner(t, &fakeRunner{})
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+
+	for _, tc := range []struct {
+		name     string
+		args     []string
+		expected string
+		err      string
+	}{
+		{"default", nil, "MIGRATE", ""},
+		{"terminateOnMigration", []string{"--gce-terminateOnMigration"}, "TERMINATE", ""},
+		{"explicit", []string{"--gce-maintenance-policy=terminate"}, "TERMINATE", ""},
+		{"explicit wins", []string{"--gce-terminateOnMigration", "--gce-maintenance-policy=MIGRATE"}, "MIGRATE", ""},
+		{"invalid", []string{"--gce-maintenance-policy=RESTART"}, "", `unknown maintenance policy "RESTART"`},
+		{"preemptible", []string{"--gce-preemptible", "--gce-maintenance-policy=MIGRATE"}, "",
+			"preemptible instances require 'TERMINATE' maintenance policy, got MIGRATE"},
+		{"spot", []string{"--gce-use-spot", "--gce-maintenance-policy=migrate"}, "",
+			"spot instances require 'TERMINATE' maintenance policy, got MIGRATE"},
+		{"spot terminate", []string{"--gce-use-spot", "--gce-maintenance-policy=TERMINATE"}, "TERMINATE", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l, logged := fileLogger(t)
+			providerOpts := DefaultProviderOpts()
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			providerOpts.ConfigureCreateFlags(flags)
+			require.NoError(t, flags.Parse(tc.args))
+			providerOpts.DryRun = true
+
+			err := p.Create(l, []string{"test-0001"}, opts, providerOpts)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			out := logged()
+			require.Contains(t, out, " --maintenance-policy "+tc.expected+" ")
+			require.Equal(t, 1, strings.Count(out, "--maintenance-policy"))
+		})
+	}
+}
+
+func TestAttachVolumeWithDeviceName(t *testing.T) {
+	for _, tc := range []struct {
+		deviceName         string
+		expectedDeviceName string
+	}{
+		{"", "test-disk"},
+		{"data1", "data1"},
+	} {
+		t.Run(tc.expectedDeviceName, func(t *testing.T) {
+			disksJSON := fmt.Sprintf(`[{"disks": [{
+  "source": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/disks/test-disk",
+  "deviceName": %q,
+  "autoDelete": true
+}]}]`, tc.expectedDeviceName)
+			r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+				return []byte(disksJSON), nil
+			}}
+			withFakeRunner(t, r)
+
+			p := &Provider{Projects: []string{"test-project"}}
+			volume := vm.Volume{ProviderResourceID: "test-disk", Zone: "us-east1-b"}
+			target := &vm.VM{Name: "test-vm", ProviderID: "test-vm", Zone: "us-east1-b"}
+			path, err := p.AttachVolumeWithDeviceName(nilLogger(), volume, tc.deviceName, target)
+			require.NoError(t, err)
+			require.Equal(t, "/dev/disk/by-id/google-"+tc.expectedDeviceName, path)
+			require.Equal(t, []string{
+				"compute --project test-project instances attach-disk test-vm --disk test-disk --device-name " +
+					tc.expectedDeviceName + " --zone us-east1-b --format=json(disks)",
+				"compute --project test-project instances set-disk-auto-delete test-vm --auto-delete --device-name " +
+					tc.expectedDeviceName + " --zone us-east1-b --format=json(disks)",
+			}, r.Commands())
+		})
+	}
+}
+
+func TestAttachVolumeKeepOnTerminate(t *testing.T) {
+	autoDelete := false
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		return []byte(fmt.Sprintf(`[{"disks": [{
+  "source": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/disks/test-disk",
+  "deviceName": "test-disk",
+  "autoDelete": %t
+}]}]`, autoDelete)), nil
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	volume := vm.Volume{ProviderResourceID: "test-disk", Zone: "us-east1-b"}
+	target := &vm.VM{Name: "test-vm", ProviderID: "test-vm", Zone: "us-east1-b"}
+	path, err := p.AttachVolumeWithOpts(nilLogger(), volume, AttachVolumeOpts{KeepOnTerminate: true}, target)
+	require.NoError(t, err)
+	require.Equal(t, "/dev/disk/by-id/google-test-disk", path)
+	require.Equal(t, []string{
+		"compute --project test-project instances attach-disk test-vm --disk test-disk --device-name test-disk " +
+			"--zone us-east1-b --format=json(disks)",
+		"compute --project test-project instances set-disk-auto-delete test-vm --no-auto-delete " +
+			"--device-name test-disk --zone us-east1-b --format=json(disks)",
+	}, r.Commands())
+
+	// The disk still being set to auto-delete is an error.
+	autoDelete = true
+	_, err = p.AttachVolumeWithOpts(nilLogger(), volume, AttachVolumeOpts{KeepOnTerminate: true}, target)
+	require.ErrorContains(t, err, "Could not set disk 'test-disk' not to auto-delete on instance termination")
+}
+
+func TestAttachVolumeNotListedYet(t *testing.T) {
+	defer func(opts retry.Options) { attachDiskRetryOpts = opts }(attachDiskRetryOpts)
+	attachDiskRetryOpts = retry.Options{
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		MaxRetries:     2,
+	}
+
+	const bootDisk = `{"source": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/disks/test-vm", "boot": true}`
+	const dataDisk = `{"source": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/disks/test-disk", "deviceName": "test-disk", "autoDelete": true}`
+	volume := vm.Volume{ProviderResourceID: "test-disk", Zone: "us-east1-b"}
+	target := &vm.VM{Name: "test-vm", ProviderID: "test-vm", Zone: "us-east1-b"}
+	p := &Provider{Projects: []string{"test-project"}}
+
+	// listedAfter returns a respond function for which the disk is missing from
+	// the attach output and the first describes of the instance, until the
+	// given describe.
+	listedAfter := func(describes int) func(args []string) ([]byte, error) {
+		var n int
+		return func(args []string) ([]byte, error) {
+			switch {
+			case args[4] == "attach-disk":
+				return []byte(`[{"disks": [` + bootDisk + `]}]`), nil
+			case isInstanceDescribe(args):
+				n++
+				if n < describes {
+					return []byte(`{"disks": [` + bootDisk + `]}`), nil
+				}
+				return []byte(`{"disks": [` + bootDisk + `, ` + dataDisk + `]}`), nil
+			}
+			return []byte(`[{"disks": [` + bootDisk + `, ` + dataDisk + `]}]`), nil
+		}
+	}
+
+	t.Run("listed on second describe", func(t *testing.T) {
+		r := &fakeRunner{respond: listedAfter(2)}
+		withFakeRunner(t, r)
+		path, err := p.AttachVolume(nilLogger(), volume, target)
+		require.NoError(t, err)
+		require.Equal(t, "/dev/disk/by-id/google-test-disk", path)
+		commands := r.Commands()
+		require.Len(t, commands, 4)
+		describe := "compute instances describe test-vm --project test-project --zone us-east1-b --format json(disks)"
+		require.Equal(t, []string{describe, describe}, commands[1:3])
+		// The auto-delete is still set and verified.
+		require.Contains(t, commands[3], "set-disk-auto-delete test-vm --auto-delete")
+	})
+
+	t.Run("never listed", func(t *testing.T) {
+		r := &fakeRunner{respond: listedAfter(100)}
+		withFakeRunner(t, r)
+		_, err := p.AttachVolume(nilLogger(), volume, target)
+		require.ErrorContains(t, err, "Could not find created disk 'test-disk' in list of disks for test-vm")
+		// The attach and the bounded describes.
+		require.Len(t, r.Commands(), 1+3)
+	})
+}
+
+func TestAttachVolumesToVMs(t *testing.T) {
+	const numVolumes = 3
+	var pairs []VolumeAttachment
+	for i := 1; i <= numVolumes; i++ {
+		pairs = append(pairs, VolumeAttachment{
+			Vol: vm.Volume{ProviderResourceID: fmt.Sprintf("test-disk-%d", i), Zone: "us-east1-b"},
+			VM: &vm.VM{
+				Name: fmt.Sprintf("test-%04d", i), ProviderID: fmt.Sprintf("test-%04d", i), Zone: "us-east1-b",
+			},
+		})
+	}
+
+	// Each attachment blocks until all of them are in flight, which only
+	// succeeds if the volumes are attached concurrently.
+	var wg sync.WaitGroup
+	wg.Add(numVolumes)
+	allAttaching := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(allAttaching)
+	}()
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		disk := argValue(args, "--device-name")
+		if args[4] == "attach-disk" {
+			wg.Done()
+			select {
+			case <-allAttaching:
+			case <-time.After(30 * time.Second):
+				return nil, errors.New("timed out waiting for concurrent attachments")
+			}
+		}
+		return []byte(fmt.Sprintf(`[{"disks": [{
+  "source": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/disks/%[1]s",
+  "deviceName": %[1]q,
+  "autoDelete": true
+}]}]`, disk)), nil
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	paths, err := p.AttachVolumesToVMs(nilLogger(), pairs)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"test-disk-1": "/dev/disk/by-id/google-test-disk-1",
+		"test-disk-2": "/dev/disk/by-id/google-test-disk-2",
+		"test-disk-3": "/dev/disk/by-id/google-test-disk-3",
+	}, paths)
+}
+
+func TestSupportsEncryptedVolumes(t *testing.T) {
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		return []byte(`[{"name": "test-disk", "sizeGb": "10", "zone": "us-east1-b"}]`), nil
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	_, err := p.CreateVolume(nilLogger(), vm.VolumeCreateOpts{
+		Name:      "test-disk",
+		Size:      10,
+		Zone:      "us-east1-b",
+		Encrypted: true,
+	})
+	require.Equal(t, p.SupportsEncryptedVolumes(), err == nil, "unexpected error: %v", err)
+	if !p.SupportsEncryptedVolumes() {
+		require.Empty(t, r.Commands())
+	}
+}
+
+func TestReloadEnv(t *testing.T) {
+	withFakeRunner(t, &fakeRunner{})
+	createArgs := func(t *testing.T, p *Provider) string {
+		l, logged := fileLogger(t)
+		opts := vm.DefaultCreateOpts()
+		opts.ClusterName = "test"
+		providerOpts := DefaultProviderOpts()
+		providerOpts.DryRun = true
+		require.NoError(t, p.Create(l, []string{"test-0001"}, opts, providerOpts))
+		return logged()
+	}
+
+	t.Run("env", func(t *testing.T) {
+		p := &Provider{}
+		t.Setenv("GCE_PROJECT", "project-a")
+		t.Setenv("GCE_SERVICE_ACCOUNT", "sa-a@project-a.iam.gserviceaccount.com")
+		p.ReloadEnv()
+		out := createArgs(t, p)
+		require.Contains(t, out, " --project project-a ")
+		require.Contains(t, out, " --service-account sa-a@project-a.iam.gserviceaccount.com ")
+
+		t.Setenv("GCE_PROJECT", "project-b")
+		t.Setenv("GCE_SERVICE_ACCOUNT", "sa-b@project-b.iam.gserviceaccount.com")
+		p.ReloadEnv()
+		out = createArgs(t, p)
+		require.Contains(t, out, " --project project-b ")
+		require.Contains(t, out, " --service-account sa-b@project-b.iam.gserviceaccount.com ")
+	})
+
+	t.Run("flags", func(t *testing.T) {
+		p := &Provider{}
+		t.Setenv("GCE_PROJECT", "project-a")
+		p.ReloadEnv()
+		// Emulate --gce-project and --gce-service-account.
+		p.Projects = []string{"project-flag"}
+		p.projectsFromFlag = true
+		p.ServiceAccount = "sa-flag@project-flag.iam.gserviceaccount.com"
+
+		t.Setenv("GCE_PROJECT", "project-b")
+		t.Setenv("GCE_SERVICE_ACCOUNT", "sa-b@project-b.iam.gserviceaccount.com")
+		p.ReloadEnv()
+		out := createArgs(t, p)
+		require.Contains(t, out, " --project project-flag ")
+		require.Contains(t, out, " --service-account sa-flag@project-flag.iam.gserviceaccount.com ")
+	})
+}
+
+func TestGcloudBinary(t *testing.T) {
+	t.Setenv(gcloudBinEnvVar, "")
+	cmd := gcloudCommand(context.Background(), "compute", "instances", "list")
+	require.Equal(t, []string{"gcloud", "compute", "instances", "list"}, cmd.Args)
+
+	custom := filepath.Join(t.TempDir(), "google-cloud-sdk", "bin", "gcloud")
+	t.Setenv(gcloudBinEnvVar, custom)
+	cmd = gcloudCommand(context.Background(), "compute", "instances", "list")
+	require.Equal(t, custom, cmd.Path)
+	require.Equal(t, []string{custom, "compute", "instances", "list"}, cmd.Args)
+
+	// Init falls back to a stub if the custom binary doesn't exist.
+	prev, ok := vm.Providers[ProviderName]
+	defer func() {
+		if ok {
+			vm.Providers[ProviderName] = prev
+		} else {
+			delete(vm.Providers, ProviderName)
+		}
+	}()
+	require.ErrorContains(t, Init(), "gcloud not found")
+}
+
+func TestCreateLabelsFlag(t *testing.T) {
+	withFakeRunner(t, &fakeRunner{})
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	opts.CustomLabels = map[string]string{"usage": "roachprod"}
+	providerOpts := DefaultProviderOpts()
+	providerOpts.DryRun = true
+
+	t.Run("merged", func(t *testing.T) {
+		l, logged := fileLogger(t)
+		providerOpts.Labels = map[string]string{"team": "storage", "Owner": "Jane.Doe"}
+		require.NoError(t, p.Create(l, []string{"test-0001"}, opts, providerOpts))
+		labelsArg := regexp.MustCompile(` --labels (\S+) `).FindStringSubmatch(logged())
+		require.NotNil(t, labelsArg)
+		labels := strings.Split(labelsArg[1], ",")
+		require.Contains(t, labels, "team=storage")
+		require.Contains(t, labels, "owner=jane_doe")
+		require.Contains(t, labels, "usage=roachprod")
+		require.Contains(t, labels, "cluster=test")
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		for _, key := range []string{"cluster", "Usage"} {
+			providerOpts.Labels = map[string]string{key: "x"}
+			err := p.Create(nilLogger(), []string{"test-0001"}, opts, providerOpts)
+			require.ErrorContains(t, err, "duplicate label name defined: "+strings.ToLower(key))
+		}
+	})
+}
+
+func TestCreateLabelsFromFile(t *testing.T) {
+	withFakeRunner(t, &fakeRunner{})
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	opts.CustomLabels = map[string]string{"usage": "roachprod"}
+
+	writeLabels := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "labels")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+	providerOpts := DefaultProviderOpts()
+	providerOpts.DryRun = true
+
+	t.Run("merged", func(t *testing.T) {
+		l, logged := fileLogger(t)
+		providerOpts.LabelsFromFile = writeLabels(t, "# Canonical labels.\nteam=Storage\n\ncost-center = 1234.5\n")
+		require.NoError(t, p.Create(l, []string{"test-0001"}, opts, providerOpts))
+		labelsArg := regexp.MustCompile(` --labels (\S+) `).FindStringSubmatch(logged())
+		require.NotNil(t, labelsArg)
+		labels := strings.Split(labelsArg[1], ",")
+		require.Contains(t, labels, "team=storage")
+		require.Contains(t, labels, "cost-center=1234_5")
+		require.Contains(t, labels, "usage=roachprod")
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		providerOpts.Labels = map[string]string{"owner": "x"}
+		defer func() { providerOpts.Labels = nil }()
+		for _, key := range []string{"cluster", "Usage", "Owner"} {
+			providerOpts.LabelsFromFile = writeLabels(t, key+"=x\n")
+			err := p.Create(nilLogger(), []string{"test-0001"}, opts, providerOpts)
+			require.ErrorContains(t, err, "duplicate label name defined: "+strings.ToLower(key))
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		providerOpts.LabelsFromFile = writeLabels(t, "team=storage\nmalformed\n")
+		err := p.Create(nilLogger(), []string{"test-0001"}, opts, providerOpts)
+		require.ErrorContains(t, err, `:2: expected key=value, got "malformed"`)
+	})
+}
+
+func TestRestoreSnapshotToVM(t *testing.T) {
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		switch {
+		case args[3] == "disks" && args[4] == "create":
+			return []byte(`[{"name": "test-0001-1", "sizeGb": "100", "zone": "us-east1-b"}]`), nil
+		case args[3] == "instances":
+			return []byte(`[{"disks": [{
+  "source": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/disks/test-0001-1",
+  "deviceName": "test-0001-1",
+  "autoDelete": true
+}]}]`), nil
+		}
+		return nil, nil
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	target := &vm.VM{
+		Name:       "test-0001",
+		ProviderID: "test-0001",
+		Zone:       "us-east1-b",
+		Lifetime:   12 * time.Hour,
+		Labels:     map[string]string{vm.TagCluster: "test"},
+	}
+	volume, device, err := p.RestoreSnapshotToVM(nilLogger(), "test-snapshot", target, 100, "pd-ssd")
+	require.NoError(t, err)
+	require.Equal(t, "test-0001-1", volume.ProviderResourceID)
+	require.Equal(t, 100, volume.Size)
+	require.Equal(t, "/dev/disk/by-id/google-test-0001-1", device)
+
+	commands := r.Commands()
+	require.Len(t, commands, 5)
+	require.Equal(t, "compute --project test-project disks describe test-0001-1 --zone us-east1-b "+
+		"--format json(name)", commands[0])
+	require.Equal(t, "compute --project test-project disks create test-0001-1 --size 100 --zone us-east1-b "+
+		"--format json --source-snapshot test-snapshot --type pd-ssd", commands[1])
+	require.Contains(t, commands[2], "compute --project test-project disks add-labels test-0001-1 --labels ")
+	for _, label := range []string{"cluster=test", "lifetime=12h0m0s", "roachprod=true", "created="} {
+		require.Contains(t, commands[2], label)
+	}
+	require.Contains(t, commands[3], "instances attach-disk test-0001 --disk test-0001-1 ")
+	require.Contains(t, commands[4], "instances set-disk-auto-delete test-0001 ")
+}
+
+func TestVolumeInterface(t *testing.T) {
+	const selfLink = "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/disks/test-cluster-0001-1"
+	fixture := fmt.Sprintf(`{
+  "name": "test-cluster-0001",
+  "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b",
+  "labels": {"lifetime": "12h0m0s"},
+  "disks": [
+    {"boot": true, "source": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/disks/test-cluster-0001", "interface": "SCSI"},
+    {"source": %q, "interface": "NVME"},
+    {"type": "SCRATCH", "diskSizeGb": "375", "interface": "NVME"}
+  ]
+}`, selfLink)
+	var v jsonVM
+	require.NoError(t, json.Unmarshal([]byte(fixture), &v))
+	disks := []describeVolumeCommandResponse{{
+		Name:     "test-cluster-0001-1",
+		SelfLink: selfLink,
+		SizeGB:   "500",
+		Type:     "pd-ssd",
+		Zone:     "us-east1-b",
+	}}
+	parsed := v.toVM("test-project", disks, DefaultProviderOpts())
+	require.Len(t, parsed.NonBootAttachedVolumes, 1)
+	require.Equal(t, "NVME", parsed.NonBootAttachedVolumes[0].Interface)
+	require.Len(t, parsed.LocalDisks, 1)
+	require.Equal(t, "NVME", parsed.LocalDisks[0].Interface)
+}
+
+func TestResolveMinCPUPlatform(t *testing.T) {
+	for _, tc := range []struct {
+		machineType    string
+		minCPUPlatform string
+		expected       string
+		expectedErr    string
+	}{
+		{"n2-standard-4", "Intel Ice Lake", "Intel Ice Lake", ""},
+		{"c3d-standard-8", "AMD Genoa", "AMD Genoa", ""},
+		{"c3d-standard-8", "Intel Ice Lake", "AMD Genoa", ""},
+		{"n2d-standard-4", "Intel Ice Lake", "AMD Milan", ""},
+		{"n2d-standard-4", "AMD Rome", "AMD Rome", ""},
+		{"n2d-standard-4", "AMD Genoa", "", `min CPU platform "AMD Genoa" is not supported by machine type n2d-standard-4`},
+		{"n2-standard-4", "AMD Milan", "", `min CPU platform "AMD Milan" is only supported by AMD machine types`},
+	} {
+		t.Run(tc.machineType+"/"+tc.minCPUPlatform, func(t *testing.T) {
+			actual, err := resolveMinCPUPlatform(nilLogger(), tc.machineType, tc.minCPUPlatform)
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestListActiveAccounts(t *testing.T) {
+	const fixture = `[
+  {"account": "alice@cockroachlabs.com", "status": "ACTIVE"},
+  {"account": "bob@example.com", "status": "ACTIVE"}
+]`
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		return []byte(fixture), nil
+	}}
+	withFakeRunner(t, r)
+
+	accounts, err := (&Provider{}).ListActiveAccounts(nilLogger())
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice", "bob"}, accounts)
+	require.Equal(t, []string{"auth list --format json --filter status~ACTIVE"}, r.Commands())
+}
+
+func TestFindActiveAccountDomains(t *testing.T) {
+	account := "alice@cockroachlabs.com"
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		return []byte(fmt.Sprintf(`[{"account": %q, "status": "ACTIVE"}]`, account)), nil
+	}}
+	withFakeRunner(t, r)
+	t.Setenv(allowedEmailDomainsEnvVar, "")
+
+	// By default, only config.EmailDomain is allowed.
+	username, err := (&Provider{}).FindActiveAccount(nilLogger())
+	require.NoError(t, err)
+	require.Equal(t, "alice", username)
+	account = "bob@example.com"
+	_, err = (&Provider{}).FindActiveAccount(nilLogger())
+	require.ErrorContains(t, err, `active account "bob@example.com" does not belong to domain @cockroachlabs.com`)
+
+	// An alternate domain can be allowed on the provider...
+	p := &Provider{AllowedEmailDomains: []string{"cockroachlabs.com", "example.com"}}
+	username, err = p.FindActiveAccount(nilLogger())
+	require.NoError(t, err)
+	require.Equal(t, "bob", username)
+
+	// ... or via the environment.
+	t.Setenv(allowedEmailDomainsEnvVar, "@cockroachlabs.com, example.com")
+	username, err = (&Provider{}).FindActiveAccount(nilLogger())
+	require.NoError(t, err)
+	require.Equal(t, "bob", username)
+
+	// Domains must match exactly.
+	account = "mallory@notexample.com"
+	_, err = (&Provider{}).FindActiveAccount(nilLogger())
+	require.ErrorContains(t, err, "does not belong to domain @cockroachlabs.com, example.com")
+}
+
+func TestCreateRestartOnFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		flag     []string
+		expected string
+		err      string
+	}{
+		{"unset", nil, "", ""},
+		{"implicit true", []string{"--gce-restart-on-failure"}, " --restart-on-failure ", ""},
+		{"true", []string{"--gce-restart-on-failure=true"}, " --restart-on-failure ", ""},
+		{"false", []string{"--gce-restart-on-failure=false"}, " --no-restart-on-failure ", ""},
+		{"spot false", []string{"--gce-use-spot", "--gce-restart-on-failure=false"},
+			" --no-restart-on-failure ", ""},
+		{"spot", []string{"--gce-use-spot", "--gce-restart-on-failure"}, "",
+			"spot instances cannot be restarted on failure"},
+		{"preemptible", []string{"--gce-preemptible", "--gce-restart-on-failure"}, "",
+			"preemptible instances cannot be restarted on failure"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &fakeRunner{}
+			withFakeRunner(t, r)
+			l, logged := fileLogger(t)
+
+			providerOpts := DefaultProviderOpts()
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			providerOpts.ConfigureCreateFlags(flags)
+			require.NoError(t, flags.Parse(tc.flag))
+			providerOpts.DryRun = true
+
+			p := &Provider{Projects: []string{"test-project"}}
+			opts := vm.DefaultCreateOpts()
+			opts.ClusterName = "test"
+			err := p.Create(l, []string{"test-0001"}, opts, providerOpts)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				require.Empty(t, r.Commands())
+				return
+			}
+			require.NoError(t, err)
+			out := logged()
+			if tc.expected == "" {
+				require.NotContains(t, out, "restart-on-failure")
+			} else {
+				require.Contains(t, out, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCreateExtraCreateArgs(t *testing.T) {
+	withFakeRunner(t, &fakeRunner{})
+	l, logged := fileLogger(t)
+
+	providerOpts := DefaultProviderOpts()
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	providerOpts.ConfigureCreateFlags(flags)
+	require.NoError(t, flags.Parse([]string{
+		"--gce-extra-create-args=--enable-nested-virtualization,--threads-per-core=1",
+	}))
+	providerOpts.DryRun = true
+
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	require.NoError(t, p.Create(l, []string{"test-0001"}, opts, providerOpts))
+	require.Regexp(t, `(?m)Dry run: gcloud compute instances create .* `+
+		`test-0001 --enable-nested-virtualization --threads-per-core=1$`, logged())
+}
+
+func TestGetLatestUbuntuImage(t *testing.T) {
+	// Images of each family, deliberately not sorted by creation time.
+	fixtures := map[string]string{
+		"ubuntu-2204-lts": `[
+  {"name": "ubuntu-2204-jammy-v20240101", "creationTimestamp": "2024-01-01T00:00:00.000-07:00"},
+  {"name": "ubuntu-2204-jammy-v20240301", "creationTimestamp": "2024-03-01T00:00:00.000-07:00"},
+  {"name": "ubuntu-2204-jammy-v20240201", "creationTimestamp": "2024-02-01T00:00:00.000-07:00"}
+]`,
+		"ubuntu-2204-lts-arm64": `[
+  {"name": "ubuntu-2204-jammy-arm64-v20240401", "creationTimestamp": "2024-04-01T00:00:00.000-07:00"},
+  {"name": "ubuntu-2204-jammy-arm64-v20240101", "creationTimestamp": "2024-01-01T00:00:00.000-07:00"}
+]`,
+		"ubuntu-pro-fips-2004-lts": `[
+  {"name": "ubuntu-pro-fips-2004-focal-v20240215", "creationTimestamp": "2024-02-15T00:00:00.000-07:00"}
+]`,
+	}
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		family := strings.TrimPrefix(argValue(args, "--filter"), "family=")
+		return []byte(fixtures[family]), nil
+	}}
+	withFakeRunner(t, r)
+
+	for _, tc := range []struct {
+		project  string
+		arch     string
+		expected string
+	}{
+		{defaultImageProject, "", "ubuntu-2204-jammy-v20240301"},
+		{defaultImageProject, string(vm.ArchAMD64), "ubuntu-2204-jammy-v20240301"},
+		{defaultImageProject, string(vm.ArchARM64), "ubuntu-2204-jammy-arm64-v20240401"},
+		{FIPSImageProject, string(vm.ArchFIPS), "ubuntu-pro-fips-2004-focal-v20240215"},
+	} {
+		t.Run(tc.arch, func(t *testing.T) {
+			image, err := getLatestUbuntuImage(tc.project, "" /* version */, tc.arch)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, image)
+		})
+	}
+	require.Contains(t, r.Commands(), "compute images list --project ubuntu-os-pro-cloud "+
+		"--filter family=ubuntu-pro-fips-2004-lts --sort-by ~creationTimestamp --limit 1 "+
+		"--format json(name,creationTimestamp)")
+}
+
+func TestCreateProgress(t *testing.T) {
+	zones := []string{"us-east1-b", "us-west1-b"}
+	withFakeRunner(t, &fakeRunner{respond: createResponder(zones...)})
+	l, logged := fileLogger(t)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	providerOpts := DefaultProviderOpts()
+	providerOpts.Zones = zones
+	providerOpts.SkipDiskLabels = true
+	require.NoError(t, p.Create(l, []string{"test-0001", "test-0002", "test-0003"}, opts, providerOpts))
+
+	out := logged()
+	require.Contains(t, out, "zone us-east1-b: 2/2 instances created")
+	require.Contains(t, out, "zone us-west1-b: 1/1 instances created")
+	require.Contains(t, out, "3/3 instances, 2/2 zones overall")
+	require.Contains(t, out, "Created 3 instances across 2 zones")
+}
+
+func TestCreateProgressRateLimited(t *testing.T) {
+	const numZones = 25
+	progress := newCreateProgress(nilLogger(), numZones, numZones)
+	require.Equal(t, 3, progress.logEvery)
+
+	l, logged := fileLogger(t)
+	progress.l = l
+	for i := 0; i < numZones; i++ {
+		progress.zoneDone(fmt.Sprintf("zone-%d", i), 1)
+	}
+	// One log every 3 zones, plus the last one.
+	require.Equal(t, numZones/3+1, strings.Count(logged(), "instances created"))
+}
+
+func TestCreateVolumeTargetVMZone(t *testing.T) {
+	target := &vm.VM{Name: "test-0001", Zone: "us-east1-b"}
+	for _, tc := range []struct {
+		name        string
+		zone        string
+		target      *vm.VM
+		expectedErr string
+	}{
+		{"inherited", "", target, ""},
+		{"matching", "us-east1-b", target, ""},
+		{"mismatch", "us-west1-a", target, "volume test-disk is in zone us-west1-a, but VM test-0001 is in zone us-east1-b"},
+		{"neither", "", nil, "Either a zone or a target VM must be specified"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+				return json.Marshal([]map[string]string{{
+					"name":   "test-disk",
+					"sizeGb": "10",
+					"zone":   argValue(args, "--zone"),
+				}})
+			}}
+			withFakeRunner(t, r)
+
+			p := &Provider{Projects: []string{"test-project"}}
+			volume, err := p.CreateVolume(nilLogger(), vm.VolumeCreateOpts{
+				Name:     "test-disk",
+				Size:     10,
+				Zone:     tc.zone,
+				TargetVM: tc.target,
+			})
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, err, tc.expectedErr)
+				require.Empty(t, r.Commands())
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "us-east1-b", volume.Zone)
+			for _, cmd := range r.Commands() {
+				require.Equal(t, "us-east1-b", argValue(strings.Split(cmd, " "), "--zone"))
+			}
+		})
+	}
+}
+
+func TestWaitForSSH(t *testing.T) {
+	defer func(opts retry.Options) { waitForSSHRetryOpts = opts }(waitForSSHRetryOpts)
+	waitForSSHRetryOpts = retry.Options{
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	}
+	defer func(fn func(context.Context, vm.VM) error) { checkSSH = fn }(checkSSH)
+
+	vms := vm.List{{Name: "test-0001"}, {Name: "test-0002"}}
+
+	t.Run("becomes reachable", func(t *testing.T) {
+		// test-0002 only becomes reachable on the third check.
+		var mu syncutil.Mutex
+		checks := make(map[string]int)
+		checkSSH = func(ctx context.Context, v vm.VM) error {
+			mu.Lock()
+			defer mu.Unlock()
+			checks[v.Name]++
+			if v.Name == "test-0002" && checks[v.Name] < 3 {
+				return errors.New("connection refused")
+			}
+			return nil
+		}
+		require.NoError(t, (&Provider{}).WaitForSSH(nilLogger(), vms, time.Minute))
+		// Reachable VMs aren't checked again.
+		require.Equal(t, map[string]int{"test-0001": 1, "test-0002": 3}, checks)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		checkSSH = func(ctx context.Context, v vm.VM) error {
+			if v.Name == "test-0002" {
+				return errors.New("connection refused")
+			}
+			return nil
+		}
+		err := (&Provider{}).WaitForSSH(nilLogger(), vms, 50*time.Millisecond)
+		require.ErrorContains(t, err, "unreachable VMs: test-0002")
+	})
+}
+
+func TestCreateVolumeInheritSnapshotLabels(t *testing.T) {
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		switch args[4] {
+		case "describe":
+			return []byte(`{"labels": {"cluster": "snapshot-cluster", "team": "storage"}}`), nil
+		case "create":
+			return []byte(`[{"name": "test-disk", "sizeGb": "10", "zone": "us-east1-b"}]`), nil
+		}
+		return nil, nil
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	_, err := p.CreateVolume(nilLogger(), vm.VolumeCreateOpts{
+		Name:                  "test-disk",
+		Size:                  10,
+		Zone:                  "us-east1-b",
+		SourceSnapshotID:      "test-snapshot",
+		InheritSnapshotLabels: true,
+		Labels:                map[string]string{"cluster": "test", "usage": "roachtest"},
+		SkipDefaultLabels:     true,
+	})
+	require.NoError(t, err)
+
+	commands := r.Commands()
+	require.Len(t, commands, 4)
+	require.Equal(t, "compute --project test-project snapshots describe test-snapshot --format json(labels)", commands[1])
+	labels := strings.Split(argValue(strings.Split(commands[3], " "), "--labels"), ",")
+	require.ElementsMatch(t, []string{"cluster=test", "team=storage", "usage=roachtest"}, labels)
+}
+
+func TestZoneToRegion(t *testing.T) {
+	for _, tc := range []struct {
+		zone     string
+		expected string
+	}{
+		{"us-east1-b", "us-east1"},
+		{"us-central1-a", "us-central1"},
+		{"northamerica-northeast1-c", "northamerica-northeast1"},
+		// Malformed zones.
+		{"", ""},
+		{"us-east1", ""},
+		{"us-east1-", ""},
+		{"us-east1-bb", ""},
+		{"https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b", ""},
+	} {
+		t.Run(tc.zone, func(t *testing.T) {
+			require.Equal(t, tc.expected, ZoneToRegion(tc.zone))
+		})
+	}
+}
+
+func TestAccelerators(t *testing.T) {
+	const fixture = `{
+  "name": "test-cluster-0001",
+  "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b",
+  "labels": {"lifetime": "12h0m0s"},
+  "guestAccelerators": [
+    {
+      "acceleratorCount": 2,
+      "acceleratorType": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/acceleratorTypes/nvidia-tesla-t4"
+    }
+  ]
+}`
+	var v jsonVM
+	require.NoError(t, json.Unmarshal([]byte(fixture), &v))
+	parsed := v.toVM("test-project", nil /* disks */, DefaultProviderOpts())
+	require.Equal(t, []vm.Accelerator{{Type: "nvidia-tesla-t4", Count: 2}}, parsed.Accelerators)
+
+	v = jsonVM{}
+	require.NoError(t, json.Unmarshal([]byte(`{"name": "test-cluster-0002"}`), &v))
+	require.Empty(t, v.toVM("test-project", nil /* disks */, DefaultProviderOpts()).Accelerators)
+}
+
+func TestVolumeSnapshotCreationTimestamp(t *testing.T) {
+	const snapshotJSON = `{
+  "creationTimestamp": "2024-03-01T10:00:00.000-08:00",
+  "diskSizeGb": "500",
+  "id": "1234",
+  "name": "test-snapshot"%s
+}`
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		switch {
+		case len(args) >= 5 && args[4] == "list":
+			return []byte("[" + fmt.Sprintf(snapshotJSON, `, "labels": {"cluster": "test"}`) + "]"), nil
+		case len(args) >= 5 && args[4] == "create":
+			return []byte(fmt.Sprintf(snapshotJSON, "")), nil
+		}
+		return nil, nil
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	created, err := time.Parse(time.RFC3339, "2024-03-01T18:00:00Z")
+	require.NoError(t, err)
+	expected := vm.VolumeSnapshot{
+		ID:                "1234",
+		Name:              "test-snapshot",
+		CreationTimestamp: created,
+		SizeGB:            500,
+		Labels:            map[string]string{"cluster": "test"},
+	}
+
+	snapshot, err := p.CreateVolumeSnapshot(nilLogger(),
+		vm.Volume{ProviderResourceID: "test-disk", Zone: "us-east1-b"},
+		vm.VolumeSnapshotCreateOpts{Name: "test-snapshot", Labels: map[string]string{"cluster": "test"}})
+	require.NoError(t, err)
+	require.True(t, created.Equal(snapshot.CreationTimestamp), snapshot.CreationTimestamp)
+	snapshot.CreationTimestamp = created
+	require.Equal(t, expected, snapshot)
+
+	snapshots, err := p.ListVolumeSnapshots(nilLogger(), vm.VolumeSnapshotListOpts{})
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	require.True(t, created.Equal(snapshots[0].CreationTimestamp), snapshots[0].CreationTimestamp)
+	snapshots[0].CreationTimestamp = created
+	require.Equal(t, expected, snapshots[0])
+}
+
+func TestDeleteWithSnapshots(t *testing.T) {
+	dataDisk := func(name string) vm.Volume {
+		return vm.Volume{Name: name, ProviderResourceID: name, Zone: "us-east1-b"}
+	}
+	vms := vm.List{
+		{
+			Name: "test-0001", Provider: ProviderName, Project: "test-project", Zone: "us-east1-b",
+			Labels:                 map[string]string{vm.TagCluster: "test"},
+			NonBootAttachedVolumes: []vm.Volume{dataDisk("test-0001-1"), dataDisk("test-0001-2")},
+		},
+		{
+			// N.B. the VMs of a cluster may be spread across projects, see
+			// ProviderOpts.MultiProject.
+			Name: "test-0002", Provider: ProviderName, Project: "other-project", Zone: "us-east1-b",
+			Labels:                 map[string]string{vm.TagCluster: "test"},
+			NonBootAttachedVolumes: []vm.Volume{dataDisk("test-0002-1")},
+		},
+	}
+
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		if len(args) > 5 && args[3] == "snapshots" && args[4] == "create" {
+			return json.Marshal(map[string]string{"id": "id-" + args[5], "name": args[5]})
+		}
+		return nil, nil
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project", "other-project"}}
+
+	// VMs of other providers are rejected before any snapshot is created.
+	_, err := p.DeleteWithSnapshots(nilLogger(), append(vms[:2:2], vm.VM{Name: "aws-0001", Provider: "aws"}))
+	require.ErrorContains(t, err, "gce received VM instance from aws")
+	require.Empty(t, r.Commands())
+
+	ids, err := p.DeleteWithSnapshots(nilLogger(), vms)
+	require.NoError(t, err)
+	require.Len(t, ids, 3)
+
+	commands := r.Commands()
+	deleteIdx := -1
+	var snapshotted []string
+	for i, c := range commands {
+		args := strings.Split(c, " ")
+		switch {
+		case strings.HasPrefix(c, "compute instances delete"):
+			deleteIdx = i
+		case strings.Contains(c, "snapshots create"):
+			require.Equal(t, -1, deleteIdx, "snapshot created after delete: %s", c)
+			disk := argValue(args, "--source-disk")
+			snapshotted = append(snapshotted, disk)
+			// The snapshot is created in the project of the VM.
+			expectedProject := "test-project"
+			if strings.HasPrefix(disk, "test-0002") {
+				expectedProject = "other-project"
+			}
+			require.Equal(t, expectedProject, argValue(args, "--project"), c)
+		case strings.Contains(c, "snapshots add-labels"):
+			require.Equal(t, -1, deleteIdx, "snapshot labeled after delete: %s", c)
+			require.Contains(t, argValue(args, "--labels"), "cluster=test")
+			if strings.Contains(c, "test-0002") {
+				require.Equal(t, "other-project", argValue(args, "--project"), c)
+			}
+		}
+	}
+	require.NotEqual(t, -1, deleteIdx)
+	// The boot disks, named after the instances, must not be snapshotted.
+	require.ElementsMatch(t, []string{"test-0001-1", "test-0001-2", "test-0002-1"}, snapshotted)
+	// The IDs are returned in the order of the VMs and their volumes.
+	for i, disk := range []string{"test-0001-1", "test-0001-2", "test-0002-1"} {
+		require.Regexp(t, fmt.Sprintf(`^id-%s-\d{14}$`, disk), ids[i])
+	}
+}
+
+func TestResetStoppedInstance(t *testing.T) {
+	vms := vm.List{
+		{Name: "test-0001", Provider: ProviderName, Project: "test-project", Zone: "us-east1-b", Status: "RUNNING"},
+		{Name: "test-0002", Provider: ProviderName, Project: "test-project", Zone: "us-east1-b", Status: "TERMINATED"},
+	}
+
+	t.Run("force", func(t *testing.T) {
+		r := &fakeRunner{}
+		withFakeRunner(t, r)
+		require.NoError(t, (&Provider{}).ForceReset(nilLogger(), vms))
+		require.ElementsMatch(t, []string{
+			"compute instances reset --project test-project --zone us-east1-b test-0001",
+			"compute instances start --project test-project --zone us-east1-b test-0002",
+		}, r.Commands())
+	})
+
+	t.Run("no force", func(t *testing.T) {
+		r := &fakeRunner{}
+		withFakeRunner(t, r)
+		err := (&Provider{}).Reset(nilLogger(), vms)
+		require.ErrorContains(t, err,
+			"cannot reset test-0002: instance is TERMINATED rather than RUNNING; use --force to start it instead")
+		require.Empty(t, r.Commands())
+	})
+}
+
+func TestGracefulReset(t *testing.T) {
+	vms := vm.List{
+		{Name: "test-0001", Provider: ProviderName, Project: "test-project", Zone: "us-east1-b", Status: "RUNNING"},
+		{Name: "test-0002", Provider: ProviderName, Project: "test-project", Zone: "us-east1-b", Status: "RUNNING"},
+	}
+
+	t.Run("graceful", func(t *testing.T) {
+		r := &fakeRunner{}
+		withFakeRunner(t, r)
+		require.NoError(t, (&Provider{}).GracefulReset(nilLogger(), vms))
+		require.Equal(t, []string{
+			"compute instances stop --project test-project --zone us-east1-b test-0001 test-0002",
+			"compute instances start --project test-project --zone us-east1-b test-0001 test-0002",
+		}, r.Commands())
+	})
+
+	t.Run("default", func(t *testing.T) {
+		r := &fakeRunner{}
+		withFakeRunner(t, r)
+		require.NoError(t, (&Provider{}).Reset(nilLogger(), vms))
+		require.Equal(t, []string{
+			"compute instances reset --project test-project --zone us-east1-b test-0001 test-0002",
+		}, r.Commands())
+	})
+}
+
+func TestSuspendResume(t *testing.T) {
+	vms := vm.List{
+		{Name: "test-0001", Provider: ProviderName, Project: "test-project", Zone: "us-east1-b", MachineType: "n2-standard-4"},
+		{Name: "test-0002", Provider: ProviderName, Project: "test-project", Zone: "us-east1-b", MachineType: "n2-standard-4"},
+		{Name: "test-0003", Provider: ProviderName, Project: "test-project", Zone: "us-west1-a", MachineType: "n2-standard-4"},
+		{Name: "test-0004", Provider: ProviderName, Project: "other-project", Zone: "us-east1-b", MachineType: "n2-standard-4"},
+	}
+	expected := func(command string) []string {
+		return []string{
+			"compute instances " + command + " --project other-project --zone us-east1-b test-0004",
+			"compute instances " + command + " --project test-project --zone us-east1-b test-0001 test-0002",
+			"compute instances " + command + " --project test-project --zone us-west1-a test-0003",
+		}
+	}
+
+	t.Run("suspend", func(t *testing.T) {
+		r := &fakeRunner{}
+		withFakeRunner(t, r)
+		require.NoError(t, (&Provider{}).Suspend(nilLogger(), vms))
+		commands := r.Commands()
+		sort.Strings(commands)
+		require.Equal(t, expected("suspend"), commands)
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		r := &fakeRunner{}
+		withFakeRunner(t, r)
+		require.NoError(t, (&Provider{}).Resume(nilLogger(), vms))
+		commands := r.Commands()
+		sort.Strings(commands)
+		require.Equal(t, expected("resume"), commands)
+	})
+
+	t.Run("unsupported machine type", func(t *testing.T) {
+		r := &fakeRunner{}
+		withFakeRunner(t, r)
+		unsupported := append(vm.List{}, vms...)
+		unsupported[2].MachineType = "a2-highgpu-1g"
+		err := (&Provider{}).Suspend(nilLogger(), unsupported)
+		require.ErrorContains(t, err, "cannot suspend test-0003: machine type a2-highgpu-1g doesn't support suspending instances")
+		require.Empty(t, r.Commands())
+	})
+}
+
+func TestCreateVolumeNameCollision(t *testing.T) {
+	defer func(fn func() string) { diskNameSuffix = fn }(diskNameSuffix)
+	suffixes := []string{"aaaaaa", "bbbbbb"}
+	diskNameSuffix = func() string {
+		suffix := suffixes[0]
+		suffixes = suffixes[1:]
+		return suffix
+	}
+
+	// test-disk and test-disk-aaaaaa already exist.
+	existing := map[string]bool{"test-disk": true, "test-disk-aaaaaa": true}
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		if isListCommand(args[2:], "disks") {
+			name := strings.TrimPrefix(argValue(args, "--filter"), "name=")
+			if existing[name] {
+				return json.Marshal([]map[string]string{{"name": name}})
+			}
+			return []byte("[]"), nil
+		}
+		if args[4] == "create" {
+			if existing[args[5]] {
+				output := fmt.Sprintf("ERROR: (gcloud.compute.disks.create) Could not fetch resource:\n"+
+					" - The resource 'projects/test-project/zones/us-east1-b/disks/%s' already exists", args[5])
+				return []byte(output), errors.New("exit status 1")
+			}
+			return json.Marshal([]map[string]string{{
+				"name":   args[5],
+				"sizeGb": "10",
+				"zone":   "us-east1-b",
+			}})
+		}
+		return nil, nil
+	}}
+	withFakeRunner(t, r)
+	p := &Provider{Projects: []string{"test-project"}}
+
+	t.Run("error", func(t *testing.T) {
+		_, err := p.CreateVolume(nilLogger(), vm.VolumeCreateOpts{
+			Name: "test-disk",
+			Size: 10,
+			Zone: "us-east1-b",
+		})
+		require.ErrorContains(t, err, "already exists")
+		// Without AutoSuffixName, the collision is left to gcloud to report.
+		for _, cmd := range r.Commands() {
+			require.NotContains(t, cmd, "disks list")
+		}
+	})
+
+	t.Run("auto suffix", func(t *testing.T) {
+		volume, err := p.CreateVolume(nilLogger(), vm.VolumeCreateOpts{
+			Name:           "test-disk",
+			Size:           10,
+			Zone:           "us-east1-b",
+			AutoSuffixName: true,
+		})
+		require.NoError(t, err)
+		require.Equal(t, "test-disk-bbbbbb", volume.Name)
+		require.Contains(t, r.Commands(),
+			"compute --project test-project disks create test-disk-bbbbbb --size 10 --zone us-east1-b --format json")
+	})
+}
+
+func TestCreateHostnames(t *testing.T) {
+	withFakeRunner(t, &fakeRunner{})
+	l, logged := fileLogger(t)
+
+	providerOpts := DefaultProviderOpts()
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	providerOpts.ConfigureCreateFlags(flags)
+	require.NoError(t, flags.Parse([]string{"--gce-hostnames=test-0001=db1.example.com"}))
+	providerOpts.Zones = []string{"us-east1-b"}
+	providerOpts.DryRun = true
+
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	require.NoError(t, p.Create(l, []string{"test-0001", "test-0002", "test-0003"}, opts, providerOpts))
+	out := logged()
+	require.Regexp(t, `(?m)Dry run: gcloud compute instances create .* `+
+		`--hostname db1.example.com --zone us-east1-b test-0001$`, out)
+	require.Regexp(t, `(?m)Dry run: gcloud compute instances create .* `+
+		`--zone us-east1-b test-0002 test-0003$`, out)
+	require.NotRegexp(t, `--hostname \S+ --zone us-east1-b test-0002`, out)
+
+	fixture := `{
+  "name": "test-0001",
+  "hostname": "db1.example.com",
+  "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b",
+  "labels": {"lifetime": "12h0m0s"},
+  "scheduling": {"onHostMaintenance": "MIGRATE"}
+}`
+	var v jsonVM
+	require.NoError(t, json.Unmarshal([]byte(fixture), &v))
+	parsed := v.toVM("test-project", nil /* disks */, DefaultProviderOpts())
+	require.Equal(t, "db1.example.com", parsed.Hostname)
+	require.Equal(t, "test-0001", parsed.Name)
+
+	for _, tc := range []struct {
+		hostnames map[string]string
+		err       string
+	}{
+		{map[string]string{"test-0001": "db1"}, "must be a fully qualified domain name"},
+		{map[string]string{"test-0001": "DB1.example.com"}, "labels must be 1-63 lowercase letters"},
+		{map[string]string{"test-0001": "db1..example.com"}, "labels must be 1-63 lowercase letters"},
+		{map[string]string{"test-0001": "-db1.example.com"}, "labels must be 1-63 lowercase letters"},
+		{map[string]string{"test-0009": "db9.example.com"}, "unknown instance test-0009"},
+	} {
+		providerOpts.Hostnames = tc.hostnames
+		require.ErrorContains(t, p.Create(l, []string{"test-0001"}, opts, providerOpts), tc.err)
+	}
+}
+
+func TestListImages(t *testing.T) {
+	// Deliberately not sorted by creation time.
+	fixture := `[
+  {"name": "ubuntu-2204-jammy-v20240101", "family": "ubuntu-2204-lts", "creationTimestamp": "2024-01-01T00:00:00.000-07:00", "architecture": "X86_64"},
+  {"name": "ubuntu-2204-jammy-v20240301", "family": "ubuntu-2204-lts", "creationTimestamp": "2024-03-01T00:00:00.000-07:00", "architecture": "X86_64"},
+  {"name": "ubuntu-2204-jammy-arm64-v20240201", "family": "ubuntu-2204-lts-arm64", "creationTimestamp": "2024-02-01T00:00:00.000-07:00", "architecture": "ARM64"}
+]`
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		return []byte(fixture), nil
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	images, err := p.ListImages(nilLogger(), "" /* family */, "" /* project */)
+	require.NoError(t, err)
+	require.Len(t, images, 3)
+	require.Equal(t, "ubuntu-2204-jammy-v20240301", images[0].Name)
+	require.Equal(t, "ubuntu-2204-lts", images[0].Family)
+	require.Equal(t, "X86_64", images[0].Architecture)
+	require.True(t, images[0].CreatedAt.Equal(time.Date(2024, 3, 1, 7, 0, 0, 0, time.UTC)))
+	require.Equal(t, "ubuntu-2204-jammy-arm64-v20240201", images[1].Name)
+	require.Equal(t, "ARM64", images[1].Architecture)
+	require.Equal(t, "ubuntu-2204-jammy-v20240101", images[2].Name)
+
+	_, err = p.ListImages(nilLogger(), "ubuntu-2204-lts", "my-images")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"compute images list --project ubuntu-os-cloud " +
+			"--format json(name,family,creationTimestamp,architecture)",
+		"compute images list --project my-images --filter family=ubuntu-2204-lts " +
+			"--format json(name,family,creationTimestamp,architecture)",
+	}, r.Commands())
+}
+
+func TestPropagateDiskLabelsMultipleDataDisks(t *testing.T) {
+	var mu syncutil.Mutex
+	updated := make(map[string]string)
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		switch {
+		case isInstanceDescribe(args):
+			return instanceDisks(args[3], 2 /* dataDisks */)
+		case args[2] == "update":
+			mu.Lock()
+			defer mu.Unlock()
+			updated[args[len(args)-1]] = argValue(args, "--update-labels")
+			return nil, nil
+		case args[2] == "describe":
+			return []byte(fmt.Sprintf(`{"labels": {"cluster": "test", "disk": %q}}`, instanceDiskRole(args[3]))), nil
+		}
+		return nil, errors.Newf("unexpected command: %v", args)
+	}}
+	withFakeRunner(t, r)
+
+	require.NoError(t, propagateDiskLabels(
+		nilLogger(), "test-project", "cluster=test", map[string][]string{"us-east1-b": {"test-0001"}},
+	))
+	// The boot and data disks are told apart by their role label.
+	require.Equal(t, map[string]string{
+		"test-0001":   "cluster=test,disk=boot",
+		"test-0001-1": "cluster=test,disk=data",
+		"test-0001-2": "cluster=test,disk=data",
+	}, updated)
+	require.Contains(t, r.Commands(),
+		"compute instances describe test-0001 --project test-project --zone us-east1-b --format json(disks)")
+}
+
+func TestCreateWaitsForStartupScript(t *testing.T) {
+	defer func(opts retry.Options) { startupScriptRetryOpts = opts }(startupScriptRetryOpts)
+	startupScriptRetryOpts = retry.Options{
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	}
+
+	// The startup script sets the guest attribute polled by Create.
+	filename, err := writeStartupScript("", vm.Ext4, false, false, false)
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(filename) }()
+	script, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	require.Contains(t, string(script), "/computeMetadata/v1/instance/guest-attributes/roachprod/startup-script")
+	// It reports a failure unless it verified its result, which it only
+	// signals as done at the very end.
+	require.Contains(t, string(script), "trap 'report_status failed' EXIT")
+	require.Less(t, strings.Index(string(script), "mountpoint -q /mnt/data1"),
+		strings.Index(string(script), "report_status done"))
+	require.True(t, strings.HasSuffix(string(script), "trap - EXIT\nreport_status done\n"))
+
+	const done = `[{"namespace": "roachprod", "key": "startup-script", "value": "done"}]`
+	zones := []string{"us-east1-b"}
+	names := []string{"test-0001", "test-0002"}
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	providerOpts := DefaultProviderOpts()
+	providerOpts.Zones = zones
+	providerOpts.SkipDiskLabels = true
+
+	t.Run("completed", func(t *testing.T) {
+		var mu syncutil.Mutex
+		polls := make(map[string]int)
+		respond := createResponder(zones...)
+		r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+			if len(args) >= 4 && args[2] == "get-guest-attributes" {
+				mu.Lock()
+				defer mu.Unlock()
+				polls[args[3]]++
+				if args[3] == "test-0002" && polls[args[3]] == 1 {
+					// The guest attribute doesn't exist until the script completes.
+					return nil, errors.New("exit status 1")
+				}
+				return []byte(done), nil
+			}
+			return respond(args)
+		}}
+		withFakeRunner(t, r)
+		providerOpts.StartupScriptTimeout = time.Minute
+		require.NoError(t, p.Create(nilLogger(), names, opts, providerOpts))
+		require.Equal(t, map[string]int{"test-0001": 1, "test-0002": 2}, polls)
+		require.Contains(t, r.Commands(), "compute instances get-guest-attributes test-0001 --project test-project "+
+			"--zone us-east1-b --query-path roachprod/startup-script --format json")
+		for _, c := range r.Commands() {
+			if strings.HasPrefix(c, "compute instances create") {
+				require.Contains(t, c, "--metadata enable-guest-attributes=TRUE")
+			}
+		}
+	})
+
+	t.Run("timed out", func(t *testing.T) {
+		respond := createResponder(zones...)
+		withFakeRunner(t, &fakeRunner{respond: func(args []string) ([]byte, error) {
+			if len(args) >= 4 && args[2] == "get-guest-attributes" {
+				if args[3] == "test-0001" {
+					return []byte(done), nil
+				}
+				return []byte(`[]`), nil
+			}
+			return respond(args)
+		}})
+		providerOpts.StartupScriptTimeout = 50 * time.Millisecond
+		err := p.Create(nilLogger(), names, opts, providerOpts)
+		require.ErrorContains(t, err, "timed out after 50ms waiting for the startup script to complete on: test-0002")
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		respond := createResponder(zones...)
+		withFakeRunner(t, &fakeRunner{respond: func(args []string) ([]byte, error) {
+			if len(args) >= 4 && args[2] == "get-guest-attributes" {
+				if args[3] == "test-0001" {
+					return []byte(done), nil
+				}
+				return []byte(`[{"namespace": "roachprod", "key": "startup-script", "value": "failed"}]`), nil
+			}
+			return respond(args)
+		}})
+		// The failure is reported right away rather than once the timeout
+		// elapses.
+		providerOpts.StartupScriptTimeout = time.Hour
+		err := p.Create(nilLogger(), names, opts, providerOpts)
+		require.ErrorContains(t, err, "the startup script failed on: test-0002")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		r := &fakeRunner{respond: createResponder(zones...)}
+		withFakeRunner(t, r)
+		providerOpts.StartupScriptTimeout = 0
+		require.NoError(t, p.Create(nilLogger(), names, opts, providerOpts))
+		for _, c := range r.Commands() {
+			require.NotContains(t, c, "guest-attributes")
+		}
+	})
+}
+
+func TestRemoveLabelsByPrefix(t *testing.T) {
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		if isInstanceDescribe(args) {
+			return []byte(`{"labels": {"test_b": "2", "cluster": "test"}}`), nil
+		}
+		return nil, nil
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	vms := vm.List{
+		{
+			Name: "test-0001",
+			Zone: "us-east1-b",
+			Labels: map[string]string{
+				"test_a": "1", "test_b": "2", "cluster": "test", "lifetime": "12h0m0s",
+			},
+		},
+		// The labels of this VM weren't populated, so they're described.
+		{Name: "test-0002", Zone: "us-west1-b"},
+		// Nothing to remove.
+		{Name: "test-0003", Zone: "us-east1-b", Labels: map[string]string{"cluster": "test"}},
+	}
+	require.NoError(t, p.RemoveLabelsByPrefix(nilLogger(), vms, "test_"))
+
+	var removed []string
+	for _, c := range r.Commands() {
+		if strings.Contains(c, "remove-labels") {
+			removed = append(removed, c)
+		}
+	}
+	require.Len(t, removed, 2)
+	sort.Strings(removed)
+	require.Regexp(t, `^compute instances remove-labels test-0001 --zone us-east1-b --project test-project `+
+		`--labels=(test_a,test_b|test_b,test_a)$`, removed[0])
+	require.Equal(t, "compute instances remove-labels test-0002 --zone us-west1-b --project test-project "+
+		"--labels=test_b", removed[1])
+	require.Contains(t, r.Commands(),
+		"compute instances describe test-0002 --project test-project --zone us-west1-b --format json(labels)")
+
+	require.ErrorContains(t, p.RemoveLabelsByPrefix(nilLogger(), vms, ""), "empty prefix")
+}
+
+func TestSetLabels(t *testing.T) {
+	var mu syncutil.Mutex
+	labels := map[string]map[string]string{
+		"test-0001": {
+			"cluster": "test", "created": "2024-01-01t00_00_00z", "roachprod": "true",
+			"lifetime": "12h0m0s", "stale": "x", "team": "kv",
+		},
+		// Already in the desired state.
+		"test-0002": {
+			"cluster": "test", "created": "2024-01-01t00_00_00z", "roachprod": "true",
+			"lifetime": "24h0m0s", "team": "storage",
+		},
+	}
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		current := labels[args[3]]
+		switch args[2] {
+		case "describe":
+			return json.Marshal(map[string]interface{}{"labels": current})
+		case "add-labels", "remove-labels":
+			for _, pair := range strings.Split(strings.TrimPrefix(args[len(args)-1], "--labels="), ",") {
+				key, value, _ := strings.Cut(pair, "=")
+				if args[2] == "add-labels" {
+					current[key] = value
+				} else {
+					delete(current, key)
+				}
+			}
+		}
+		return nil, nil
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	vms := vm.List{
+		{Name: "test-0001", Zone: "us-east1-b"},
+		{Name: "test-0002", Zone: "us-east1-b"},
+	}
+	desired := map[string]string{
+		"lifetime": "24h0m0s",
+		"team":     "Storage",
+		// Protected labels are neither overwritten nor removed.
+		"cluster": "other",
+	}
+	require.NoError(t, p.SetLabels(nilLogger(), vms, desired))
+
+	expected := map[string]string{
+		"cluster": "test", "created": "2024-01-01t00_00_00z", "roachprod": "true",
+		"lifetime": "24h0m0s", "team": "storage",
+	}
+	require.Equal(t, expected, labels["test-0001"])
+	require.Equal(t, expected, labels["test-0002"])
+	for _, c := range r.Commands() {
+		require.NotContains(t, c, "test-0002 --zone", "unexpected edit of test-0002: %s", c)
+	}
+}
+
+func TestExpiredVMs(t *testing.T) {
+	fresh := timeutil.Now().Add(-time.Hour).Format(time.RFC3339)
+	fixture := fmt.Sprintf(`[
+  {
+    "name": "expired",
+    "creationTimestamp": "2024-01-01T00:00:00.000-07:00",
+    "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b",
+    "labels": {"lifetime": "12h0m0s"}
+  },
+  {
+    "name": "fresh",
+    "creationTimestamp": %q,
+    "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b",
+    "labels": {"lifetime": "12h0m0s"}
+  },
+  {
+    "name": "unlabeled",
+    "creationTimestamp": "2024-01-01T00:00:00.000-07:00",
+    "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b"
+  }
+]`, fresh)
+	withFakeRunner(t, &fakeRunner{respond: func(args []string) ([]byte, error) {
+		return []byte(fixture), nil
+	}})
+	p := &Provider{Projects: []string{"test-project"}}
+
+	vms, err := p.ExpiredVMs(nilLogger(), false /* includeUnlabeled */)
+	require.NoError(t, err)
+	require.Equal(t, []string{"expired"}, vms.Names())
+
+	vms, err = p.ExpiredVMs(nilLogger(), true /* includeUnlabeled */)
+	require.NoError(t, err)
+	require.Equal(t, []string{"expired", "unlabeled"}, vms.Names())
+}
+
+func TestCreateLocalSSDInterface(t *testing.T) {
+	withFakeRunner(t, &fakeRunner{})
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	opts.SSDOpts.UseLocalSSD = true
+
+	for _, tc := range []struct {
+		machineType string
+		flag        string
+		expected    string
+		err         string
+	}{
+		{"n2-standard-4", "", "NVME", ""},
+		{"n2-standard-4", "scsi", "SCSI", ""},
+		{"n1-standard-4", "SCSI", "SCSI", ""},
+		{"c3-standard-8", "SCSI", "", "machine type c3-standard-8 doesn't support SCSI local SSDs"},
+		{"n2-standard-4", "IDE", "", `unknown local SSD interface "IDE"`},
+	} {
+		t.Run(tc.machineType+"/"+tc.flag, func(t *testing.T) {
+			l, logged := fileLogger(t)
+			providerOpts := DefaultProviderOpts()
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			providerOpts.ConfigureCreateFlags(flags)
+			args := []string{"--gce-machine-type=" + tc.machineType, "--gce-local-ssd-count=2"}
+			if tc.flag != "" {
+				args = append(args, "--gce-local-ssd-interface="+tc.flag)
+			}
+			require.NoError(t, flags.Parse(args))
+			providerOpts.DryRun = true
+
+			err := p.Create(l, []string{"test-0001"}, opts, providerOpts)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Regexp(t, `--local-ssd interface=`+tc.expected+` --local-ssd interface=`+tc.expected+` `, logged())
+			require.NotRegexp(t, `--local-ssd interface=`+tc.expected+` --local-ssd interface=`+tc.expected+
+				` --local-ssd`, logged())
+		})
+	}
+}
+
+func TestCreateMinNodeCPUs(t *testing.T) {
+	withFakeRunner(t, &fakeRunner{})
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	opts.SSDOpts.UseLocalSSD = false
+
+	for _, tc := range []struct {
+		machineType string
+		minCPUs     int
+		err         string
+	}{
+		{"n2-custom-4-65536", 8, "custom machine type n2-custom-4-65536 has 4 vCPUs, below the minimum of 8"},
+		{"n2-custom-8-65536", 8, ""},
+		{"n2-custom-16-65536", 8, ""},
+		{"n2-custom-4-65536", 0, ""},
+		// Predefined machine types aren't checked.
+		{"n2-standard-4", 8, ""},
+		// Custom machine types of other series.
+		{"custom-8-16384", 8, ""},
+		{"custom-4-16384", 8, "custom machine type custom-4-16384 has 4 vCPUs, below the minimum of 8"},
+		{"e2-custom-4-8192", 8, "custom machine type e2-custom-4-8192 has 4 vCPUs, below the minimum of 8"},
+		{"e2-custom-8-8192", 8, ""},
+		{"n2d-custom-8-32768", 8, ""},
+		{"n2d-custom-8-32768-ext", 8, ""},
+		{"n2-custom-medium-8192", 8, "cannot determine the number of vCPUs of custom machine type n2-custom-medium-8192"},
+	} {
+		t.Run(fmt.Sprintf("%s/%d", tc.machineType, tc.minCPUs), func(t *testing.T) {
+			providerOpts := DefaultProviderOpts()
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			providerOpts.ConfigureCreateFlags(flags)
+			require.NoError(t, flags.Parse([]string{
+				"--gce-machine-type=" + tc.machineType,
+				fmt.Sprintf("--gce-min-node-cpus=%d", tc.minCPUs),
+			}))
+			providerOpts.DryRun = true
+
+			err := p.Create(nilLogger(), []string{"test-0001"}, opts, providerOpts)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestGetLabels(t *testing.T) {
+	const fixture = `{
+  "labels": {
+    "cluster": "test",
+    "created": "2024-01-02t03_04_05z",
+    "lifetime": "12h0m0s",
+    "roachprod": "true"
+  }
+}`
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		if isInstanceDescribe(args) {
+			return []byte(fixture), nil
+		}
+		return nil, nil
+	}}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	// The stale labels populated at List time are ignored.
+	v := &vm.VM{Name: "test-0001", Zone: "us-east1-b", Labels: map[string]string{"cluster": "stale"}}
+	labels, err := p.GetLabels(nilLogger(), v)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"cluster":   "test",
+		"created":   "2024-01-02t03_04_05z",
+		"lifetime":  "12h0m0s",
+		"roachprod": "true",
+	}, labels)
+	require.Equal(t, []string{
+		"compute instances describe test-0001 --project test-project --zone us-east1-b --format json(labels)",
+	}, r.Commands())
+}
+
+func TestBuildStartupScript(t *testing.T) {
+	const rsaForSSH = `PubkeyAcceptedAlgorithms +ssh-rsa`
+	for _, tc := range []struct {
+		name            string
+		extraMountOpts  string
+		fileSystem      string
+		enableRSAForSSH bool
+		expected        []string
+		unexpected      []string
+	}{
+		{
+			name:           "local ssd nobarrier",
+			extraMountOpts: "nobarrier",
+			fileSystem:     vm.Ext4,
+			expected:       []string{`mount_opts="${mount_opts},nobarrier"`},
+			unexpected:     []string{rsaForSSH},
+		},
+		{
+			name:           "persistent disk discard",
+			extraMountOpts: "discard",
+			fileSystem:     vm.Ext4,
+			expected:       []string{`mount_opts="${mount_opts},discard"`},
+			unexpected:     []string{rsaForSSH},
+		},
+		{
+			name:       "no extra mount opts",
+			fileSystem: vm.Ext4,
+			expected:   []string{`mount_opts="defaults"`},
+			unexpected: []string{`mount_opts="${mount_opts},`},
+		},
+		{
+			name:           "zfs",
+			extraMountOpts: "discard",
+			fileSystem:     vm.Zfs,
+			unexpected:     []string{`mount_opts=`},
+		},
+		{
+			name:            "rsa for ssh",
+			fileSystem:      vm.Ext4,
+			enableRSAForSSH: true,
+			expected:        []string{rsaForSSH},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			script, err := buildStartupScript(tc.extraMountOpts, tc.fileSystem, false, false, tc.enableRSAForSSH)
+			require.NoError(t, err)
+			for _, s := range tc.expected {
+				require.Contains(t, script, s)
+			}
+			for _, s := range tc.unexpected {
+				require.NotContains(t, script, s)
+			}
+		})
+	}
+
+	// The written script matches the built one.
+	filename, err := writeStartupScript("discard", vm.Ext4, false, false, true)
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(filename) }()
+	written, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	built, err := buildStartupScript("discard", vm.Ext4, false, false, true)
+	require.NoError(t, err)
+	require.Equal(t, built, string(written))
+}
+
+func TestDeleteByLabel(t *testing.T) {
+	const fixture = `[
+  {
+    "name": "leaked-0001",
+    "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b",
+    "labels": {"cluster": "leaked", "roachprod": "true"}
+  },
+  {
+    "name": "leaked-0002",
+    "zone": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-west1-b",
+    "labels": {"cluster": "leaked", "roachprod": "true"}
+  }
+]`
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		if isListCommand(args, "instances") {
+			return []byte(fixture), nil
+		}
+		return nil, nil
+	}}
+	withFakeRunner(t, r)
+	p := &Provider{Projects: []string{"test-project"}}
+
+	_, err := p.DeleteByLabel(nilLogger(), nil)
+	require.ErrorContains(t, err, "no labels specified")
+	require.Empty(t, r.Commands())
+
+	deleted, err := p.DeleteByLabel(nilLogger(), map[string]string{"roachprod": "true", "cluster": "leaked"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"leaked-0001", "leaked-0002"}, deleted.Names())
+	commands := r.Commands()
+	require.Equal(t, "compute instances list --project test-project --format json "+
+		"--filter labels.cluster=leaked AND labels.roachprod=true", commands[0])
+	require.ElementsMatch(t, []string{
+		"compute instances delete --delete-disks all --project test-project --zone us-east1-b leaked-0001",
+		"compute instances delete --delete-disks all --project test-project --zone us-west1-b leaked-0002",
+	}, commands[1:])
+}
+
+func TestDeleteMIG(t *testing.T) {
+	migInstances := []map[string]string{
+		{"instance": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/instances/test-0001"},
+		{"instance": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-c/instances/test-0002"},
+	}
+	respond := func(args []string) ([]byte, error) {
+		if len(args) > 4 && args[1] == "instance-groups" && args[3] == "list-instances" {
+			return json.Marshal(migInstances)
+		}
+		return nil, nil
+	}
+	r := &fakeRunner{respond: respond}
+	withFakeRunner(t, r)
+
+	p := &Provider{Projects: []string{"test-project"}}
+	migVM := func(name, zone string) vm.VM {
+		return vm.VM{Name: name, Provider: ProviderName, Project: "test-project", Zone: zone,
+			Labels: map[string]string{migLabel: "test"}}
+	}
+	other := vm.VM{Name: "other-0001", Provider: ProviderName, Project: "test-project", Zone: "us-east1-b"}
+	const listInstances = "compute instance-groups managed list-instances test --project test-project " +
+		"--region us-east1 --format json(instance)"
+
+	// Deleting some of the instances of the MIG deletes them via the MIG, which
+	// would recreate them otherwise.
+	require.NoError(t, p.Delete(nilLogger(), vm.List{migVM("test-0002", "us-east1-c"), other}))
+	require.ElementsMatch(t, []string{
+		listInstances,
+		"compute instance-groups managed delete-instances test --project test-project --region us-east1 " +
+			"--instances test-0002",
+		"compute instances delete --delete-disks all --project test-project --zone us-east1-b other-0001",
+	}, r.Commands())
+
+	// Deleting all of them deletes the MIG and its instance template.
+	r = &fakeRunner{respond: respond}
+	withFakeRunner(t, r)
+	require.NoError(t, p.Delete(nilLogger(), vm.List{
+		migVM("test-0001", "us-east1-b"), migVM("test-0002", "us-east1-c"),
+	}))
+	require.Equal(t, []string{
+		listInstances,
+		"compute instance-groups managed delete test --project test-project --region us-east1 --quiet",
+		"compute instance-templates delete test-template --project test-project --quiet",
+	}, r.Commands())
+}
+
+func TestLogOperations(t *testing.T) {
+	const operationJSON = `[{
+  "kind": "compute#operation",
+  "id": "4242424242424242424",
+  "name": "operation-1700000000000-60a8e0c3c5b3e-7e7f1b2c-0d5e8f9a",
+  "operationType": "delete",
+  "targetLink": "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/instances/test-0001"
+}, {
+  "kind": "compute#instance",
+  "id": "1234",
+  "name": "test-0002"
+}]`
+	r := &fakeRunner{respond: func(args []string) ([]byte, error) {
+		return []byte(operationJSON), nil
+	}}
+	withFakeRunner(t, r)
+
+	l, logged := fileLogger(t)
+	vms := vm.List{{Name: "test-0001", Provider: ProviderName, Project: "test-project", Zone: "us-east1-b"}}
+	require.NoError(t, (&Provider{}).Delete(l, vms))
+	require.Contains(t, logged(), "gcloud compute instances delete: "+
+		"operation operation-1700000000000-60a8e0c3c5b3e-7e7f1b2c-0d5e8f9a (id: 4242424242424242424, type: delete, "+
+		"target: https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/instances/test-0001)")
+	require.NotContains(t, logged(), "test-0002")
+
+	// Operations are also found in non-JSON output, e.g. of async commands.
+	require.Equal(t, []gcloudOperation{{Name: "operation-1700000000001-60a8e0c3c5b3f-1a2b3c4d-5e6f7a8b"}},
+		parseOperations([]byte("Delete in progress for instance [test-0001] "+
+			"[https://www.googleapis.com/compute/v1/projects/test-project/zones/us-east1-b/operations/"+
+			"operation-1700000000001-60a8e0c3c5b3f-1a2b3c4d-5e6f7a8b].\n")))
+	require.Empty(t, parseOperations([]byte("Deleted [test-0001].\n")))
+}
+
+func TestDeletionProtection(t *testing.T) {
+	t.Run("create", func(t *testing.T) {
+		withFakeRunner(t, &fakeRunner{})
+		p := &Provider{Projects: []string{"test-project"}}
+		opts := vm.DefaultCreateOpts()
+		opts.ClusterName = "test"
+		for _, protected := range []bool{false, true} {
+			l, logged := fileLogger(t)
+			providerOpts := DefaultProviderOpts()
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			providerOpts.ConfigureCreateFlags(flags)
+			require.NoError(t, flags.Parse([]string{fmt.Sprintf("--gce-deletion-protection=%t", protected)}))
+			providerOpts.DryRun = true
+			require.NoError(t, p.Create(l, []string{"test-0001"}, opts, providerOpts))
+			if protected {
+				require.Contains(t, logged(), " --deletion-protection ")
+			} else {
+				require.NotContains(t, logged(), "--deletion-protection")
+			}
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		const output = "ERROR: (gcloud.compute.instances.delete) Could not fetch resource:\n" +
+			" - Invalid resource usage: 'Resource cannot be deleted if it's protected against deletion.'."
+		withFakeRunner(t, &fakeRunner{respond: func(args []string) ([]byte, error) {
+			return []byte(output), errors.New("exit status 1")
+		}})
+		vms := vm.List{{Name: "test-0001", Provider: ProviderName, Project: "test-project", Zone: "us-east1-b"}}
+		err := (&Provider{}).Delete(nilLogger(), vms)
+		require.ErrorContains(t, err, "cannot delete test-0001: protected against deletion; "+
+			"disable the protection with `gcloud compute instances update --project test-project --zone us-east1-b "+
+			"--no-deletion-protection NAME` and retry")
+
+		// Other errors are passed through.
+		withFakeRunner(t, &fakeRunner{respond: func(args []string) ([]byte, error) {
+			return []byte("ERROR: quota exceeded"), errors.New("exit status 1")
+		}})
+		err = (&Provider{}).Delete(nilLogger(), vms)
+		require.ErrorContains(t, err, "Command: gcloud")
+		require.NotContains(t, err.Error(), "protected against deletion")
+	})
+}
+
+func TestSetDeletionProtection(t *testing.T) {
+	vms := vm.List{
+		{Name: "test-0001", Provider: ProviderName, Project: "test-project", Zone: "us-east1-b"},
+		{Name: "test-0002", Provider: ProviderName, Project: "test-project", Zone: "us-west1-b"},
+		{Name: "test-0003", Provider: ProviderName, Project: "test-project", Zone: "us-east1-b"},
+		{Name: "other-0001", Provider: ProviderName, Project: "other-project", Zone: "us-east1-b"},
+	}
+	for _, tc := range []struct {
+		enabled bool
+		flag    string
+	}{
+		{true, "--deletion-protection"},
+		{false, "--no-deletion-protection"},
+	} {
+		t.Run(tc.flag, func(t *testing.T) {
+			r := &fakeRunner{}
+			withFakeRunner(t, r)
+			require.NoError(t, (&Provider{}).SetDeletionProtection(nilLogger(), vms, tc.enabled))
+			require.ElementsMatch(t, []string{
+				"compute instances update test-0001 --project test-project --zone us-east1-b " + tc.flag,
+				"compute instances update test-0002 --project test-project --zone us-west1-b " + tc.flag,
+				"compute instances update test-0003 --project test-project --zone us-east1-b " + tc.flag,
+				"compute instances update other-0001 --project other-project --zone us-east1-b " + tc.flag,
+			}, r.Commands())
+			// The instances of a project and zone are updated in order.
+			var eastInstances []string
+			for _, c := range r.Commands() {
+				if strings.Contains(c, "--project test-project --zone us-east1-b") {
+					eastInstances = append(eastInstances, strings.Split(c, " ")[3])
+				}
+			}
+			require.Equal(t, []string{"test-0001", "test-0003"}, eastInstances)
+		})
+	}
+
+	require.ErrorContains(t, (&Provider{}).SetDeletionProtection(nilLogger(), vm.List{{Name: "aws-0001", Provider: "aws"}}, true),
+		"gce received VM instance from aws")
+}
+
+func TestCreateSubnets(t *testing.T) {
+	withFakeRunner(t, &fakeRunner{})
+	p := &Provider{Projects: []string{"test-project"}}
+	opts := vm.DefaultCreateOpts()
+	opts.ClusterName = "test"
+	names := []string{"test-0001", "test-0002", "test-0003"}
+
+	create := func(t *testing.T, args ...string) (string, error) {
+		l, logged := fileLogger(t)
+		providerOpts := DefaultProviderOpts()
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		providerOpts.ConfigureCreateFlags(flags)
+		require.NoError(t, flags.Parse(append([]string{"--gce-zones=us-east1-b,us-west1-b"}, args...)))
+		providerOpts.DryRun = true
+		err := p.Create(l, names, opts, providerOpts)
+		return logged(), err
+	}
+
+	t.Run("per zone", func(t *testing.T) {
+		// The region of us-east1-b and the zone us-west1-b are mapped.
+		out, err := create(t, "--gce-subnets=us-east1=east-subnet,us-west1-b=west-subnet,us-west1=unused")
+		require.NoError(t, err)
+		require.Regexp(t, `compute instances create .* --subnet east-subnet --zone us-east1-b test-0001 test-0003\n`, out)
+		require.Regexp(t, `compute instances create .* --subnet west-subnet --zone us-west1-b test-0002\n`, out)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		out, err := create(t)
+		require.NoError(t, err)
+		require.Equal(t, 2, strings.Count(out, " --subnet default "))
+	})
+
+	t.Run("missing zone", func(t *testing.T) {
+		_, err := create(t, "--gce-subnets=us-east1=east-subnet")
+		require.ErrorContains(t, err, "no subnet specified for zones us-west1-b")
+	})
+}
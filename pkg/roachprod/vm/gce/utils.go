@@ -13,10 +13,10 @@ package gce
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"strings"
 	"text/template"
 
@@ -48,6 +48,20 @@ if [ -e /mnt/data1/.roachprod-initialized ]; then
   exit 0
 fi
 
+# Report the outcome of the startup script via a guest attribute, which Create
+# may wait for (see --gce-startup-script-timeout). This fails harmlessly unless
+# guest attributes are enabled for the instance. Unless the script gets to
+# verify its result at the very end, it reports a failure.
+report_status() {
+  curl -s -X PUT --data "$1" -H "Metadata-Flavor: Google" "http://metadata.google.internal/computeMetadata/v1/instance/guest-attributes/` + startupScriptGuestAttribute + `" || true
+}
+trap 'report_status ` + startupScriptFailed + `' EXIT
+
+fail() {
+  echo "$1"
+  exit 1
+}
+
 {{ if not .Zfs }}
 mount_opts="defaults"
 {{if .ExtraMountOpts}}mount_opts="${mount_opts},{{.ExtraMountOpts}}"{{end}}
@@ -96,11 +110,11 @@ elif [ "${#disks[@]}" -eq "1" ] || [ -n "$use_multiple_disks" ]; then
     echo "Mounting ${disk} at ${mountpoint}"
     mkdir -p ${mountpoint}
 {{ if .Zfs }}
-    zpool create -f $(basename $mountpoint) -m ${mountpoint} ${disk}
+    zpool create -f $(basename $mountpoint) -m ${mountpoint} ${disk} || fail "Failed to create zpool on ${disk}"
     # NOTE: we don't need an /etc/fstab entry for ZFS. It will handle this itself.
 {{ else }}
     mkfs.ext4 -q -F ${disk}
-    mount -o ${mount_opts} ${disk} ${mountpoint}
+    mount -o ${mount_opts} ${disk} ${mountpoint} || fail "Failed to mount ${disk} at ${mountpoint}"
     echo "${d} ${mountpoint} ext4 ${mount_opts} 1 1" | tee -a /etc/fstab
     tune2fs -m 0 ${disk}
 {{ end }}
@@ -111,13 +125,13 @@ else
   echo "${#disks[@]} disks mounted, creating ${mountpoint} using RAID 0"
   mkdir -p ${mountpoint}
 {{ if .Zfs }}
-  zpool create -f $(basename $mountpoint) -m ${mountpoint} ${disks[@]}
+  zpool create -f $(basename $mountpoint) -m ${mountpoint} ${disks[@]} || fail "Failed to create zpool on ${disks[@]}"
   # NOTE: we don't need an /etc/fstab entry for ZFS. It will handle this itself.
 {{ else }}
   raiddisk="/dev/md0"
   mdadm -q --create ${raiddisk} --level=0 --raid-devices=${#disks[@]} "${disks[@]}"
   mkfs.ext4 -q -F ${raiddisk}
-  mount -o ${mount_opts} ${raiddisk} ${mountpoint}
+  mount -o ${mount_opts} ${raiddisk} ${mountpoint} || fail "Failed to mount ${raiddisk} at ${mountpoint}"
   echo "${raiddisk} ${mountpoint} ext4 ${mount_opts} 1 1" | tee -a /etc/fstab
   tune2fs -m 0 ${raiddisk}
 {{ end }}
@@ -235,19 +249,47 @@ echo "kernel.core_pattern=$CORE_PATTERN" >> /etc/sysctl.conf
 sysctl --system  # reload sysctl settings
 
 {{ if .EnableFIPS }}
-sudo ua enable fips --assume-yes
+sudo ua enable fips --assume-yes || fail "Failed to enable FIPS"
 {{ end }}
 
-sudo touch /mnt/data1/.roachprod-initialized
+# Verify the result before signaling the completion of the startup script.
+if [ "${#disks[@]}" -ne "0" ] && ! mountpoint -q /mnt/data1; then
+  fail "/mnt/data1 is not mounted"
+fi
+sudo touch /mnt/data1/.roachprod-initialized || fail "Failed to mark the instance as initialized"
+
+trap - EXIT
+report_status ` + startupScriptSucceeded + `
 `
 
-// writeStartupScript writes the startup script to a temp file.
-// Returns the path to the file.
+// writeStartupScript writes the startup script to a temp file, see
+// buildStartupScript. Returns the path to the file.
 // After use, the caller should delete the temp file.
+func writeStartupScript(
+	extraMountOpts string, fileSystem string, useMultiple bool, enableFIPS bool, enableRSAForSSH bool,
+) (string, error) {
+	script, err := buildStartupScript(extraMountOpts, fileSystem, useMultiple, enableFIPS, enableRSAForSSH)
+	if err != nil {
+		return "", err
+	}
+
+	tmpfile, err := os.CreateTemp("", "gce-startup-script")
+	if err != nil {
+		return "", err
+	}
+	defer tmpfile.Close()
+
+	if _, err := tmpfile.WriteString(script); err != nil {
+		return "", err
+	}
+	return tmpfile.Name(), nil
+}
+
+// buildStartupScript returns the text of the startup script.
 //
 // extraMountOpts, if not empty, is appended to the default mount options. It is
 // a comma-separated list of options for the "mount -o" flag.
-func writeStartupScript(
+func buildStartupScript(
 	extraMountOpts string, fileSystem string, useMultiple bool, enableFIPS bool, enableRSAForSSH bool,
 ) (string, error) {
 	type tmplParams struct {
@@ -268,17 +310,12 @@ func writeStartupScript(
 		EnableRSAForSSH:  enableRSAForSSH,
 	}
 
-	tmpfile, err := os.CreateTemp("", "gce-startup-script")
-	if err != nil {
-		return "", err
-	}
-	defer tmpfile.Close()
-
+	var script strings.Builder
 	t := template.Must(template.New("start").Parse(gceDiskStartupScriptTemplate))
-	if err := t.Execute(tmpfile, args); err != nil {
+	if err := t.Execute(&script, args); err != nil {
 		return "", err
 	}
-	return tmpfile.Name(), nil
+	return script.String(), nil
 }
 
 // SyncDNS replaces the configured DNS zone with the supplied hosts.
@@ -314,7 +351,7 @@ func SyncDNS(l *logger.Logger, vms vm.List) error {
 
 	args := []string{"--project", dnsProject, "dns", "record-sets", "import",
 		f.Name(), "-z", dnsZone, "--delete-all-existing", "--zone-file-format"}
-	cmd := exec.Command("gcloud", args...)
+	cmd := gcloudCommand(context.Background(), args...)
 	output, err := cmd.CombinedOutput()
 
 	return errors.Wrapf(err, "Command: %s\nOutput: %s\nZone file contents:\n%s", cmd, output, zoneBuilder.String())
@@ -326,7 +363,7 @@ func SyncDNS(l *logger.Logger, vms vm.List) error {
 func GetUserAuthorizedKeys(l *logger.Logger) (authorizedKeys []byte, err error) {
 	var outBuf bytes.Buffer
 	// The below command will return a stream of user:pubkey as text.
-	cmd := exec.Command("gcloud", "compute", "project-info", "describe",
+	cmd := gcloudCommand(context.Background(), "compute", "project-info", "describe",
 		"--project=cockroach-ephemeral",
 		"--format=value(commonInstanceMetadata.ssh-keys)")
 	cmd.Stderr = os.Stderr
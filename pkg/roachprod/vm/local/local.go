@@ -125,6 +125,10 @@ type Provider struct {
 	vm.DNSProvider
 }
 
+func (p *Provider) SupportsEncryptedVolumes() bool {
+	return false
+}
+
 func (p *Provider) SupportsSpotVMs() bool {
 	return false
 }
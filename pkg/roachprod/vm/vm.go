@@ -31,6 +31,8 @@ const (
 	TagCluster = "cluster"
 	// TagCreated is created time tag const, RFC3339-formatted timestamp.
 	TagCreated = "created"
+	// TagCreatedBy is the tag const for the user who created the resource.
+	TagCreatedBy = "created-by"
 	// TagLifetime is lifetime tag const.
 	TagLifetime = "lifetime"
 	// TagRoachprod is roachprod tag const, value is true & false.
@@ -89,17 +91,42 @@ func GetDefaultLabelMap(opts CreateOpts) map[string]string {
 	}
 }
 
+// IsRoachprodManaged returns whether the given VM was created by roachprod,
+// i.e. whether it carries the labels set by GetDefaultLabelMap, along with
+// TagCreated.
+func IsRoachprodManaged(v VM) bool {
+	for _, tag := range []string{TagCluster, TagLifetime, TagRoachprod, TagCreated} {
+		if _, ok := v.Labels[tag]; !ok {
+			return false
+		}
+	}
+	return v.Labels[TagRoachprod] == "true"
+}
+
 // A VM is an abstract representation of a specific machine instance.  This type is used across
 // the various cloud providers supported by roachprod.
 type VM struct {
-	Name      string    `json:"name"`
+	Name string `json:"name"`
+	// Hostname is the guest OS hostname of the VM, if it was set to a custom
+	// value at creation time; empty otherwise.
+	Hostname  string    `json:"hostname,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	// If non-empty, indicates that some or all of the data in the VM instance
 	// is not present or otherwise invalid.
-	Errors      []error           `json:"errors"`
-	Lifetime    time.Duration     `json:"lifetime"`
-	Preemptible bool              `json:"preemptible"`
-	Labels      map[string]string `json:"labels"`
+	Errors      []error       `json:"errors"`
+	Lifetime    time.Duration `json:"lifetime"`
+	Preemptible bool          `json:"preemptible"`
+	// ProvisioningModel is the provider-specific provisioning model of the VM,
+	// if any; e.g. on GCE, it distinguishes spot from legacy preemptible
+	// instances.
+	ProvisioningModel string            `json:"provisioning_model,omitempty"`
+	Labels            map[string]string `json:"labels"`
+	// Status is the provider-specific status of the VM, if known; e.g. on
+	// GCE, RUNNING or TERMINATED.
+	Status string `json:"status,omitempty"`
+	// InstanceTerminationAction is the provider-specific action taken when the
+	// VM is preempted, if any; e.g. on GCE, STOP or DELETE for spot instances.
+	InstanceTerminationAction string `json:"instance_termination_action,omitempty"`
 	// The provider-internal DNS name for the VM instance
 	DNS string `json:"dns"`
 
@@ -127,7 +154,9 @@ type VM struct {
 	CPUArch CPUArch `json:"cpu_architecture"`
 	// When available, 'Haswell', 'Skylake', etc.
 	CPUFamily string `json:"cpu_family"`
-	Zone      string `json:"zone"`
+	// Accelerators are the accelerators (e.g. GPUs) attached to the VM.
+	Accelerators []Accelerator `json:"accelerators,omitempty"`
+	Zone         string        `json:"zone"`
 	// Project represents the project to which this vm belongs, if the VM is in a
 	// cloud that supports project (i.e. GCE). Empty otherwise.
 	Project string `json:"project"`
@@ -151,6 +180,26 @@ type VM struct {
 	EmptyCluster bool
 }
 
+// Accelerator describes accelerators (e.g. GPUs) of a given type attached to
+// a VM.
+type Accelerator struct {
+	// Type is the provider-specific accelerator type, e.g. nvidia-tesla-t4.
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// Image describes a provider image which VMs can be created from.
+type Image struct {
+	Name string `json:"name"`
+	// Family is the provider-specific image family, if any, e.g.
+	// ubuntu-2204-lts on GCE.
+	Family    string    `json:"family"`
+	CreatedAt time.Time `json:"created_at"`
+	// Architecture is the provider-specific CPU architecture of the image,
+	// e.g. X86_64 or ARM64 on GCE.
+	Architecture string `json:"architecture"`
+}
+
 // Name generates the name for the i'th node in a cluster.
 func Name(cluster string, idx int) string {
 	return fmt.Sprintf("%s-%0.4d", cluster, idx)
@@ -343,6 +392,14 @@ type ProviderOpts interface {
 type VolumeSnapshot struct {
 	ID   string
 	Name string
+	// CreationTimestamp is the time at which the snapshot was created, which
+	// allows garbage collecting snapshots by age. It is zero if the provider
+	// doesn't report it.
+	CreationTimestamp time.Time
+	// SizeGB is the size of the snapshotted volume, if reported.
+	SizeGB int
+	// Labels are the labels of the snapshot, if reported.
+	Labels map[string]string
 }
 
 type VolumeSnapshots []VolumeSnapshot
@@ -387,6 +444,18 @@ type VolumeSnapshotListOpts struct {
 	CreatedBefore time.Time
 }
 
+// Quota is the limit and current usage of a cloud provider resource quota,
+// e.g. the number of CPUs in a region.
+type Quota struct {
+	Limit float64
+	Usage float64
+}
+
+// Remaining returns the quota which is still available.
+func (q Quota) Remaining() float64 {
+	return q.Limit - q.Usage
+}
+
 // Volume is an abstract representation of a specific volume/disks. This type is
 // used across various cloud providers supported by roachprod, and can typically
 // be snapshotted or attached, detached, mounted from existing VMs.
@@ -398,6 +467,17 @@ type Volume struct {
 	Name               string
 	Labels             map[string]string
 	Size               int
+	// IOPS and Throughput (in MiB/s) are the performance provisioned for the
+	// volume, if any. Zero if the volume type doesn't support provisioning
+	// or the information isn't available.
+	IOPS       int
+	Throughput int
+	// Interface is the interface through which the volume is attached to its
+	// VM, e.g. NVME or SCSI, if known.
+	Interface string
+	// PhysicalBlockSizeBytes is the physical block size of the volume, e.g.
+	// 4096 or 16384, if known.
+	PhysicalBlockSizeBytes int
 }
 
 // VolumeCreateOpts groups input callers can provide when creating volumes.
@@ -410,14 +490,29 @@ type VolumeCreateOpts struct {
 	Size             int
 	Type             string
 	SourceSnapshotID string
-	Zone             string
-	Labels           map[string]string
+	// InheritSnapshotLabels, if set, copies the labels of the source snapshot
+	// onto the volume, merged with Labels, which take precedence.
+	InheritSnapshotLabels bool
+	Zone                  string
+	Labels                map[string]string
+	// TargetVM, if set, is the VM the volume is meant to be attached to. If
+	// Zone is empty, it is inherited from the target VM.
+	TargetVM *VM
+	// AutoSuffixName, if set, appends a random token to Name if a disk with
+	// that name already exists, rather than failing.
+	AutoSuffixName bool
+	// SkipDefaultLabels, if set, doesn't label the volume with the creation
+	// time (TagCreated) and the creating user (TagCreatedBy).
+	SkipDefaultLabels bool
 }
 
 type ListOptions struct {
 	IncludeVolumes       bool
 	IncludeEmptyClusters bool
 	ComputeEstimatedCost bool
+	// OnlyRoachprodManaged restricts the listed VMs to those created by
+	// roachprod; see IsRoachprodManaged.
+	OnlyRoachprodManaged bool
 }
 
 type PreemptedVM struct {
@@ -476,6 +571,9 @@ type Provider interface {
 	ListVolumeSnapshots(l *logger.Logger, vslo VolumeSnapshotListOpts) ([]VolumeSnapshot, error)
 	// DeleteVolumeSnapshots permanently deletes the given snapshots.
 	DeleteVolumeSnapshots(l *logger.Logger, snapshot ...VolumeSnapshot) error
+	// SupportsEncryptedVolumes returns if the provider supports creating
+	// encrypted volumes, i.e. VolumeCreateOpts.Encrypted.
+	SupportsEncryptedVolumes() bool
 
 	// SpotVM related APIs.
 
@@ -486,6 +584,13 @@ type Provider interface {
 	GetPreemptedSpotVMs(l *logger.Logger, vms List, since time.Time) ([]PreemptedVM, error)
 }
 
+// ForceReset is an optional capability for a Provider which can reset VMs
+// regardless of their state, e.g. by starting the ones which are stopped
+// rather than failing to reset them.
+type ForceReset interface {
+	ForceReset(l *logger.Logger, vms List) error
+}
+
 // DeleteCluster is an optional capability for a Provider which can
 // destroy an entire cluster in a single operation.
 type DeleteCluster interface {
@@ -673,6 +778,80 @@ func ExpandZonesFlag(zoneFlag []string) (zones []string, err error) {
 	return zones, nil
 }
 
+// ParseWeightedZonesFlag takes a slice of strings which may be of the format
+// zone:W, where W is the positive weight of the zone, and returns the zones
+// along with their weights. Zones without a weight are given a weight of 1.
+// For example ["us-east1-b:3", "us-west1-b"] will return
+// ["us-east1-b", "us-west1-b"] and [3, 1].
+func ParseWeightedZonesFlag(zoneFlag []string) (zones []string, weights []int, err error) {
+	seen := make(map[string]struct{}, len(zoneFlag))
+	for _, zone := range zoneFlag {
+		weight := 1
+		if colonIdx := strings.Index(zone, ":"); colonIdx != -1 {
+			weight, err = strconv.Atoi(zone[colonIdx+1:])
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "failed to parse %q", zone)
+			}
+			if weight < 1 {
+				return nil, nil, errors.Newf("weight of %q must be positive", zone)
+			}
+			zone = zone[:colonIdx]
+		}
+		if _, ok := seen[zone]; ok {
+			return nil, nil, errors.Newf("zone %s specified more than once", zone)
+		}
+		seen[zone] = struct{}{}
+		zones = append(zones, zone)
+		weights = append(weights, weight)
+	}
+	return zones, weights, nil
+}
+
+// WeightedZonePlacement allocates zones to numNodes in proportion to the
+// given weights, in groups in the same order as the zones. Each zone is
+// allocated its share of the nodes rounded down, and the remaining nodes are
+// allocated to the zones with the largest remainders (the earliest zones
+// first, on ties). The returned slice has a length of numNodes where each
+// value is in [0, len(weights)).
+//
+// For example:
+//
+//	WeightedZonePlacement([]int{3, 1}, 8) = []int{0, 0, 0, 0, 0, 0, 1, 1}
+func WeightedZonePlacement(weights []int, numNodes int) (nodeZones []int) {
+	if len(weights) < 1 {
+		panic("expected 1 or more zones")
+	}
+	var total int
+	for _, w := range weights {
+		total += w
+	}
+	counts := make([]int, len(weights))
+	remainders := make([]int, len(weights))
+	allocated := 0
+	for i, w := range weights {
+		counts[i] = numNodes * w / total
+		remainders[i] = numNodes * w % total
+		allocated += counts[i]
+	}
+	byRemainder := make([]int, len(weights))
+	for i := range byRemainder {
+		byRemainder[i] = i
+	}
+	sort.SliceStable(byRemainder, func(i, j int) bool {
+		return remainders[byRemainder[i]] > remainders[byRemainder[j]]
+	})
+	for _, i := range byRemainder[:numNodes-allocated] {
+		counts[i]++
+	}
+	nodeZones = make([]int, 0, numNodes)
+	for zone, count := range counts {
+		for i := 0; i < count; i++ {
+			nodeZones = append(nodeZones, zone)
+		}
+	}
+	return nodeZones
+}
+
 // DNSSafeAccount takes a string and returns a cleaned version of the string that can be used in DNS entries.
 // Unsafe characters are dropped. No length check is performed.
 func DNSSafeAccount(account string) string {
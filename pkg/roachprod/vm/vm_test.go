@@ -71,6 +71,85 @@ func TestExpandZonesFlag(t *testing.T) {
 	}
 }
 
+func TestParseWeightedZonesFlag(t *testing.T) {
+	for i, c := range []struct {
+		input   []string
+		zones   []string
+		weights []int
+		expErr  string
+	}{
+		{
+			input:   []string{"us-east1-b:3", "us-west1-b:1"},
+			zones:   []string{"us-east1-b", "us-west1-b"},
+			weights: []int{3, 1},
+		},
+		{
+			input:   []string{"us-east1-b:3", "us-west1-b"},
+			zones:   []string{"us-east1-b", "us-west1-b"},
+			weights: []int{3, 1},
+		},
+		{
+			input:   []string{"us-east1-b", "us-west1-b"},
+			zones:   []string{"us-east1-b", "us-west1-b"},
+			weights: []int{1, 1},
+		},
+		{
+			input:  []string{"us-east1-b", "us-west1-b:a2"},
+			expErr: "failed to parse",
+		},
+		{
+			input:  []string{"us-east1-b:0"},
+			expErr: "must be positive",
+		},
+		{
+			input:  []string{"us-east1-b:2", "us-east1-b:1"},
+			expErr: "specified more than once",
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			zones, weights, err := ParseWeightedZonesFlag(c.input)
+			if c.expErr != "" {
+				if assert.Error(t, err) {
+					assert.Regexp(t, c.expErr, err.Error())
+				}
+			} else {
+				assert.EqualValues(t, c.zones, zones)
+				assert.EqualValues(t, c.weights, weights)
+			}
+		})
+	}
+}
+
+func TestWeightedZonePlacement(t *testing.T) {
+	for i, c := range []struct {
+		weights  []int
+		numNodes int
+		expected []int // number of nodes per zone
+	}{
+		{[]int{1}, 3, []int{3}},
+		{[]int{3, 1}, 4, []int{3, 1}},
+		{[]int{3, 1}, 8, []int{6, 2}},
+		{[]int{3, 1}, 9, []int{7, 2}},
+		{[]int{1, 1, 1}, 8, []int{3, 3, 2}},
+		{[]int{2, 1}, 2, []int{1, 1}},
+		{[]int{5, 3, 2}, 10, []int{5, 3, 2}},
+		{[]int{1, 1, 1, 1}, 2, []int{1, 1, 0, 0}},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			nodeZones := WeightedZonePlacement(c.weights, c.numNodes)
+			assert.Len(t, nodeZones, c.numNodes)
+			counts := make([]int, len(c.weights))
+			for j, zone := range nodeZones {
+				counts[zone]++
+				if j > 0 {
+					assert.GreaterOrEqual(t, zone, nodeZones[j-1], "nodes should be grouped by zone")
+				}
+			}
+			assert.EqualValues(t, c.expected, counts)
+		})
+	}
+}
+
 func TestVM_ZoneEntry(t *testing.T) {
 	cases := []struct {
 		description string
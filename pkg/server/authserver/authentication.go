@@ -102,6 +102,17 @@ var WebSessionTimeout = settings.RegisterDurationSetting(
 	settings.WithName("server.web_session.timeout"),
 	settings.WithPublic)
 
+// WebSessionMaxLifetime is the cluster setting capping the validity of web
+// sessions, including the ones created via `cockroach auth-session login`.
+var WebSessionMaxLifetime = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"server.web_session.max_lifetime",
+	"the maximum duration that a newly created web session can be valid, "+
+		"including the sessions created via 'cockroach auth-session login' (0 = unlimited)",
+	0,
+	settings.NonNegativeDuration,
+	settings.WithPublic)
+
 type authenticationServer struct {
 	cfg       *base.Config
 	sqlServer SQLServerInterface
@@ -387,10 +398,7 @@ WHERE id = $1`
 		return false, "", nil
 	}
 
-	hasher := sha256.New()
-	_, _ = hasher.Write(cookie.Secret)
-	hashedCookieSecret := hasher.Sum(nil)
-	if !bytes.Equal(hashedSecret, hashedCookieSecret) {
+	if !bytes.Equal(hashedSecret, HashAuthSecret(cookie.Secret)) {
 		return false, "", nil
 	}
 
@@ -449,10 +457,15 @@ func CreateAuthSecret() (secret, hashedSecret []byte, err error) {
 		return nil, nil, err
 	}
 
+	return secret, HashAuthSecret(secret), nil
+}
+
+// HashAuthSecret computes the hash of a session secret, as stored in
+// the hashedSecret column of system.web_sessions.
+func HashAuthSecret(secret []byte) []byte {
 	hasher := sha256.New()
 	_, _ = hasher.Write(secret)
-	hashedSecret = hasher.Sum(nil)
-	return secret, hashedSecret, nil
+	return hasher.Sum(nil)
 }
 
 // NewAuthSession attempts to create a new authentication session for
@@ -471,7 +484,11 @@ func (s *authenticationServer) NewAuthSession(
 		return 0, nil, err
 	}
 
-	expiration := s.sqlServer.ExecutorConfig().Clock.PhysicalTime().Add(WebSessionTimeout.Get(&st.SV))
+	validity := WebSessionTimeout.Get(&st.SV)
+	if maxLifetime := WebSessionMaxLifetime.Get(&st.SV); maxLifetime > 0 && validity > maxLifetime {
+		validity = maxLifetime
+	}
+	expiration := s.sqlServer.ExecutorConfig().Clock.PhysicalTime().Add(validity)
 
 	insertSessionStmt := `
 INSERT INTO system.web_sessions ("hashedSecret", username, "expiresAt", user_id)
@@ -588,7 +605,9 @@ func (am *authenticationMux) getSession(
 	return username, cookie, nil
 }
 
-func decodeSessionCookie(encodedCookie *http.Cookie) (*serverpb.SessionCookie, error) {
+// DecodeSessionCookie decodes a SessionCookie proto from an http.Cookie.
+// It is the counterpart of EncodeSessionCookie.
+func DecodeSessionCookie(encodedCookie *http.Cookie) (*serverpb.SessionCookie, error) {
 	// Cookie value should be a base64 encoded protobuf.
 	cookieBytes, err := base64.StdEncoding.DecodeString(encodedCookie.Value)
 	if err != nil {
@@ -97,7 +97,7 @@ func FindAndDecodeSessionCookie(
 		if mtSessionVal != "" {
 			cookie.Value = mtSessionVal
 		}
-		sessionCookie, err = decodeSessionCookie(cookie)
+		sessionCookie, err = DecodeSessionCookie(cookie)
 		if err != nil {
 			// Multiple cookies with the same name may be included in the
 			// header. We continue searching even if we find a matching